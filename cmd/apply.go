@@ -0,0 +1,238 @@
+package cmd
+
+import (
+	"context"
+	"crypto/sha1"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"time"
+
+	"cuelang.org/go/cue"
+	"cuelang.org/go/cue/build"
+	"github.com/TangoGroup/stx/stx"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/cloudformation"
+	"github.com/spf13/cobra"
+)
+
+// deployPlan is the file written by deploy --plan-out and read by apply
+type deployPlan struct {
+	Created time.Time
+	Stacks  []plannedStack
+}
+
+// plannedStack is a change set that was created and described, but not executed
+type plannedStack struct {
+	Name, Profile, Region                      string
+	ChangeSetName, ChangeSetArn, ChangeSetType string
+	TemplateSha1                               string
+	Changes                                    [][]string // rows of the change table
+}
+
+// plan collects the change sets created by deploy --plan-out
+var plan deployPlan
+
+// addToPlan records a prepared deployment in the plan instead of executing it
+func addToPlan(d *stackDeployment) {
+	plan.Stacks = append(plan.Stacks, plannedStack{
+		Name:          d.stack.Name,
+		Profile:       d.stack.Profile,
+		Region:        d.stack.Region,
+		ChangeSetName: d.changeSetName,
+		ChangeSetArn:  d.changeSetArn,
+		ChangeSetType: d.changeSetType,
+		TemplateSha1:  d.templateSha1,
+		Changes:       changeRows(d.changes),
+	})
+	recordResult(d.stack, outcomePlanned, d.changeSetName)
+	d.log.Infof("%s %s %s %s\n", au.White("Planned"), au.BrightBlue(d.changeSetName), au.White("⤏"), au.Magenta(d.stack.Name))
+}
+
+// writePlan writes the plan as json
+func writePlan(fileName string) error {
+	plan.Created = time.Now().UTC()
+	planBytes, marshalErr := json.MarshalIndent(plan, "", "  ")
+	if marshalErr != nil {
+		return marshalErr
+	}
+	return ioutil.WriteFile(fileName, planBytes, 0644)
+}
+
+func init() {
+	rootCmd.AddCommand(applyCmd)
+	applyCmd.Flags().BoolVarP(&flags.DeployWait, "wait", "w", false, "Wait for stack updates to complete before continuing.")
+	applyCmd.Flags().BoolVarP(&flags.DeploySave, "save", "s", false, "Save stack outputs upon successful completion. Implies --wait.")
+	applyCmd.Flags().BoolVarP(&flags.DeployYes, "yes", "y", false, "Execute the planned change sets without prompting.")
+}
+
+// applyCmd represents the apply command
+var applyCmd = &cobra.Command{
+	Use:   "apply <plan.json> [cue files]",
+	Short: "Executes the change sets in a plan written by deploy --plan-out.",
+	Long: `Apply executes the change sets recorded by stx deploy --plan-out, in the
+order they were planned.
+
+Before anything is executed, apply checks every stack in the plan:
+
+  - the cue files are evaluated and exported again, and the template must
+    have the same sha1 as when the plan was written
+  - the stack's profile and region must be unchanged
+  - the change set must still exist with status CREATE_COMPLETE and be
+    available to execute
+
+If any check fails, nothing is executed. Run deploy --plan-out again to create
+a new plan.
+
+The planned changes are displayed, along with any StackPolicy or
+EnableTerminationProtection that differs from the stack, and each change set is
+confirmed before it is executed; the settings are applied with it. Use --yes to
+execute without prompting; since the plan has already been reviewed,
+Cmd:Deploy:AutoApprove:Policy is not applied.
+
+Interrupts are handled as in deploy, except that the planned change sets are
+kept, since apply did not create them.
+
+Example:
+  stx deploy --plan-out plan.json ./prod/...
+  stx apply plan.json ./prod/...
+`,
+	Args: cobra.MinimumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		defer log.Flush()
+
+		planBytes, readErr := ioutil.ReadFile(args[0])
+		if readErr != nil {
+			log.Fatal(readErr)
+		}
+		var applyPlan deployPlan
+		if unmarshalErr := json.Unmarshal(planBytes, &applyPlan); unmarshalErr != nil {
+			log.Fatalf("Unable to read plan %s: %s\n", args[0], unmarshalErr)
+		}
+		if len(applyPlan.Stacks) < 1 {
+			log.Info("Plan has no change sets.")
+			return
+		}
+
+		if sessionErr := stx.EnsureSession(config); sessionErr != nil {
+			log.Fatal(sessionErr)
+		}
+
+		// evaluate the cue files to find the planned stacks
+		availableStacks := make(map[string]deployArgs)
+		buildInstances := stx.GetBuildInstances(args[1:], config.PackageName)
+		stx.Process(buildInstances, flags, log, func(buildInstance *build.Instance, cueInstance *cue.Instance) {
+			stacksIterator, stacksIteratorErr := stx.NewStacksIterator(cueInstance, flags, log)
+			if stacksIteratorErr != nil {
+				log.Fatal(stacksIteratorErr)
+			}
+			for stacksIterator.Next() {
+				stackValue := stacksIterator.Value()
+				var stack stx.Stack
+				decodeErr := stackValue.Decode(&stack)
+				if decodeErr != nil {
+					log.Error(decodeErr)
+					continue
+				}
+				availableStacks[stack.Name] = deployArgs{stack: stack, buildInstance: buildInstance, stackValue: stackValue}
+			}
+		})
+
+		ctx, cleanedUp, stopInterrupts := handleInterrupts()
+		defer stopInterrupts()
+
+		var deployments []*stackDeployment
+		for _, planned := range applyPlan.Stacks {
+			d, verifyErr := verifyPlannedStack(ctx, planned, availableStacks)
+			if verifyErr != nil {
+				log.Errorf("%s %s\n", au.Magenta(planned.Name), verifyErr)
+				continue
+			}
+			deployments = append(deployments, d)
+		}
+		if len(deployments) < len(applyPlan.Stacks) {
+			log.Fatal("Plan is out of date; nothing was executed. Run deploy --plan-out again.")
+		}
+
+		for i, d := range deployments {
+			if ctx.Err() != nil {
+				break
+			}
+			if len(applyPlan.Stacks[i].Changes) > 0 {
+				renderChanges(os.Stdout, applyPlan.Stacks[i].Changes)
+			}
+			printSettingsDiffs(d.log, d.stack.Name, d.settingsDiffs)
+			if !flags.DeployYes && !d.approve() {
+				recordResult(d.stack, outcomeCancelled, "not approved; the change set was kept")
+				continue
+			}
+			d.execute()
+		}
+		waitForInterrupt(ctx, cleanedUp)
+		printDeployResults()
+	},
+}
+
+// verifyPlannedStack checks that the planned change set can still be executed as reviewed, and returns a deployment to execute it
+func verifyPlannedStack(ctx context.Context, planned plannedStack, availableStacks map[string]deployArgs) (*stackDeployment, error) {
+	dplArgs, ok := availableStacks[planned.Name]
+	if !ok {
+		return nil, fmt.Errorf("was not found among the evaluated stacks")
+	}
+	stack := dplArgs.stack
+	if stack.Profile != planned.Profile || stack.Region != planned.Region {
+		return nil, fmt.Errorf("was planned for %s:%s but is now %s:%s", planned.Profile, planned.Region, stack.Profile, stack.Region)
+	}
+
+	// the artifacts were uploaded when the plan was made, so only their locations are needed to compare templates
+	fileName, saveErr := saveStackAsYml(log, stack, dplArgs.buildInstance, dplArgs.stackValue, true)
+	if saveErr != nil {
+		return nil, saveErr
+	}
+	templateFileBytes, readErr := ioutil.ReadFile(fileName)
+	if readErr != nil {
+		return nil, readErr
+	}
+	templateSha1 := fmt.Sprintf("%x", sha1.Sum(templateFileBytes))
+	if templateSha1 != planned.TemplateSha1 {
+		return nil, fmt.Errorf("template has changed since it was planned (sha1 %s, planned %s)", templateSha1, planned.TemplateSha1)
+	}
+
+	session, sessionErr := stx.GetStackSession(stack)
+	if sessionErr != nil {
+		return nil, sessionErr
+	}
+	if accountErr := stx.VerifyAccount(session, stack); accountErr != nil {
+		return nil, accountErr
+	}
+	cfn := cloudformation.New(session, aws.NewConfig().WithRegion(stack.Region))
+
+	describeChangeSetOutput, describeErr := cfn.DescribeChangeSetWithContext(ctx, &cloudformation.DescribeChangeSetInput{
+		ChangeSetName: aws.String(planned.ChangeSetArn),
+		StackName:     aws.String(stack.Name),
+	})
+	if describeErr != nil {
+		return nil, fmt.Errorf("change set %s: %s", planned.ChangeSetName, describeErr)
+	}
+	status, executionStatus := aws.StringValue(describeChangeSetOutput.Status), aws.StringValue(describeChangeSetOutput.ExecutionStatus)
+	if status != "CREATE_COMPLETE" || executionStatus != "AVAILABLE" {
+		return nil, fmt.Errorf("change set %s has status %s and execution status %s", planned.ChangeSetName, status, executionStatus)
+	}
+
+	d := &stackDeployment{
+		ctx:           ctx,
+		deployArgs:    dplArgs,
+		log:           log,
+		cfn:           cfn,
+		changeSetName: planned.ChangeSetName,
+		changeSetArn:  planned.ChangeSetArn,
+		changeSetType: planned.ChangeSetType,
+		templateSha1:  planned.TemplateSha1,
+		changes:       describeChangeSetOutput.Changes,
+	}
+	if settingsErr := d.loadSettingsDiffs(); settingsErr != nil {
+		return nil, settingsErr
+	}
+	return d, nil
+}
@@ -0,0 +1,344 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/user"
+	"regexp"
+	"strings"
+	"time"
+
+	"cuelang.org/go/cue"
+	"cuelang.org/go/cue/build"
+	"github.com/TangoGroup/stx/stx"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/cloudformation"
+	"github.com/olekukonko/tablewriter"
+	"github.com/spf13/cobra"
+)
+
+// stxChangeSetName matches the names deploy gives change sets, capturing the owner
+var stxChangeSetName = regexp.MustCompile(`^stx-dpl-(.+)-[0-9a-f]{40}$`)
+
+func init() {
+	rootCmd.AddCommand(changeSetsCmd)
+	changeSetsCmd.AddCommand(changeSetsListCmd, changeSetsDescribeCmd, changeSetsExecuteCmd, changeSetsCleanCmd)
+
+	changeSetsExecuteCmd.Flags().BoolVarP(&flags.DeployWait, "wait", "w", false, "Wait for the stack update to complete.")
+	changeSetsExecuteCmd.Flags().BoolVarP(&flags.DeploySave, "save", "s", false, "Save stack outputs upon successful completion. Implies --wait.")
+	changeSetsExecuteCmd.Flags().BoolVarP(&flags.DeployYes, "yes", "y", false, "Execute without prompting.")
+
+	changeSetsCleanCmd.Flags().DurationVar(&flags.ChangeSetsOlderThan, "older-than", 24*time.Hour, "Only delete change sets created longer ago than this.")
+	changeSetsCleanCmd.Flags().BoolVar(&flags.ChangeSetsMine, "mine", false, "Only delete change sets created by the current user.")
+	changeSetsCleanCmd.Flags().BoolVarP(&flags.DeployYes, "yes", "y", false, "Delete without prompting.")
+}
+
+// changeSetsCmd represents the changesets command
+var changeSetsCmd = &cobra.Command{
+	Use:   "changesets",
+	Short: "Lists, describes, executes, and cleans up change sets of the evaluated stacks.",
+	Long: `Changesets operates on every stack found in the evaluated cue files.
+
+Deploy names change sets stx-dpl-<user>-<sha1 of template>. Change sets that
+were never executed or deleted stay on the stack; use these commands to find
+and remove them.
+
+Examples:
+  stx changesets list
+  stx changesets describe stx-dpl-alice-3f2c... ./dev/...
+  stx changesets clean --older-than 72h --mine
+`,
+}
+
+var changeSetsListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "Lists the change sets of each stack with their owner, status, and age.",
+	Run: func(cmd *cobra.Command, args []string) {
+		defer log.Flush()
+
+		table := tablewriter.NewWriter(os.Stdout)
+		table.SetAutoWrapText(false)
+		table.SetHeader([]string{"Stack", "Change set", "Owner", "Status", "Execution", "Age"})
+		table.SetHeaderColor(tablewriter.Colors{tablewriter.FgWhiteColor}, tablewriter.Colors{tablewriter.FgWhiteColor}, tablewriter.Colors{tablewriter.FgWhiteColor}, tablewriter.Colors{tablewriter.FgWhiteColor}, tablewriter.Colors{tablewriter.FgWhiteColor}, tablewriter.Colors{tablewriter.FgWhiteColor})
+
+		forEachStackChangeSets(args, func(stack stx.Stack, cfn *cloudformation.CloudFormation, summaries []*cloudformation.ChangeSetSummary) {
+			for _, summary := range summaries {
+				status := aws.StringValue(summary.Status)
+				if strings.Contains(status, "FAIL") {
+					status = au.Red(status).String()
+				} else if strings.Contains(status, "COMPLETE") {
+					status = au.BrightGreen(status).String()
+				}
+				table.Append([]string{
+					stack.Name,
+					aws.StringValue(summary.ChangeSetName),
+					changeSetOwner(aws.StringValue(summary.ChangeSetName)),
+					status,
+					aws.StringValue(summary.ExecutionStatus),
+					changeSetAge(summary),
+				})
+			}
+		})
+
+		if table.NumLines() > 0 {
+			table.Render()
+		} else {
+			log.Info("No change sets found.")
+		}
+	},
+}
+
+var changeSetsDescribeCmd = &cobra.Command{
+	Use:   "describe <change set> [cue files]",
+	Short: "Renders the changes of a change set as deploy does.",
+	Args:  cobra.MinimumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		defer log.Flush()
+
+		found := false
+		forEachChangeSet(args[0], args[1:], func(d *stackDeployment, changeSet *cloudformation.DescribeChangeSetOutput) {
+			found = true
+			log.Infof("%s %s %s %s:%s %s\n", au.White("Describing"), au.BrightBlue(d.changeSetName), au.White("⤎"), au.Magenta(d.stack.Name), au.Cyan(d.stack.Region), au.Gray(11, aws.StringValue(changeSet.Status)))
+			if reason := aws.StringValue(changeSet.StatusReason); reason != "" {
+				log.Info(au.Gray(11, reason))
+			}
+			if len(changeSet.Changes) > 0 {
+				renderChanges(os.Stdout, changeRows(changeSet.Changes))
+			}
+		})
+		if !found {
+			log.Fatalf("Change set %s was not found on the evaluated stacks.\n", args[0])
+		}
+	},
+}
+
+var changeSetsExecuteCmd = &cobra.Command{
+	Use:   "execute <change set> [cue files]",
+	Short: "Executes a change set after showing its changes.",
+	Long: `Execute finds the named change set on the evaluated stacks, renders its
+changes and any StackPolicy or EnableTerminationProtection that differs from the
+stack, and executes it with those settings once confirmed. Use --yes to execute
+without prompting; Cmd:Deploy:AutoApprove:Policy is applied as it is for deploy
+--yes.
+With --wait or --save, events are streamed and failures reported as in deploy.
+`,
+	Args: cobra.MinimumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		defer log.Flush()
+
+		ctx, cleanedUp, stopInterrupts := handleInterrupts()
+		defer stopInterrupts()
+
+		found := false
+		forEachChangeSet(args[0], args[1:], func(d *stackDeployment, changeSet *cloudformation.DescribeChangeSetOutput) {
+			found = true
+			if ctx.Err() != nil {
+				return
+			}
+			d.ctx = ctx
+			if aws.StringValue(changeSet.Status) != "CREATE_COMPLETE" || aws.StringValue(changeSet.ExecutionStatus) != "AVAILABLE" {
+				log.Errorf("%s %s has status %s and execution status %s\n", au.Magenta(d.stack.Name), d.changeSetName, aws.StringValue(changeSet.Status), aws.StringValue(changeSet.ExecutionStatus))
+				return
+			}
+
+			d.changes = changeSet.Changes
+			if len(changeSet.Changes) > 0 {
+				renderChanges(os.Stdout, changeRows(changeSet.Changes))
+			}
+			if settingsErr := d.loadSettingsDiffs(); settingsErr != nil {
+				log.Errorf("%s %s\n", au.Magenta(d.stack.Name), settingsErr)
+				return
+			}
+			printSettingsDiffs(d.log, d.stack.Name, d.settingsDiffs)
+			if !d.approve() {
+				recordResult(d.stack, outcomeCancelled, "not approved; the change set was kept")
+				return
+			}
+			d.execute()
+		})
+		if !found {
+			log.Fatalf("Change set %s was not found on the evaluated stacks.\n", args[0])
+		}
+		waitForInterrupt(ctx, cleanedUp)
+		printDeployResults()
+	},
+}
+
+var changeSetsCleanCmd = &cobra.Command{
+	Use:   "clean",
+	Short: "Deletes change sets created by stx deploy.",
+	Long: `Clean deletes change sets named by stx deploy (stx-dpl-<user>-<sha1>) from the
+evaluated stacks once they are older than --older-than (24h by default). Use
+--mine to only delete your own. Change sets not created by stx are never
+deleted. The change sets are listed and confirmed before deleting, unless
+--yes is used.
+`,
+	Run: func(cmd *cobra.Command, args []string) {
+		defer log.Flush()
+
+		usr, _ := user.Current()
+		var doomed []*stackDeployment
+		forEachStackChangeSets(args, func(stack stx.Stack, cfn *cloudformation.CloudFormation, summaries []*cloudformation.ChangeSetSummary) {
+			for _, summary := range summaries {
+				changeSetName := aws.StringValue(summary.ChangeSetName)
+				owner := changeSetOwner(changeSetName)
+				if !stxChangeSetName.MatchString(changeSetName) || (flags.ChangeSetsMine && owner != usr.Username) {
+					continue
+				}
+				if time.Since(aws.TimeValue(summary.CreationTime)) < flags.ChangeSetsOlderThan {
+					continue
+				}
+				doomed = append(doomed, &stackDeployment{deployArgs: deployArgs{stack: stack}, log: log, cfn: cfn, changeSetName: changeSetName})
+				log.Infof("  %s %s %s %s\n", au.BrightBlue(changeSetName), au.White("⤏"), au.Magenta(stack.Name), au.Gray(11, changeSetAge(summary)+" old"))
+			}
+		})
+
+		if len(doomed) < 1 {
+			log.Info("No change sets to clean.")
+			return
+		}
+		if !flags.DeployYes {
+			if !promptYes(context.Background(), au.Index(255-88, fmt.Sprintf("Delete %d change sets?", len(doomed))).String()) {
+				return
+			}
+		}
+		for _, d := range doomed {
+			d.deleteChangeSet()
+		}
+	},
+}
+
+// forEachStackChangeSets calls fn with the change sets of every evaluated stack
+func forEachStackChangeSets(args []string, fn func(stack stx.Stack, cfn *cloudformation.CloudFormation, summaries []*cloudformation.ChangeSetSummary)) {
+	forEachStack(args, func(dplArgs deployArgs, cfn *cloudformation.CloudFormation) {
+		stack := dplArgs.stack
+		var summaries []*cloudformation.ChangeSetSummary
+		listInput := cloudformation.ListChangeSetsInput{StackName: aws.String(stack.Name)}
+		for {
+			page, listErr := cfn.ListChangeSets(&listInput)
+			if listErr != nil {
+				// stacks that do not exist have no change sets
+				if awsErr, ok := listErr.(awserr.Error); ok && awsErr.Code() == "ValidationError" {
+					log.Debugf("Skipping %s: %s\n", stack.Name, listErr)
+					return
+				}
+				log.Error(listErr)
+				return
+			}
+			summaries = append(summaries, page.Summaries...)
+			if page.NextToken == nil {
+				break
+			}
+			listInput.NextToken = page.NextToken
+		}
+		fn(stack, cfn, summaries)
+	})
+}
+
+// forEachChangeSet calls fn for every evaluated stack that has the named change set
+func forEachChangeSet(changeSetName string, args []string, fn func(d *stackDeployment, changeSet *cloudformation.DescribeChangeSetOutput)) {
+	forEachStack(args, func(dplArgs deployArgs, cfn *cloudformation.CloudFormation) {
+		stack := dplArgs.stack
+		var changeSet *cloudformation.DescribeChangeSetOutput
+		describeInput := cloudformation.DescribeChangeSetInput{ChangeSetName: aws.String(changeSetName), StackName: aws.String(stack.Name)}
+		// changes are paged, so gather them all before rendering
+		for {
+			page, describeErr := cfn.DescribeChangeSet(&describeInput)
+			if describeErr != nil {
+				if awsErr, ok := describeErr.(awserr.Error); ok && (awsErr.Code() == cloudformation.ErrCodeChangeSetNotFoundException || awsErr.Code() == "ValidationError") {
+					return
+				}
+				log.Error(describeErr)
+				return
+			}
+			if changeSet == nil {
+				changeSet = page
+			} else {
+				changeSet.Changes = append(changeSet.Changes, page.Changes...)
+			}
+			if page.NextToken == nil {
+				break
+			}
+			describeInput.NextToken = page.NextToken
+		}
+
+		// a stack that has never been deployed waits in REVIEW_IN_PROGRESS for its first change set
+		changeSetType := "UPDATE"
+		if stackStatus, statusErr := describeStackStatus(cfn, stack.Name); statusErr == nil && stackStatus == "REVIEW_IN_PROGRESS" {
+			changeSetType = "CREATE"
+		}
+		// import change sets only contain Import actions
+		for _, change := range changeSet.Changes {
+			if aws.StringValue(change.ResourceChange.Action) == cloudformation.ChangeActionImport {
+				changeSetType = "IMPORT"
+				break
+			}
+		}
+
+		fn(&stackDeployment{
+			deployArgs:    dplArgs,
+			log:           log,
+			cfn:           cfn,
+			changeSetName: changeSetName,
+			changeSetArn:  aws.StringValue(changeSet.ChangeSetId),
+			changeSetType: changeSetType,
+		}, changeSet)
+	})
+}
+
+// forEachStack calls fn with a cloudformation client for every evaluated stack, once the credentials are verified to belong to
+// the stack's AccountId, since the client may be used to execute or delete change sets
+func forEachStack(args []string, fn func(dplArgs deployArgs, cfn *cloudformation.CloudFormation)) {
+	if sessionErr := stx.EnsureSession(config); sessionErr != nil {
+		log.Fatal(sessionErr)
+	}
+
+	buildInstances := stx.GetBuildInstances(args, config.PackageName)
+	stx.Process(buildInstances, flags, log, func(buildInstance *build.Instance, cueInstance *cue.Instance) {
+		stacksIterator, stacksIteratorErr := stx.NewStacksIterator(cueInstance, flags, log)
+		if stacksIteratorErr != nil {
+			log.Fatal(stacksIteratorErr)
+		}
+
+		for stacksIterator.Next() {
+			stackValue := stacksIterator.Value()
+			var stack stx.Stack
+			decodeErr := stackValue.Decode(&stack)
+			if decodeErr != nil {
+				log.Error(decodeErr)
+				continue
+			}
+
+			session, sessionErr := stx.GetStackSession(stack)
+			if sessionErr != nil {
+				log.Error(sessionErr)
+				continue
+			}
+			if accountErr := stx.VerifyAccount(session, stack); accountErr != nil {
+				log.Error(accountErr)
+				continue
+			}
+			fn(deployArgs{stack: stack, buildInstance: buildInstance, stackValue: stackValue}, cloudformation.New(session, aws.NewConfig().WithRegion(stack.Region)))
+		}
+	})
+}
+
+// changeSetOwner returns the user that deploy recorded in the change set name, or "-" for change sets not created by stx
+func changeSetOwner(changeSetName string) string {
+	matches := stxChangeSetName.FindStringSubmatch(changeSetName)
+	if matches == nil {
+		return "-"
+	}
+	return matches[1]
+}
+
+// changeSetAge returns how long ago the change set was created, rounded for display
+func changeSetAge(summary *cloudformation.ChangeSetSummary) string {
+	age := time.Since(aws.TimeValue(summary.CreationTime))
+	if age > time.Hour {
+		return age.Round(time.Hour).String()
+	}
+	return age.Round(time.Minute).String()
+}
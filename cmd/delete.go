@@ -1,7 +1,7 @@
 package cmd
 
 import (
-	"fmt"
+	"context"
 	"os"
 	"path/filepath"
 	"strings"
@@ -36,7 +36,9 @@ applied to the credentials being used! **
 
 		//TODO add debug messages
 		defer log.Flush()
-		stx.EnsureVaultSession(config)
+		if sessionErr := stx.EnsureSession(config); sessionErr != nil {
+			log.Fatal(sessionErr)
+		}
 
 		buildInstances := stx.GetBuildInstances(args, config.PackageName)
 
@@ -57,18 +59,27 @@ applied to the credentials being used! **
 				}
 				log.Infof("%s %s %s %s:%s %s\n", au.Red("You are about to DELETE"), au.Magenta(stack.Name), au.Red("from"), au.Green(stack.Profile), au.Cyan(stack.Region), au.Red("."))
 				log.Infof("%s\n%s\n%s", au.Index(255-88, "Are you sure you want to DELETE this stack?"), au.Gray(11, "Enter the name of the stack to confirm."), au.Gray(11, "▶︎"))
-				var input string
-				fmt.Scanln(&input)
-
-				if input != stack.Name {
+				input, _ := readLine(context.Background())
+				if strings.TrimSpace(input) != stack.Name {
 					continue
 				}
 
-				session := stx.GetSession(stack.Profile)
+				session, sessionErr := stx.GetStackSession(stack)
+				if sessionErr != nil {
+					log.Error(sessionErr)
+					continue
+				}
+				if accountErr := stx.VerifyAccount(session, stack); accountErr != nil {
+					log.Error(accountErr)
+					continue
+				}
 				cfn := cloudformation.New(session, aws.NewConfig().WithRegion(stack.Region))
 
 				log.Infof("%s %s %s %s:%s\n", au.White("Deleting"), au.Magenta(stack.Name), au.White("⤎"), au.Green(stack.Profile), au.Cyan(stack.Region))
 				deleteStackInput := cloudformation.DeleteStackInput{StackName: aws.String(stack.Name)}
+				if stack.RoleArn != "" {
+					deleteStackInput.SetRoleARN(stack.RoleArn)
+				}
 				_, deleteStackErr := cfn.DeleteStack(&deleteStackInput)
 				if deleteStackErr != nil {
 					log.Error(deleteStackErr)
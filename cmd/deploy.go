@@ -1,20 +1,26 @@
 package cmd
 
 import (
+	"bytes"
 	"context"
 	"crypto/sha1"
+	"encoding/json"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"os"
 	"os/user"
-	"path/filepath"
+	"reflect"
 	"regexp"
+	"sort"
 	"strings"
+	"sync"
 	"time"
 
 	"cuelang.org/go/cue"
 	"cuelang.org/go/cue/build"
 	"github.com/TangoGroup/stx/graph"
+	"github.com/TangoGroup/stx/logger"
 	"github.com/TangoGroup/stx/stx"
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/aws/awserr"
@@ -23,7 +29,6 @@ import (
 	"github.com/aws/aws-sdk-go/service/sns"
 	"github.com/olekukonko/tablewriter"
 	"github.com/spf13/cobra"
-	"gopkg.in/yaml.v2"
 )
 
 func init() {
@@ -32,6 +37,14 @@ func init() {
 	deployCmd.Flags().BoolVarP(&flags.DeploySave, "save", "s", false, "Save stack outputs upon successful completion. Implies --wait.")
 	deployCmd.Flags().BoolVarP(&flags.DeployDeps, "dependencies", "d", false, "Deploy stack dependencies in order. Implies --save.")
 	deployCmd.Flags().BoolVarP(&flags.DeployPrevious, "previous-values", "v", false, "Deploy stack using previous parameter values.")
+	deployCmd.Flags().BoolVarP(&flags.DeployYes, "yes", "y", false, "Execute change sets without prompting, subject to Cmd:Deploy:AutoApprove:Policy.")
+	deployCmd.Flags().BoolVar(&flags.DeployYes, "auto-approve", false, "Alias for --yes.")
+	deployCmd.Flags().StringVar(&flags.DeploySaveOverrides, "save-overrides", "", "Save prompted parameter values to this overrides file, relative to the cue root. Supports ${STX::CuePath} and ${STX::StackName}.")
+	deployCmd.Flags().StringVar(&flags.DeploySopsKmsArn, "sops-kms-arn", "", "Encrypt the file written by --save-overrides with sops using this KMS key.")
+	deployCmd.Flags().StringVar(&flags.DeployPlanOut, "plan-out", "", "Create and describe change sets without executing them, and write a plan for stx apply to this file.")
+	deployCmd.Flags().BoolVar(&flags.DeployImport, "import", false, "Create IMPORT change sets that adopt the existing resources declared in each stack's Import.")
+	deployCmd.Flags().StringVar(&flags.DeployImportFile, "import-file", "", "Read resources to import from this yaml or json file, keyed by stack name and logical ID. Implies --import.")
+	deployCmd.Flags().IntVarP(&flags.DeployParallel, "parallel", "p", 1, "Deploy up to this many independent stacks at once. Approval is requested once per batch.")
 }
 
 type deployArgs struct {
@@ -50,10 +63,18 @@ For each stack, a changeset is first created, and the proposed changes are
 displayed. At this point you have the option to execute the changeset
 before moving on to the next stack.
 
+Change sets are named stx-dpl-<user>-<sha1 of template>. If one with that name
+already exists, it is reused when it is ready to execute and was created with
+the same parameters, tags, RoleArn, RollbackConfiguration, NotificationARNs, and
+capabilities. Otherwise it is deleted and created again. Change sets with NoEcho
+parameters are always created again, since their values cannot be compared.
+
 The following config.stx.cue options are available:
 
 Cmd: {
   Deploy: {
+    AutoApprove: Policy: *"Safe" | "Any"
+    TemplateBucket: string | *""
     Notify: {
       Endpoint: string | *""
       TopicArn: string | *""
@@ -61,6 +82,133 @@ Cmd: {
   }
 }
 
+Use --yes (or --auto-approve) to run deploy without prompting, such as in a CI
+pipeline. Cmd:Deploy:AutoApprove:Policy decides which change sets may be
+executed this way. "Safe" only executes change sets that neither remove nor
+replace resources; any Remove action, or any change that requires recreation
+(ALWAYS or CONDITIONAL), fails the deploy and deletes the change set. "Any"
+executes every change set.
+
+A StackPolicy or EnableTerminationProtection that differs from the stack is
+shown with the changes and approved together with the change set, or on its
+own when the template has no changes. Under "Safe", settings may enable
+termination protection or set a policy on a stack without one, but not disable
+termination protection or replace a live stack policy.
+
+Parameters that have neither a value in Overrides nor a Default are prompted
+for, showing the parameter's Description, Type, AllowedValues and
+ConstraintDescription. Input for NoEcho parameters is hidden. Use
+--save-overrides to write the answers to a new overrides file, and
+--sops-kms-arn to encrypt it with sops. Answers to NoEcho parameters are only
+saved when the file is encrypted. With --yes, missing values are an error.
+The overrides of every stack are checked before the first change set is
+created, so a duplicated, unknown, or missing parameter in any stack stops the
+whole run.
+
+Stacks may declare the following optional fields:
+
+Stacks: [string]: {
+  RoleArn:        string // service role CloudFormation uses to make changes
+  AssumeRoleArn:  string // role assumed on top of the Profile credentials
+  AccountId:      string // deploy refuses to run unless the credentials belong to this account
+  TemplateBucket: string // overrides Cmd:Deploy:TemplateBucket
+  Import: [string]: string | {[string]: string} // resources for --import, by logical ID
+
+  StackPolicy:                 {...}         // stack policy document
+  EnableTerminationProtection: bool
+  TimeoutInMinutes:            int           // deploy waits at most this long
+  NotificationARNs:            [...string]   // in addition to Cmd:Deploy:Notify:TopicArn
+  RollbackConfiguration: {
+    MonitoringTimeInMinutes: int
+    RollbackTriggers: [...{Arn: string, Type: *"AWS::CloudWatch::Alarm" | string}]
+  }
+
+  StackSet: {
+    PermissionModel:       *"SELF_MANAGED" | "SERVICE_MANAGED"
+    AdministrationRoleARN: string
+    ExecutionRoleName:     string
+    Accounts:              [...string] // SELF_MANAGED targets
+    OrganizationalUnitIds: [...string] // SERVICE_MANAGED targets
+    Regions:               [...string]
+    AutoDeployment: {Enabled: bool, RetainStacksOnAccountRemoval: bool}
+    OperationPreferences: {
+      FailureToleranceCount | FailureTolerancePercentage: int
+      MaxConcurrentCount | MaxConcurrentPercentage:       int
+      RegionOrder: [...string]
+    }
+  }
+}
+
+StackPolicy and EnableTerminationProtection are applied before an update is
+executed, and after a new stack has been created. RollbackConfiguration and
+NotificationARNs are part of the change set. Change sets cannot set
+TimeoutInMinutes, so deploy waits at most that long and then cancels an update,
+which rolls it back; a stack that is still being created is reported as failed.
+Fields that are not declared are left as they are. stx diff reports declared
+settings that differ from the live stack.
+
+Use --import to bring existing resources under a stack. Import maps the logical
+ID of each resource in the template to its physical ID, or to a map of its
+identifier properties when the resource type has more than one, such as
+{TableName: "orders"}. --import-file reads the same mapping from a yaml or
+json file keyed by stack name, which takes precedence over Import. Every
+imported resource must have DeletionPolicy: Retain. Resources the stack already
+manages are left out, and a stack with nothing left to import is skipped.
+Otherwise an IMPORT change set is created, reviewed and executed like any
+other. The template may not change anything else in the same deploy.
+
+A stack that declares a StackSet is deployed as a StackSet instead. Its
+Profile and Region are the administrator account and region that own the stack
+set, and every target account (or organizational unit) gets an instance in
+every region. The template is exported, diffed against the stack set's
+template, and approved like a change set, followed by a table of every
+instance; declared instances that do not exist yet are shown as Create, and
+instances that are not declared are left as they are. Once approved, deploy
+creates or updates the stack set, then creates the missing instances, waiting
+for each operation before starting the next and printing the result for each
+instance. Under the Safe AutoApprove policy only new stack sets and instances
+are auto-approved, since stack set updates cannot be previewed for
+replacements. Stack sets cannot be planned with --plan-out and have no outputs
+to save.
+
+CloudFormation only accepts templates up to 51,200 bytes in the request. When
+Cmd:Deploy:TemplateBucket (or a stack's TemplateBucket) is set, the template is
+uploaded to stx/<sha1>.cfn.yml in that bucket and referenced by TemplateURL
+when validating and creating the change set. The bucket must be in the stack's
+region. Without a bucket, larger templates fail before anything is created.
+Local artifacts referenced by the template are uploaded to the same bucket;
+see stx package --help.
+
+Use --parallel to deploy independent stacks at the same time. Combined with
+--dependencies, each layer of the dependency graph is deployed together and
+must complete, including saving outputs, before the next layer starts. Change
+sets for a layer are created first, then a single approval is requested for
+the whole layer. Output from each stack is prefixed with the stack name.
+
+Use --plan-out plan.json to create and describe change sets for every selected
+stack without executing them. The plan records each stack's name, profile,
+region, change set name and ARN, template sha1, and change list. Review it,
+then run stx apply plan.json to execute the change sets. With --dependencies,
+change sets are planned in dependency order, but no layer is executed before
+the next is planned.
+
+With --wait or --save, stack events are printed as they arrive while deploy
+waits, coloured as in stx events. If the stack fails or rolls back, the first
+failure reasons are summarised and outputs are not saved. Notify is not needed
+to follow progress.
+
+Deploy ends with a summary of every stack in the run: succeeded, executed
+(without --wait), no changes, planned, cancelled, skipped, or failed. A stack
+that ends in a ROLLBACK_* or *_FAILED status is failed. With --dependencies,
+stacks that depend on a failed stack are skipped. The exit code is the number
+of errors, so any failure exits non-zero.
+
+Ctrl-C (SIGINT) or SIGTERM cancels any AWS call in progress and deletes every
+change set deploy created but did not execute, including those already
+written to a --plan-out plan. If a stack update is in progress, deploy offers
+to cancel it with CancelUpdateStack; with --yes it is left running. A second
+interrupt exits immediately. An interrupted deploy exits with code 130.
+
 Use Cmd:Deploy:Notify: properties to enable the notify command to receive stack
 event notifications from SNS. The endpoint will be the http address provided by
 the notify command. If this is run behind a router, you will need to enable
@@ -79,12 +227,24 @@ first.
 	Run: func(cmd *cobra.Command, args []string) {
 
 		defer log.Flush()
-		stx.EnsureVaultSession(config)
+		if sessionErr := stx.EnsureSession(config); sessionErr != nil {
+			log.Fatal(sessionErr)
+		}
 
 		if flags.DeployDeps {
 			flags.DeploySave = true
 		}
 
+		if flags.DeployImportFile != "" {
+			flags.DeployImport = true
+			if readErr := readImportFile(flags.DeployImportFile); readErr != nil {
+				log.Fatal(readErr)
+			}
+		}
+
+		ctx, cleanedUp, stopInterrupts := handleInterrupts()
+		defer stopInterrupts()
+
 		availableStacks := make(map[string]deployArgs)
 		workingGraph := graph.NewGraph()
 		buildInstances := stx.GetBuildInstances(args, config.PackageName)
@@ -117,72 +277,176 @@ first.
 			}
 		})
 
+		// a mistake in any stack's overrides stops the run before change sets are created for the others
+		if invalid := checkOverrides(availableStacks); invalid > 0 {
+			log.Fatalf("Not deploying: %d stacks have invalid overrides.\n", invalid)
+		}
+
 		if flags.DeployDeps {
-			resolved, err := workingGraph.Resolve()
+			layers, err := workingGraph.Layers()
 			if err != nil {
+				if _, ok := err.(*graph.MissingDependencyError); ok {
+					log.Info(au.Gray(11, "Every stack in DependsOn must exist and must not be excluded by global filters such as --stacks or --environment."))
+				}
 				log.Fatalf("Failed to resolve dependency graph: %s\n", err)
 			}
 
-			for _, stackName := range resolved {
-				dplArgs := availableStacks[stackName]
-				deployStack(dplArgs.stack, dplArgs.buildInstance, dplArgs.stackValue)
+			for i, stackNames := range layers {
+				var layer []deployArgs
+				for _, stackName := range stackNames {
+					layer = append(layer, availableStacks[stackName])
+				}
+				deployLayer(ctx, layer)
+				if ctx.Err() != nil {
+					break
+				}
+
+				// stacks in later layers depend on this one, so stop once any of it fails
+				if stackFailed(stackNames...) {
+					for _, remaining := range layers[i+1:] {
+						for _, stackName := range remaining {
+							recordResult(availableStacks[stackName].stack, outcomeSkipped, "a dependency failed")
+						}
+					}
+					break
+				}
 			}
 		} else {
+			var layer []deployArgs
 			for _, dplArgs := range availableStacks {
-				deployStack(dplArgs.stack, dplArgs.buildInstance, dplArgs.stackValue)
+				layer = append(layer, dplArgs)
 			}
+			deployLayer(ctx, layer)
+		}
+
+		waitForInterrupt(ctx, cleanedUp)
+		printDeployResults()
+
+		if flags.DeployPlanOut != "" {
+			if writeErr := writePlan(flags.DeployPlanOut); writeErr != nil {
+				log.Fatal(writeErr)
+			}
+			log.Infof("%s %d change sets %s %s\n", au.White("Planned"), len(plan.Stacks), au.White("⤏"), flags.DeployPlanOut)
 		}
 	},
 }
 
-func deployStack(stack stx.Stack, buildInstance *build.Instance, stackValue cue.Value) {
+// stackDeployment holds the state of a stack between creating its change set and executing it
+type stackDeployment struct {
+	deployArgs
+	ctx                          context.Context
+	log                          *logger.Logger
+	cfn                          *cloudformation.CloudFormation
+	changeSetName, changeSetType string
+	changeSetArn, templateSha1   string
+	templateBody                 string
+	changes                      []*cloudformation.Change
+	settingsDiffs                []settingDiff       // StackPolicy and EnableTerminationProtection, approved together with the change set
+	settingsOnly                 bool                // there is no change set, only settingsDiffs to apply
+	stackSet                     *stackSetDeployment // set for stacks that declare a StackSet
+}
 
-	fileName, saveErr := saveStackAsYml(stack, buildInstance, stackValue)
-	if saveErr != nil {
-		log.Error(saveErr)
-	}
-	log.Infof("%s %s %s %s:%s\n", au.White("Deploying"), au.Magenta(stack.Name), au.White("⤏"), au.Green(stack.Profile), au.Cyan(stack.Region))
-	log.Infof("%s", au.Gray(11, "  Validating template..."))
+// prepareDeployment creates and describes a change set for the stack. It returns nil if there is nothing to execute.
+func prepareDeployment(ctx context.Context, dplArgs deployArgs, stackLog *logger.Logger) *stackDeployment {
+	stack, buildInstance, stackValue := dplArgs.stack, dplArgs.buildInstance, dplArgs.stackValue
 
-	// get a session and cloudformation service client
-	log.Debugf("\nGetting session for profile %s\n", stack.Profile)
-	session := stx.GetSession(stack.Profile)
+	// the account is verified before packaging, so artifacts are never uploaded to the wrong account's bucket
+	stackLog.Debugf("Getting session for profile %s\n", stack.Profile)
+	session, sessionErr := stx.GetStackSession(stack)
+	if sessionErr != nil {
+		return deployFailed(stackLog, stack, sessionErr)
+	}
+	if accountErr := stx.VerifyAccount(session, stack); accountErr != nil {
+		return deployFailed(stackLog, stack, accountErr)
+	}
 	awsCfg := aws.NewConfig().WithRegion(stack.Region)
 	cfn := cloudformation.New(session, awsCfg)
 
+	fileName, saveErr := saveStackAsYml(stackLog, stack, buildInstance, stackValue, false)
+	if saveErr != nil {
+		return deployFailed(stackLog, stack, saveErr)
+	}
+	stackLog.Infof("%s %s %s %s:%s\n", au.White("Deploying"), au.Magenta(stack.Name), au.White("⤏"), au.Green(stack.Profile), au.Cyan(stack.Region))
+
+	// property typos would otherwise only surface once the change set fails
+	propertyProblems, specErr := resourceSpecProblems(stackValue)
+	if specErr != nil {
+		return deployFailed(stackLog, stack, specErr)
+	}
+	if len(propertyProblems) > 0 {
+		var tableBuf bytes.Buffer
+		renderProblems(&tableBuf, propertyProblems)
+		stackLog.Stdout().Write(tableBuf.Bytes())
+		return deployFailed(stackLog, stack, fmt.Errorf("%d resource properties do not match Cmd:Validate:ResourceSpec", len(propertyProblems)))
+	}
+
+	parameters, parametersErr := stackParameters(ctx, stack, buildInstance, stackValue, stackLog)
+	if parametersErr != nil {
+		return deployFailed(stackLog, stack, parametersErr)
+	}
+
+	stackLog.Infof("%s", au.Gray(11, "  Validating template..."))
+
 	// read template from disk
-	log.Debug("Reading template from", fileName)
+	stackLog.Debug("Reading template from", fileName)
 	templateFileBytes, _ := ioutil.ReadFile(fileName)
 	templateBody := string(templateFileBytes)
 	usr, _ := user.Current()
 
-	changeSetName := "stx-dpl-" + usr.Username + "-" + fmt.Sprintf("%x", sha1.Sum(templateFileBytes))
+	templateSha1 := fmt.Sprintf("%x", sha1.Sum(templateFileBytes))
+	changeSetName := "stx-dpl-" + usr.Username + "-" + templateSha1
+	// templates are uploaded to s3 when a bucket is configured, and must be when they are too large to send in the request
+	bucket := templateBucket(stack)
+	if bucket != "" {
+		stackLog.Debugf("Uploading template to %s\n", bucket)
+	}
+	templateURL, uploadErr := stx.UploadTemplate(stx.NewS3Client(session, awsCfg), bucket, templateFileBytes)
+	if uploadErr != nil {
+		stackLog.Infof(" %s\n", au.Red("✕"))
+		return deployFailed(stackLog, stack, uploadErr)
+	}
+
 	// validate template
-	validateTemplateInput := cloudformation.ValidateTemplateInput{
-		TemplateBody: &templateBody,
+	validateTemplateInput := cloudformation.ValidateTemplateInput{}
+	if templateURL != "" {
+		validateTemplateInput.SetTemplateURL(templateURL)
+	} else {
+		validateTemplateInput.SetTemplateBody(templateBody)
 	}
-	validateTemplateOutput, validateTemplateErr := cfn.ValidateTemplate(&validateTemplateInput)
+	validateTemplateOutput, validateTemplateErr := cfn.ValidateTemplateWithContext(ctx, &validateTemplateInput)
 
 	// template failed to validate
 	if validateTemplateErr != nil {
-		log.Infof(" %s\n", au.Red("✕"))
-		log.Fatalf("%+v\n", validateTemplateErr)
+		stackLog.Infof(" %s\n", au.Red("✕"))
+		return deployFailed(stackLog, stack, validateTemplateErr)
 	}
 
 	// template must have validated
-	log.Infof("%s\n", au.BrightGreen("✓"))
+	stackLog.Infof("%s\n", au.BrightGreen("✓"))
 	//log.Infof("%+v\n", validateTemplateOutput.String())
 
+	if stack.StackSet != nil {
+		return prepareStackSet(ctx, dplArgs, stackLog, cfn, templateBody, templateURL, validateTemplateOutput.Capabilities, parameters)
+	}
+
 	// look to see if stack exists
-	log.Debug("Describing", stack.Name)
+	stackLog.Debug("Describing", stack.Name)
 	describeStacksInput := cloudformation.DescribeStacksInput{StackName: aws.String(stack.Name)}
-	_, describeStacksErr := cfn.DescribeStacks(&describeStacksInput)
+	_, describeStacksErr := cfn.DescribeStacksWithContext(ctx, &describeStacksInput)
 
 	createChangeSetInput := cloudformation.CreateChangeSetInput{
 		Capabilities:  validateTemplateOutput.Capabilities,
 		ChangeSetName: aws.String(changeSetName), // I think AWS overuses pointers
 		StackName:     aws.String(stack.Name),
-		TemplateBody:  aws.String(templateBody),
+	}
+	if templateURL != "" {
+		createChangeSetInput.SetTemplateURL(templateURL)
+	} else {
+		createChangeSetInput.SetTemplateBody(templateBody)
+	}
+
+	if stack.RoleArn != "" {
+		createChangeSetInput.SetRoleARN(stack.RoleArn)
 	}
 
 	changeSetType := "UPDATE" // default
@@ -192,287 +456,766 @@ func deployStack(stack stx.Stack, buildInstance *build.Instance, stackValue cue.
 		changeSetType = "CREATE" // if stack does not already exist
 	}
 
+	// adopt existing resources instead of deploying other changes
+	if flags.DeployImport {
+		imports, importsErr := resourcesToImport(ctx, cfn, stack, templateBody, templateURL, describeStacksErr == nil)
+		if importsErr != nil {
+			return deployFailed(stackLog, stack, importsErr)
+		}
+		if len(imports) < 1 {
+			stackLog.Info(au.Yellow("Nothing to import."))
+			recordResult(stack, outcomeSkipped, "nothing to import")
+			return nil
+		}
+		changeSetType = "IMPORT"
+		createChangeSetInput.SetResourcesToImport(imports)
+		// an import change set must not reuse the name of an update change set for the same template
+		importsJSON, _ := json.Marshal(imports)
+		changeSetName = fmt.Sprintf("stx-dpl-%s-%x", usr.Username, sha1.Sum(append(templateFileBytes, importsJSON...)))
+		createChangeSetInput.SetChangeSetName(changeSetName)
+	}
+
 	createChangeSetInput.ChangeSetType = &changeSetType
 
-	stackParametersValue := stackValue.Lookup("Template", "Parameters")
-	if stackParametersValue.Exists() {
+	d := &stackDeployment{
+		ctx:           ctx,
+		deployArgs:    dplArgs,
+		log:           stackLog,
+		cfn:           cfn,
+		changeSetName: changeSetName,
+		changeSetType: changeSetType,
+		templateSha1:  templateSha1,
+		templateBody:  templateBody,
+	}
 
-		// TODO paramaters need to support the type as declared in Parameter.Type (in the least string and number).
-		// this should be map[string]interface{} with type casting done when adding parameters to the changeset
-		parametersMap := make(map[string]string)
-		var parameters []*cloudformation.Parameter
+	if len(parameters) > 0 {
+		createChangeSetInput.SetParameters(parameters)
+	}
 
-		if flags.DeployPrevious {
-			// deploy using previous values
-			stackParameters, stackParametersErr := stackParametersValue.Fields()
-			if stackParametersErr != nil {
-				log.Fatal(stackParametersErr)
-				return
-			}
-			log.Infof("%s", au.Gray(11, "  Using previous parameters..."))
-			for stackParameters.Next() {
-				stackParam := stackParameters.Value()
-				key, _ := stackParam.Label()
-				parametersMap[key] = ""
-			}
-			log.Check()
+	// handle Stack.Tags
+	if tags := stackTags(stack, buildInstance); len(tags) > 0 {
+		createChangeSetInput.SetTags(tags)
+	}
+	if stack.RollbackConfiguration != nil {
+		createChangeSetInput.SetRollbackConfiguration(rollbackConfiguration(stack.RollbackConfiguration))
+	}
+	if stack.NotificationARNs != nil {
+		createChangeSetInput.SetNotificationARNs(aws.StringSlice(stack.NotificationARNs))
+	}
+	if config.Cmd.Deploy.Notify.TopicArn != "" { // && stx notify command is running! perhaps use unix domain sockets to test
+		stackLog.Infof("%s", au.Gray(11, "  Reticulating splines..."))
+
+		snsClient := sns.New(session, awsCfg)
+
+		subscribeInput := sns.SubscribeInput{Endpoint: aws.String(config.Cmd.Deploy.Notify.Endpoint), TopicArn: aws.String(config.Cmd.Deploy.Notify.TopicArn), Protocol: aws.String("http")}
+		_, subscribeErr := snsClient.Subscribe(&subscribeInput)
+		if subscribeErr != nil {
+			stackLog.Errorf("%s\n", subscribeErr)
 		} else {
-			// load overrides
+			createChangeSetInput.SetNotificationARNs(aws.StringSlice(notificationARNs(stack)))
+			stackLog.Check()
+		}
+	}
 
-			// TODO #48 stx should prompt for each Parameter input if overrides are undefined
-			if len(stack.Overrides) < 0 {
-				log.Fatal("Template has Parameters but no Overrides are defined.")
-				return
-			}
+	// DescribeChangeSet does not return every setting, so a hash of them is kept in the Description for reuseChangeSet
+	createChangeSetInput.SetDescription(changeSetSettingsDescription(&createChangeSetInput))
 
-			for k, v := range stack.Overrides {
-				path := strings.Replace(k, "${STX::CuePath}", strings.Replace(buildInstance.Dir, buildInstance.Root+"/", "", 1), 1)
-				behavior := v
+	stackLog.Infof("%s", au.Gray(11, "  Creating changeset..."))
 
-				log.Infof("%s", au.Gray(11, "  Applying overrides: "+path+" "))
+	createChangeSetOutput, createChangeSetErr := cfn.CreateChangeSetWithContext(ctx, &createChangeSetInput)
 
-				var yamlBytes []byte
-				var yamlBytesErr error
+	if awsErr, ok := createChangeSetErr.(awserr.Error); ok && awsErr.Code() == "AlreadyExistsException" {
+		stackLog.Infof(" %s\n", au.Yellow("already exists"))
+		changeSetArn, existingErr := d.reuseChangeSet(&createChangeSetInput)
+		if existingErr != nil {
+			return deployFailed(stackLog, stack, existingErr)
+		}
+		d.changeSetArn = changeSetArn
+	} else if createChangeSetErr != nil {
+		stackLog.Infof(" %s\n", au.Red("✕"))
+		return deployFailed(stackLog, stack, createChangeSetErr)
+	} else {
+		d.changeSetArn = aws.StringValue(createChangeSetOutput.Id)
+	}
+	trackCreated(d)
 
-				if behavior.SopsProfile != "" {
-					// decrypt the file contents
-					yamlBytes, yamlBytesErr = stx.DecryptSecrets(filepath.Clean(buildInstance.Root+"/"+path), behavior.SopsProfile)
-				} else {
-					// just pull the file contents directly
-					yamlBytes, yamlBytesErr = ioutil.ReadFile(filepath.Clean(buildInstance.Root + "/" + path))
-				}
+	describeChangesetInput := cloudformation.DescribeChangeSetInput{
+		ChangeSetName: aws.String(changeSetName),
+		StackName:     aws.String(stack.Name),
+	}
 
-				if yamlBytesErr != nil {
-					log.Fatal(yamlBytesErr)
-					return
-				}
+	waitOption := request.WithWaiterDelay(request.ConstantWaiterDelay(5 * time.Second))
+	cfn.WaitUntilChangeSetCreateCompleteWithContext(ctx, &describeChangesetInput, waitOption)
 
-				// TODO #47 parameters need to support the type as declared in Parameter.Type (in the least string and number).
-				// this should be map[string]interface{} with type casting done when adding parameters to the changeset
-				var override map[string]string
+	stackLog.Check()
 
-				yamlUnmarshalErr := yaml.Unmarshal(yamlBytes, &override)
-				if yamlUnmarshalErr != nil {
-					log.Fatal(yamlUnmarshalErr)
-					return
-				}
+	stackLog.Infof("%s %s %s %s:%s\n", au.White("Describing"), au.BrightBlue(changeSetName), au.White("⤎"), au.Magenta(stack.Name), au.Cyan(stack.Region))
+	describeChangesetOuput, describeChangesetErr := cfn.DescribeChangeSetWithContext(ctx, &describeChangesetInput)
+	if describeChangesetErr != nil {
+		return deployFailed(stackLog, stack, describeChangesetErr)
+	}
 
-				// TODO #50 stx should error when a parameter key is duplicated among two or more overrides files
-				if len(behavior.Map) > 0 {
-					// map the yaml key:value to parameter key:value
-					for k, v := range behavior.Map {
-						fromKey := k
-						toKey := v
-						parametersMap[toKey] = override[fromKey]
-					}
-				} else {
-					// just do a straight copy, keys should align 1:1
-					for k, v := range override {
-						overrideKey := k
-						overrideVal := v
-						parametersMap[overrideKey] = overrideVal
-					}
-				}
-				log.Check()
+	if aws.StringValue(describeChangesetOuput.ExecutionStatus) != "AVAILABLE" || aws.StringValue(describeChangesetOuput.Status) != "CREATE_COMPLETE" {
+		statusReason := aws.StringValue(describeChangesetOuput.StatusReason)
+		// a change set without changes fails to create, as does one that CloudFormation rejects
+		if aws.StringValue(describeChangesetOuput.Status) == "FAILED" && !noChangesReason(statusReason) {
+			d.deleteChangeSet()
+			return deployFailed(stackLog, stack, fmt.Errorf("change set %s failed: %s", changeSetName, statusReason))
+		}
+		stackLog.Debugf("%+v\n", describeChangesetOuput)
+		stackLog.Info(au.Yellow("No changes to deploy."))
+		d.deleteChangeSet()
+		// settings outside the template can still be out of date, and are approved like a change set
+		if changeSetType == "UPDATE" && flags.DeployPlanOut == "" {
+			settingsDiffs, settingsErr := stackSettingsDiffs(cfn, stack)
+			if settingsErr != nil {
+				return deployFailed(stackLog, stack, settingsErr)
+			}
+			printSettingsDiffs(stackLog, stack.Name, settingsDiffs)
+			if len(appliedSettings(settingsDiffs)) > 0 {
+				d.settingsDiffs, d.settingsOnly = appliedSettings(settingsDiffs), true
+				return d
 			}
 		}
+		recordResult(stack, outcomeNoChanges, "")
+		return nil
+	}
 
-		// apply parameters to changeset
-		for k, v := range parametersMap {
-			paramKey := k
-			paramVal := v
-			parameter := cloudformation.Parameter{ParameterKey: aws.String(paramKey)}
+	if len(describeChangesetOuput.Changes) > 0 {
+		// render into a buffer so the table is written as a single block
+		var tableBuf bytes.Buffer
+		renderChanges(&tableBuf, changeRows(describeChangesetOuput.Changes))
+		stackLog.Stdout().Write(tableBuf.Bytes())
+	}
 
-			if flags.DeployPrevious {
-				parameter.SetUsePreviousValue(true)
-			} else {
-				parameter.ParameterValue = aws.String(paramVal)
+	diff(stackLog, cfn, stack.Name, templateBody)
+	if settingsErr := d.loadSettingsDiffs(); settingsErr != nil {
+		d.deleteChangeSet()
+		return deployFailed(stackLog, stack, settingsErr)
+	}
+	printSettingsDiffs(stackLog, stack.Name, d.settingsDiffs)
+
+	d.changes = describeChangesetOuput.Changes
+	return d
+}
+
+// stackTags returns the Stack.Tags to apply, with ${STX::CuePath} and ${STX::CueFiles} replaced, or nil if tags are not enabled
+func stackTags(stack stx.Stack, buildInstance *build.Instance) []*cloudformation.Tag {
+	if len(stack.Tags) < 1 || !stack.TagsEnabled {
+		return nil
+	}
+	var tags []*cloudformation.Tag
+	for k, v := range stack.Tags {
+		tagK := k // reassign here to avoid issues with for-scope var
+		var tagV string
+		switch v {
+		default:
+			tagV = v
+		case "${STX::CuePath}":
+			tagV = strings.Replace(buildInstance.Dir, buildInstance.Root, "", 1)
+		case "${STX::CueFiles}":
+			tagV = strings.Join(buildInstance.CUEFiles, ", ")
+		}
+		tags = append(tags, &cloudformation.Tag{Key: &tagK, Value: &tagV})
+	}
+	return tags
+}
+
+// changeRows flattens changes into the rows of the change table: Resource, Action, Attribute, Property, Recreation
+func changeRows(changes []*cloudformation.Change) [][]string {
+	var rows [][]string
+	for _, change := range changes {
+		row := []string{
+			aws.StringValue(change.ResourceChange.LogicalResourceId),
+			aws.StringValue(change.ResourceChange.Action),
+			"",
+			"",
+			"",
+		}
+
+		if aws.StringValue(change.ResourceChange.Action) == "Modify" {
+			for _, detail := range change.ResourceChange.Details {
+				detailRow := append([]string{}, row...)
+				detailRow[2] = aws.StringValue(detail.Target.Attribute)
+				detailRow[3] = aws.StringValue(detail.Target.Name)
+				detailRow[4] = aws.StringValue(detail.Target.RequiresRecreation)
+				rows = append(rows, detailRow)
 			}
+		} else {
+			rows = append(rows, row)
+		}
+	}
+	return rows
+}
 
-			parameters = append(parameters, &parameter)
+// renderChanges writes the change table, highlighting changes that require recreation
+func renderChanges(w io.Writer, rows [][]string) {
+	table := tablewriter.NewWriter(w)
+	table.SetAutoWrapText(false)
+	table.SetAutoMergeCells(true)
+	table.SetRowLine(true)
+	table.SetHeader([]string{"Resource", "Action", "Attribute", "Property", "Recreation"})
+	table.SetHeaderColor(tablewriter.Colors{tablewriter.FgWhiteColor}, tablewriter.Colors{tablewriter.FgWhiteColor}, tablewriter.Colors{tablewriter.FgWhiteColor}, tablewriter.Colors{tablewriter.FgWhiteColor}, tablewriter.Colors{tablewriter.FgWhiteColor})
+
+	for _, row := range rows {
+		recreation := row[4]
+		if recreation == "ALWAYS" || recreation == "CONDITIONAL" {
+			row = append([]string{}, row...)
+			row[4] = au.Red(recreation).String()
 		}
+		table.Append(row)
+	}
+	table.Render()
+}
 
-		createChangeSetInput.SetParameters(parameters)
+// reuseChangeSet handles a change set with the same name that already exists, most likely left by an earlier run of the same template.
+// It is reused if it is ready to execute and was created with the same parameters, tags, and settings; otherwise it is replaced.
+func (d *stackDeployment) reuseChangeSet(input *cloudformation.CreateChangeSetInput) (string, error) {
+	describeChangeSetInput := cloudformation.DescribeChangeSetInput{
+		ChangeSetName: aws.String(d.changeSetName),
+		StackName:     aws.String(d.stack.Name),
+	}
+	existing, describeErr := d.cfn.DescribeChangeSetWithContext(d.ctx, &describeChangeSetInput)
+	templateParameters, templateParametersErr := stx.GetTemplateParameters(d.stackValue)
+	if describeErr == nil && templateParametersErr == nil &&
+		aws.StringValue(existing.Status) == "CREATE_COMPLETE" &&
+		aws.StringValue(existing.ExecutionStatus) == "AVAILABLE" &&
+		aws.StringValue(existing.Description) == aws.StringValue(input.Description) &&
+		sameParameters(templateParameters, existing.Parameters, input.Parameters) &&
+		reflect.DeepEqual(tagValues(existing.Tags), tagValues(input.Tags)) {
+		d.log.Infof("%s %s\n", au.White("Reusing"), au.BrightBlue(d.changeSetName))
+		return aws.StringValue(existing.ChangeSetId), nil
+	}
 
-	} // end stackParametersValue.Exists()
+	d.log.Infof("%s %s\n", au.White("Replacing"), au.BrightBlue(d.changeSetName))
+	_, deleteErr := d.cfn.DeleteChangeSetWithContext(d.ctx, &cloudformation.DeleteChangeSetInput{
+		ChangeSetName: aws.String(d.changeSetName),
+		StackName:     aws.String(d.stack.Name),
+	})
+	if deleteErr != nil {
+		return "", deleteErr
+	}
 
-	// handle Stack.Tags
-	if len(stack.Tags) > 0 && stack.TagsEnabled {
-		var tags []*cloudformation.Tag
-		for k, v := range stack.Tags {
-			tagK := k // reassign here to avoid issues with for-scope var
-			var tagV string
-			switch v {
-			default:
-				tagV = v
-			case "${STX::CuePath}":
-				tagV = strings.Replace(buildInstance.Dir, buildInstance.Root, "", 1)
-			case "${STX::CueFiles}":
-				tagV = strings.Join(buildInstance.CUEFiles, ", ")
-			}
-			tags = append(tags, &cloudformation.Tag{Key: &tagK, Value: &tagV})
+	// deletion is asynchronous, and the name cannot be reused until it completes
+	deleted := false
+	for attempt := 0; attempt < 30; attempt++ {
+		_, describeErr = d.cfn.DescribeChangeSetWithContext(d.ctx, &describeChangeSetInput)
+		if awsErr, ok := describeErr.(awserr.Error); ok && awsErr.Code() == cloudformation.ErrCodeChangeSetNotFoundException {
+			deleted = true
+			break
+		}
+		select {
+		case <-d.ctx.Done():
+			return "", d.ctx.Err()
+		case <-time.After(2 * time.Second):
 		}
-		createChangeSetInput.SetTags(tags)
 	}
-	if config.Cmd.Deploy.Notify.TopicArn != "" { // && stx notify command is running! perhaps use unix domain sockets to test
-		log.Infof("%s", au.Gray(11, "  Reticulating splines..."))
+	if !deleted {
+		return "", fmt.Errorf("Change set %s was still not deleted after 60 seconds, so it cannot be replaced", d.changeSetName)
+	}
 
-		snsClient := sns.New(session, awsCfg)
+	d.log.Infof("%s", au.Gray(11, "  Creating changeset..."))
+	createChangeSetOutput, createChangeSetErr := d.cfn.CreateChangeSetWithContext(d.ctx, input)
+	if createChangeSetErr != nil {
+		d.log.Infof(" %s\n", au.Red("✕"))
+		return "", createChangeSetErr
+	}
+	return aws.StringValue(createChangeSetOutput.Id), nil
+}
 
-		subscribeInput := sns.SubscribeInput{Endpoint: aws.String(config.Cmd.Deploy.Notify.Endpoint), TopicArn: aws.String(config.Cmd.Deploy.Notify.TopicArn), Protocol: aws.String("http")}
-		_, subscribeErr := snsClient.Subscribe(&subscribeInput)
-		if subscribeErr != nil {
-			log.Errorf("%s\n", subscribeErr)
-		} else {
-			var notificationArns []*string
-			notificationArns = append(notificationArns, aws.String(config.Cmd.Deploy.Notify.TopicArn))
-			createChangeSetInput.SetNotificationARNs(notificationArns)
-			log.Check()
+// changeSetSettingsDescription returns a Description holding a hash of the settings of the change set besides its template,
+// parameters, and tags. DescribeChangeSet does not return RoleARN, so comparing the Description is the only way to tell
+// whether an existing change set was created with the same role.
+func changeSetSettingsDescription(input *cloudformation.CreateChangeSetInput) string {
+	settings := struct {
+		ChangeSetType, RoleARN string
+		Capabilities           []string
+		NotificationARNs       []string
+		RollbackConfiguration  *cloudformation.RollbackConfiguration
+	}{
+		ChangeSetType:         aws.StringValue(input.ChangeSetType),
+		RoleARN:               aws.StringValue(input.RoleARN),
+		Capabilities:          aws.StringValueSlice(input.Capabilities),
+		NotificationARNs:      aws.StringValueSlice(input.NotificationARNs),
+		RollbackConfiguration: input.RollbackConfiguration,
+	}
+	sort.Strings(settings.Capabilities)
+	sort.Strings(settings.NotificationARNs)
+	settingsJSON, _ := json.Marshal(settings)
+	return fmt.Sprintf("stx settings %x", sha1.Sum(settingsJSON))
+}
+
+// maskedParameterValue is how DescribeChangeSet and DescribeStackSet return the values of NoEcho parameters
+const maskedParameterValue = "****"
+
+// sameParameters returns true if an existing change set or stack set has the input parameters. DescribeChangeSet and
+// DescribeStackSet list every template parameter, so parameters the input leaves out must have their Default. NoEcho values
+// are masked and cannot be compared, so they are never the same. An input with UsePreviousValue keeps the existing value.
+func sameParameters(templateParameters map[string]stx.TemplateParameter, existing, input []*cloudformation.Parameter) bool {
+	existingValues := parameterValues(existing)
+	inputValues := parameterValues(input)
+	for key, inputValue := range inputValues {
+		if _, ok := existingValues[key]; ok && inputValue == "${UsePreviousValue}" {
+			inputValues[key] = existingValues[key]
+		}
+	}
+	for key, existingValue := range existingValues {
+		if existingValue == maskedParameterValue {
+			return false
+		}
+		if _, ok := inputValues[key]; ok {
+			continue
+		}
+		if templateParameters[key].Default == nil {
+			return false
+		}
+		defaultValue, encodeErr := templateParameters[key].Encode(templateParameters[key].Default)
+		if encodeErr != nil || defaultValue != existingValue {
+			return false
 		}
 	}
+	for key, inputValue := range inputValues {
+		if existingValue, ok := existingValues[key]; !ok || existingValue != inputValue {
+			return false
+		}
+	}
+	return true
+}
 
-	log.Infof("%s", au.Gray(11, "  Creating changeset..."))
+// parameterValues maps parameter keys to their values for comparison. Previous values compare by key only.
+func parameterValues(parameters []*cloudformation.Parameter) map[string]string {
+	values := make(map[string]string)
+	for _, parameter := range parameters {
+		if aws.BoolValue(parameter.UsePreviousValue) {
+			values[aws.StringValue(parameter.ParameterKey)] = "${UsePreviousValue}"
+			continue
+		}
+		values[aws.StringValue(parameter.ParameterKey)] = aws.StringValue(parameter.ParameterValue)
+	}
+	return values
+}
 
-	_, createChangeSetErr := cfn.CreateChangeSet(&createChangeSetInput)
+// tagValues maps tag keys to their values for comparison
+func tagValues(tags []*cloudformation.Tag) map[string]string {
+	values := make(map[string]string)
+	for _, tag := range tags {
+		values[aws.StringValue(tag.Key)] = aws.StringValue(tag.Value)
+	}
+	return values
+}
 
-	if createChangeSetErr != nil {
-		if awsErr, ok := createChangeSetErr.(awserr.Error); ok {
-			log.Infof(" %s\n", au.Red(awsErr))
-			if awsErr.Code() == "AlreadyExistsException" {
-				var deleteChangesetInput cloudformation.DeleteChangeSetInput
-				deleteChangesetInput.ChangeSetName = createChangeSetInput.ChangeSetName
-				deleteChangesetInput.StackName = createChangeSetInput.StackName
-				log.Infof("%s %s\n", au.White("Deleting"), au.BrightBlue(changeSetName))
-				_, deleteChangeSetErr := cfn.DeleteChangeSet(&deleteChangesetInput)
-				if deleteChangeSetErr != nil {
-					log.Error(deleteChangeSetErr)
-				}
-				return
-			}
+// deleteChangeSet deletes the change set created by prepareDeployment
+func (d *stackDeployment) deleteChangeSet() {
+	deleteChangesetInput := cloudformation.DeleteChangeSetInput{
+		ChangeSetName: aws.String(d.changeSetName),
+		StackName:     aws.String(d.stack.Name),
+	}
+	d.log.Infof("%s %s\n", au.White("Deleting"), au.BrightBlue(d.changeSetName))
+	untrack(d)
+	_, deleteChangeSetErr := d.cfn.DeleteChangeSet(&deleteChangesetInput)
+	if deleteChangeSetErr != nil {
+		d.log.Error(deleteChangeSetErr)
+	}
+}
+
+// loadSettingsDiffs compares the declared StackPolicy and EnableTerminationProtection with the stack, so the differences
+// are approved together with the change set. A stack that has not been created yet has neither.
+func (d *stackDeployment) loadSettingsDiffs() error {
+	if !d.existingStack() {
+		d.settingsDiffs = newStackSettingsDiffs(d.stack)
+	} else {
+		diffs, diffsErr := stackSettingsDiffs(d.cfn, d.stack)
+		if diffsErr != nil {
+			return diffsErr
 		}
-		log.Fatal(createChangeSetErr)
+		d.settingsDiffs = appliedSettings(diffs)
 	}
+	return nil
+}
 
-	describeChangesetInput := cloudformation.DescribeChangeSetInput{
-		ChangeSetName: aws.String(changeSetName),
+// name returns what is approved: the change set, or the stack settings when there is no change set
+func (d *stackDeployment) name() string {
+	if d.settingsOnly {
+		return "stack settings"
+	}
+	return d.changeSetName
+}
+
+// autoApprove evaluates the change set and settings against Cmd:Deploy:AutoApprove:Policy and returns true if they may be executed
+func (d *stackDeployment) autoApprove() bool {
+	violations := autoApproveViolations(d.changes)
+	if d.stackSet != nil {
+		violations = d.stackSetApproveViolations()
+	}
+	violations = append(violations, settingsApproveViolations(d.settingsDiffs)...)
+	if len(violations) > 0 {
+		d.log.Errorf("Refusing to auto-approve %s under policy %s:\n  %s\n", d.name(), config.Cmd.Deploy.AutoApprove.Policy, strings.Join(violations, "\n  "))
+		return false
+	}
+	d.log.Infof("%s %s %s\n", au.White("Auto-approved"), au.BrightBlue(d.name()), au.Gray(11, "(policy "+config.Cmd.Deploy.AutoApprove.Policy+")"))
+	return true
+}
+
+// approve returns true if the change set should be executed, either by policy or by prompting
+func (d *stackDeployment) approve() bool {
+	if flags.DeployYes {
+		return d.autoApprove()
+	}
+
+	prompt := "Execute change set"
+	if d.stackSet != nil {
+		prompt = "Execute"
+	} else if d.settingsOnly {
+		prompt = "Apply"
+	}
+	return promptYes(d.ctx, fmt.Sprintf("%s %s %s %s %s:%s:%s %s", au.Index(255-88, prompt), au.BrightBlue(d.name()), au.Index(255-88, "on"), au.White("⤏"), au.Magenta(d.stack.Name), au.Green(d.stack.Profile), au.Cyan(d.stack.Region), au.Index(255-88, "?")))
+}
+
+// promptYes asks the question and waits for the user to enter y or yes. It returns false if ctx is cancelled first.
+func promptYes(ctx context.Context, question string) bool {
+	promptMu.Lock()
+	defer promptMu.Unlock()
+
+	log.Infof("%s\n%s\n%s", question, au.Gray(11, "Y to execute. Anything else to cancel."), au.Gray(11, "▶︎"))
+	input, _ := readLine(ctx)
+	input = strings.ToLower(strings.TrimSpace(input))
+	matched, _ := regexp.MatchString("^(y){1}(es)?$", input)
+	return matched
+}
+
+// execute executes the change set, then optionally waits for the stack and saves its outputs
+func (d *stackDeployment) execute() {
+	if d.stackSet != nil {
+		d.executeStackSet()
+		return
+	}
+	stack := d.stack
+	if d.settingsOnly {
+		if settingsErr := applyStackSettings(d.log, d.cfn, stack.Name, d.settingsDiffs); settingsErr != nil {
+			deployFailed(d.log, stack, settingsErr)
+			return
+		}
+		recordResult(stack, outcomeSucceeded, "stack settings applied")
+		return
+	}
+	executeChangeSetInput := cloudformation.ExecuteChangeSetInput{
+		ChangeSetName: aws.String(d.changeSetName),
 		StackName:     aws.String(stack.Name),
 	}
 
-	waitOption := request.WithWaiterDelay(request.ConstantWaiterDelay(5 * time.Second))
-	cfn.WaitUntilChangeSetCreateCompleteWithContext(context.Background(), &describeChangesetInput, waitOption)
+	d.log.Infof("%s %s %s %s:%s\n", au.White("Executing"), au.BrightBlue(d.changeSetName), au.White("⤏"), au.Magenta(stack.Name), au.Cyan(stack.Region))
 
-	log.Check()
+	// the stack policy of an existing stack applies to this update, while a new stack only exists once it has been created
+	existingStack := d.existingStack()
+	if existingStack {
+		if settingsErr := applyStackSettings(d.log, d.cfn, stack.Name, d.settingsDiffs); settingsErr != nil {
+			deployFailed(d.log, stack, settingsErr)
+			return
+		}
+	}
 
-	log.Infof("%s %s %s %s:%s\n", au.White("Describing"), au.BrightBlue(changeSetName), au.White("⤎"), au.Magenta(stack.Name), au.Cyan(stack.Region))
-	describeChangesetOuput, describeChangesetErr := cfn.DescribeChangeSet(&describeChangesetInput)
-	if describeChangesetErr != nil {
-		log.Fatalf("%+v", au.Red(describeChangesetErr))
+	wait := flags.DeploySave || flags.DeployWait || stack.TimeoutInMinutes > 0
+	var stream *eventStream
+	if wait {
+		stream = newEventStream(d.log, d.cfn, stack.Name)
 	}
 
-	if aws.StringValue(describeChangesetOuput.ExecutionStatus) != "AVAILABLE" || aws.StringValue(describeChangesetOuput.Status) != "CREATE_COMPLETE" {
-		//TODO put describeChangesetOuput into table view
-		log.Infof("%+v\n", describeChangesetOuput)
-		log.Info(au.Yellow("No changes to deploy."))
-		var deleteChangesetInput cloudformation.DeleteChangeSetInput
-		deleteChangesetInput.ChangeSetName = createChangeSetInput.ChangeSetName
-		deleteChangesetInput.StackName = createChangeSetInput.StackName
-		log.Infof("%s %s\n", au.White("Deleting"), au.BrightBlue(changeSetName))
-		_, deleteChangeSetErr := cfn.DeleteChangeSet(&deleteChangesetInput)
-		if deleteChangeSetErr != nil {
-			log.Error(deleteChangeSetErr)
+	_, executeChangeSetErr := d.cfn.ExecuteChangeSetWithContext(d.ctx, &executeChangeSetInput)
+
+	if executeChangeSetErr != nil {
+		deployFailed(d.log, stack, executeChangeSetErr)
+		return
+	}
+	trackExecuting(d)
+	defer untrack(d)
+
+	if !wait {
+		if !existingStack && len(d.settingsDiffs) > 0 {
+			d.log.Warn("StackPolicy and EnableTerminationProtection are applied once the stack is created; use --wait, or deploy again.")
 		}
+		recordResult(stack, outcomeExecuted, "not waited for")
 		return
 	}
 
-	if len(describeChangesetOuput.Changes) > 0 {
-		// log.Infof("%+v\n", describeChangesetOuput.Changes)
-		table := tablewriter.NewWriter(os.Stdout)
-		table.SetAutoWrapText(false)
-		table.SetAutoMergeCells(true)
-		table.SetRowLine(true)
-		table.SetHeader([]string{"Resource", "Action", "Attribute", "Property", "Recreation"})
-		table.SetHeaderColor(tablewriter.Colors{tablewriter.FgWhiteColor}, tablewriter.Colors{tablewriter.FgWhiteColor}, tablewriter.Colors{tablewriter.FgWhiteColor}, tablewriter.Colors{tablewriter.FgWhiteColor}, tablewriter.Colors{tablewriter.FgWhiteColor})
-
-		for _, change := range describeChangesetOuput.Changes {
-
-			row := []string{
-				aws.StringValue(change.ResourceChange.LogicalResourceId),
-				aws.StringValue(change.ResourceChange.Action),
-				"",
-				"",
-				"",
-			}
+	d.log.Infof("%s\n", au.Gray(11, "  Waiting for stack..."))
+	describeStacksInput := cloudformation.DescribeStacksInput{StackName: aws.String(stack.Name)}
+	waitOption := request.WithWaiterDelay(request.ConstantWaiterDelay(5 * time.Second))
 
-			if aws.StringValue(change.ResourceChange.Action) == "Modify" {
-				for _, detail := range change.ResourceChange.Details {
-					row[2] = aws.StringValue(detail.Target.Attribute)
-					row[3] = aws.StringValue(detail.Target.Name)
-					recreation := aws.StringValue(detail.Target.RequiresRecreation)
+	// print events until the waiter returns
+	streamCtx, stopStream := context.WithCancel(d.ctx)
+	streamDone := make(chan struct{})
+	go func() {
+		stream.follow(streamCtx, 5*time.Second)
+		close(streamDone)
+	}()
+
+	// change sets cannot set TimeoutInMinutes, so it limits how long deploy waits instead
+	waitCtx := d.ctx
+	if stack.TimeoutInMinutes > 0 {
+		var cancelWait context.CancelFunc
+		waitCtx, cancelWait = context.WithTimeout(d.ctx, time.Duration(stack.TimeoutInMinutes)*time.Minute)
+		defer cancelWait()
+	}
 
-					if recreation == "ALWAYS" || recreation == "CONDITIONAL" {
-						row[4] = au.Red(recreation).String()
-					} else {
-						row[4] = recreation
-					}
-					table.Append(row)
-				}
-			} else {
-				table.Append(row)
+	var waitErr error
+	switch d.changeSetType {
+	case "UPDATE":
+		waitErr = d.cfn.WaitUntilStackUpdateCompleteWithContext(waitCtx, &describeStacksInput, waitOption)
+	case "CREATE":
+		waitErr = d.cfn.WaitUntilStackCreateCompleteWithContext(waitCtx, &describeStacksInput, waitOption)
+	case "IMPORT":
+		waitErr = d.cfn.WaitUntilStackImportCompleteWithContext(waitCtx, &describeStacksInput, waitOption)
+	}
+
+	// an interrupt is handled by handleInterrupts, which decides what happens to the stack
+	if d.ctx.Err() != nil {
+		stopStream()
+		<-streamDone
+		return
+	}
+
+	if waitCtx.Err() == context.DeadlineExceeded {
+		d.log.Errorf("%s did not finish within TimeoutInMinutes %d\n", au.Magenta(stack.Name), stack.TimeoutInMinutes)
+		if d.changeSetType == "UPDATE" {
+			d.log.Infof("%s %s\n", au.White("Cancelling update of"), au.Magenta(stack.Name))
+			if _, cancelErr := d.cfn.CancelUpdateStack(&cloudformation.CancelUpdateStackInput{StackName: aws.String(stack.Name)}); cancelErr != nil {
+				d.log.Error(cancelErr)
 			}
 		}
-		table.Render()
 	}
+	stopStream()
+	<-streamDone
+
+	// the waiter also stops on throttling or timeouts, so the final status decides the outcome
+	stackStatus, statusErr := describeStackStatus(d.cfn, stack.Name)
+	if statusErr != nil {
+		deployFailed(d.log, stack, statusErr)
+		return
+	}
+	if failedStackStatus(stackStatus) || waitErr != nil {
+		stream.printFailures(5)
+		details := stackStatus
+		if cause := stream.rootCause(); cause != "" {
+			details += ": " + cause
+		}
+		deployFailed(d.log, stack, fmt.Errorf("%s", details))
+		return
+	}
+	d.log.Infof("%s %s\n", au.Magenta(stack.Name), au.BrightGreen(stackStatus))
 
-	diff(cfn, stack.Name, templateBody)
+	if !existingStack {
+		if settingsErr := applyStackSettings(d.log, d.cfn, stack.Name, d.settingsDiffs); settingsErr != nil {
+			deployFailed(d.log, stack, settingsErr)
+			return
+		}
+	}
 
-	log.Infof("%s %s %s %s %s:%s:%s %s\n", au.Index(255-88, "Execute change set"), au.BrightBlue(changeSetName), au.Index(255-88, "on"), au.White("⤏"), au.Magenta(stack.Name), au.Green(stack.Profile), au.Cyan(stack.Region), au.Index(255-88, "?"))
-	log.Infof("%s\n%s", au.Gray(11, "Y to execute. Anything else to cancel."), au.Gray(11, "▶︎"))
-	var input string
-	fmt.Scanln(&input)
+	if flags.DeploySave {
+		saveErr := saveStackOutputs(d.buildInstance, stack)
+		if saveErr != nil {
+			deployFailed(d.log, stack, fmt.Errorf("%s, but saving outputs failed: %s", stackStatus, saveErr))
+			return
+		}
+	}
+	recordResult(stack, outcomeSucceeded, stackStatus)
+}
 
-	input = strings.ToLower(input)
-	matched, _ := regexp.MatchString("^(y){1}(es)?$", input)
-	if !matched {
-		// delete changeset and continue
-		var deleteChangesetInput cloudformation.DeleteChangeSetInput
-		deleteChangesetInput.ChangeSetName = createChangeSetInput.ChangeSetName
-		deleteChangesetInput.StackName = createChangeSetInput.StackName
-		log.Infof("%s %s\n", au.White("Deleting"), au.BrightBlue(changeSetName))
-		_, deleteChangeSetErr := cfn.DeleteChangeSet(&deleteChangesetInput)
-		if deleteChangeSetErr != nil {
-			log.Error(deleteChangeSetErr)
+// existingStack returns true if the change set changes a stack that has already been created.
+// A stack created by an IMPORT change set waits in REVIEW_IN_PROGRESS until the change set is executed.
+func (d *stackDeployment) existingStack() bool {
+	switch d.changeSetType {
+	case "CREATE":
+		return false
+	case "IMPORT":
+		stackStatus, statusErr := describeStackStatus(d.cfn, d.stack.Name)
+		return statusErr == nil && stackStatus != "REVIEW_IN_PROGRESS"
+	}
+	return true
+}
+
+// cancel deletes the change set without executing it
+func (d *stackDeployment) cancel(reason string) {
+	// stack set operations are only started once approved, so there is nothing to delete
+	if d.stackSet == nil && !d.settingsOnly {
+		d.deleteChangeSet()
+	}
+	recordResult(d.stack, outcomeCancelled, reason)
+}
+
+// describeStackStatus returns the current StackStatus of the stack
+func describeStackStatus(cfn *cloudformation.CloudFormation, stackName string) (string, error) {
+	describeStacksOutput, describeStacksErr := cfn.DescribeStacks(&cloudformation.DescribeStacksInput{StackName: aws.String(stackName)})
+	if describeStacksErr != nil {
+		return "", describeStacksErr
+	}
+	if len(describeStacksOutput.Stacks) < 1 {
+		return "", fmt.Errorf("stack %s not found", stackName)
+	}
+	return aws.StringValue(describeStacksOutput.Stacks[0].StackStatus), nil
+}
+
+// failedStackStatus returns true for ROLLBACK_* and *_FAILED states, including UPDATE_ROLLBACK_COMPLETE
+func failedStackStatus(status string) bool {
+	return strings.Contains(status, "ROLLBACK") || strings.HasSuffix(status, "_FAILED")
+}
+
+// noChangesReason returns true if a failed change set only failed because there was nothing to change
+func noChangesReason(statusReason string) bool {
+	return strings.Contains(statusReason, "didn't contain changes") || strings.Contains(statusReason, "No updates are to be performed")
+}
+
+// deployStack creates a change set for a single stack, prompts for approval, and executes it
+func deployStack(ctx context.Context, dplArgs deployArgs) {
+	d := prepareDeployment(ctx, dplArgs, log)
+	if d == nil {
+		return
+	}
+	if flags.DeployPlanOut != "" {
+		addToPlan(d)
+		return
+	}
+	if !d.approve() {
+		reason := "not approved"
+		if flags.DeployYes {
+			reason = "refused by AutoApprove policy " + config.Cmd.Deploy.AutoApprove.Policy
 		}
+		d.cancel(reason)
 		return
 	}
+	d.execute()
+}
 
-	executeChangeSetInput := cloudformation.ExecuteChangeSetInput{
-		ChangeSetName: aws.String(changeSetName),
-		StackName:     aws.String(stack.Name),
+// deployLayer deploys stacks that do not depend on one another with up to --parallel stacks in flight.
+// Change sets for the whole layer are created first, then approved in a single step, then executed.
+func deployLayer(ctx context.Context, layer []deployArgs) {
+	if flags.DeployParallel < 2 || len(layer) < 2 {
+		for _, dplArgs := range layer {
+			if ctx.Err() != nil {
+				return
+			}
+			deployStack(ctx, dplArgs)
+		}
+		return
 	}
 
-	log.Infof("%s %s %s %s:%s\n", au.White("Executing"), au.BrightBlue(changeSetName), au.White("⤏"), au.Magenta(stack.Name), au.Cyan(stack.Region))
+	// prefix every line with the stack name, padded so that output lines up
+	width := 0
+	for _, dplArgs := range layer {
+		if len(dplArgs.stack.Name) > width {
+			width = len(dplArgs.stack.Name)
+		}
+	}
+	writers := make([]*logger.PrefixWriter, 0, 2*len(layer))
+	loggers := make([]*logger.Logger, len(layer))
+	for i, dplArgs := range layer {
+		prefix := au.Magenta(fmt.Sprintf("%-*s │ ", width, dplArgs.stack.Name)).String()
+		stdout, stderr := logger.NewPrefixWriter(os.Stdout, prefix), logger.NewPrefixWriter(os.Stderr, prefix)
+		writers = append(writers, stdout, stderr)
+		loggers[i] = log.WithOutput(stdout, stderr)
+	}
+	flushWriters := func() {
+		for _, w := range writers {
+			w.Flush()
+		}
+	}
 
-	_, executeChangeSetErr := cfn.ExecuteChangeSet(&executeChangeSetInput)
+	deployments := make([]*stackDeployment, len(layer))
+	runParallel(len(layer), func(i int) {
+		deployments[i] = prepareDeployment(ctx, layer[i], loggers[i])
+	})
+	flushWriters()
+	if ctx.Err() != nil {
+		return
+	}
 
-	if executeChangeSetErr != nil {
-		log.Fatal(executeChangeSetErr)
+	var pending []*stackDeployment
+	for _, d := range deployments {
+		if d != nil {
+			pending = append(pending, d)
+		}
+	}
+	if len(pending) < 1 {
+		return
+	}
+	if flags.DeployPlanOut != "" {
+		for _, d := range pending {
+			addToPlan(d)
+		}
+		return
 	}
 
-	if flags.DeploySave || flags.DeployWait {
-		log.Infof("%s", au.Gray(11, "  Waiting for stack..."))
-		switch changeSetType {
-		case "UPDATE":
-			cfn.WaitUntilStackUpdateCompleteWithContext(context.Background(), &describeStacksInput, waitOption)
-		case "CREATE":
-			cfn.WaitUntilStackCreateCompleteWithContext(context.Background(), &describeStacksInput, waitOption)
+	var approved []*stackDeployment
+	if flags.DeployYes {
+		for _, d := range pending {
+			if d.autoApprove() {
+				approved = append(approved, d)
+			} else {
+				d.cancel("refused by AutoApprove policy " + config.Cmd.Deploy.AutoApprove.Policy)
+			}
 		}
-		log.Check()
+		flushWriters()
+	} else {
+		question := au.Index(255-88, fmt.Sprintf("Execute %d change sets?", len(pending))).String()
+		for _, d := range pending {
+			question += fmt.Sprintf("\n  %s %s %s:%s:%s", au.BrightBlue(d.name()), au.White("⤏"), au.Magenta(d.stack.Name), au.Green(d.stack.Profile), au.Cyan(d.stack.Region))
+		}
+		if promptYes(ctx, question) {
+			approved = pending
+		} else {
+			for _, d := range pending {
+				d.cancel("not approved")
+			}
+			flushWriters()
+			return
+		}
+	}
 
-		if flags.DeploySave {
-			saveErr := saveStackOutputs(buildInstance, stack)
-			if saveErr != nil {
-				log.Fatal(saveErr)
+	runParallel(len(approved), func(i int) {
+		approved[i].execute()
+	})
+	flushWriters()
+}
+
+// runParallel calls fn for every index in [0, n) with at most --parallel calls in flight, and waits for all of them
+func runParallel(n int, fn func(i int)) {
+	var wg sync.WaitGroup
+	sem := make(chan struct{}, flags.DeployParallel)
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(i int) {
+			defer wg.Done()
+			defer func() { <-sem }()
+			fn(i)
+		}(i)
+	}
+	wg.Wait()
+}
+
+// autoApproveViolations evaluates changes against Cmd:Deploy:AutoApprove:Policy
+// and returns a description of every change that the policy does not allow
+func autoApproveViolations(changes []*cloudformation.Change) []string {
+	var violations []string
+	if config.Cmd.Deploy.AutoApprove.Policy == "Any" {
+		return violations
+	}
+
+	for _, change := range changes {
+		resourceChange := change.ResourceChange
+		if resourceChange == nil {
+			continue
+		}
+		logicalID := aws.StringValue(resourceChange.LogicalResourceId)
+		if aws.StringValue(resourceChange.Action) == "Remove" {
+			violations = append(violations, logicalID+" would be removed")
+			continue
+		}
+		for _, detail := range resourceChange.Details {
+			recreation := aws.StringValue(detail.Target.RequiresRecreation)
+			if recreation == "ALWAYS" || recreation == "CONDITIONAL" {
+				violations = append(violations, fmt.Sprintf("%s.%s requires recreation (%s)", logicalID, aws.StringValue(detail.Target.Name), recreation))
 			}
 		}
 	}
+	return violations
 }
@@ -0,0 +1,90 @@
+package cmd
+
+import (
+	"testing"
+
+	"github.com/TangoGroup/stx/stx"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/cloudformation"
+)
+
+func TestChangeSetSettingsDescription(t *testing.T) {
+	base := func() *cloudformation.CreateChangeSetInput {
+		return &cloudformation.CreateChangeSetInput{
+			ChangeSetType:    aws.String("UPDATE"),
+			Capabilities:     aws.StringSlice([]string{"CAPABILITY_IAM", "CAPABILITY_AUTO_EXPAND"}),
+			NotificationARNs: aws.StringSlice([]string{"arn:aws:sns:us-west-2:123456789012:a", "arn:aws:sns:us-west-2:123456789012:b"}),
+			RoleARN:          aws.String("arn:aws:iam::123456789012:role/deploy"),
+		}
+	}
+	description := changeSetSettingsDescription(base())
+
+	reordered := base()
+	reordered.Capabilities = aws.StringSlice([]string{"CAPABILITY_AUTO_EXPAND", "CAPABILITY_IAM"})
+	reordered.NotificationARNs = aws.StringSlice([]string{"arn:aws:sns:us-west-2:123456789012:b", "arn:aws:sns:us-west-2:123456789012:a"})
+	if changeSetSettingsDescription(reordered) != description {
+		t.Error("the order of Capabilities and NotificationARNs changed the description")
+	}
+
+	changes := map[string]func(*cloudformation.CreateChangeSetInput){
+		"RoleARN": func(input *cloudformation.CreateChangeSetInput) {
+			input.RoleARN = aws.String("arn:aws:iam::123456789012:role/other")
+		},
+		"no RoleARN": func(input *cloudformation.CreateChangeSetInput) { input.RoleARN = nil },
+		"Capabilities": func(input *cloudformation.CreateChangeSetInput) {
+			input.Capabilities = aws.StringSlice([]string{"CAPABILITY_IAM"})
+		},
+		"NotificationARNs": func(input *cloudformation.CreateChangeSetInput) { input.NotificationARNs = nil },
+		"ChangeSetType":    func(input *cloudformation.CreateChangeSetInput) { input.ChangeSetType = aws.String("CREATE") },
+		"RollbackConfiguration": func(input *cloudformation.CreateChangeSetInput) {
+			input.RollbackConfiguration = &cloudformation.RollbackConfiguration{MonitoringTimeInMinutes: aws.Int64(5)}
+		},
+	}
+	for name, change := range changes {
+		input := base()
+		change(input)
+		if changeSetSettingsDescription(input) == description {
+			t.Errorf("changing %s did not change the description", name)
+		}
+	}
+}
+
+func TestSameParameters(t *testing.T) {
+	templateParameters := map[string]stx.TemplateParameter{
+		"Size":     {Type: "Number", Default: 2},
+		"Name":     {Type: "String"},
+		"Password": {Type: "String", NoEcho: true},
+	}
+	parameters := func(values map[string]string) []*cloudformation.Parameter {
+		var list []*cloudformation.Parameter
+		for key, value := range values {
+			if value == "${UsePreviousValue}" {
+				list = append(list, &cloudformation.Parameter{ParameterKey: aws.String(key), UsePreviousValue: aws.Bool(true)})
+				continue
+			}
+			list = append(list, &cloudformation.Parameter{ParameterKey: aws.String(key), ParameterValue: aws.String(value)})
+		}
+		return list
+	}
+
+	tests := []struct {
+		name            string
+		existing, input map[string]string
+		same            bool
+	}{
+		{"same values", map[string]string{"Size": "3", "Name": "a"}, map[string]string{"Size": "3", "Name": "a"}, true},
+		{"left out at its Default", map[string]string{"Size": "2", "Name": "a"}, map[string]string{"Name": "a"}, true},
+		{"left out away from its Default", map[string]string{"Size": "3", "Name": "a"}, map[string]string{"Name": "a"}, false},
+		{"left out without a Default", map[string]string{"Name": "a"}, map[string]string{}, false},
+		{"changed value", map[string]string{"Name": "a"}, map[string]string{"Name": "b"}, false},
+		{"new key", map[string]string{"Name": "a"}, map[string]string{"Name": "a", "Other": "b"}, false},
+		{"previous value", map[string]string{"Name": "a"}, map[string]string{"Name": "${UsePreviousValue}"}, true},
+		{"masked NoEcho value", map[string]string{"Name": "a", "Password": "****"}, map[string]string{"Name": "a", "Password": "secret"}, false},
+		{"masked previous NoEcho value", map[string]string{"Name": "a", "Password": "****"}, map[string]string{"Name": "a", "Password": "${UsePreviousValue}"}, false},
+	}
+	for _, test := range tests {
+		if same := sameParameters(templateParameters, parameters(test.existing), parameters(test.input)); same != test.same {
+			t.Errorf("%s: got %v, want %v", test.name, same, test.same)
+		}
+	}
+}
@@ -1,12 +1,13 @@
 package cmd
 
 import (
+	"bytes"
 	"io/ioutil"
-	"os"
 	"regexp"
 
 	"cuelang.org/go/cue"
 	"cuelang.org/go/cue/build"
+	"github.com/TangoGroup/stx/logger"
 	"github.com/TangoGroup/stx/stx"
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/service/cloudformation"
@@ -26,11 +27,24 @@ in CloudFormation, then produce a rich, functional, property-based diff (not
 text-based) against the two templates.
 
 Diff is an implementation of https://github.com/homeport/dyff
+
+Diff also reports StackPolicy, EnableTerminationProtection,
+RollbackConfiguration, and NotificationARNs declared on the stack that differ
+from the live stack.
+
+Local artifacts are rewritten to the S3 locations deploy would upload them to,
+as with stx package, but diff does not upload them.
+
+For stacks that declare a StackSet, diff compares against the stack set's
+template and shows a table of its instances, including declared instances that
+deploy would create.
 `,
 	Run: func(cmd *cobra.Command, args []string) {
 
 		defer log.Flush()
-		stx.EnsureVaultSession(config)
+		if sessionErr := stx.EnsureSession(config); sessionErr != nil {
+			log.Fatal(sessionErr)
+		}
 
 		buildInstances := stx.GetBuildInstances(args, config.PackageName)
 
@@ -49,19 +63,29 @@ Diff is an implementation of https://github.com/homeport/dyff
 					continue
 				}
 
-				fileName, saveErr := saveStackAsYml(stack, buildInstance, stackValue)
+				fileName, saveErr := saveStackAsYml(log, stack, buildInstance, stackValue, true)
 				if saveErr != nil {
 					log.Error(saveErr)
+					continue
 				}
 
 				// get a session and cloudformation service client
-				session := stx.GetSession(stack.Profile)
+				session, sessionErr := stx.GetStackSession(stack)
+				if sessionErr != nil {
+					log.Error(sessionErr)
+					continue
+				}
 				cfn := cloudformation.New(session, aws.NewConfig().WithRegion(stack.Region))
 
 				// read template from disk
 				templateFileBytes, _ := ioutil.ReadFile(fileName)
 				templateBody := string(templateFileBytes)
 
+				if stack.StackSet != nil {
+					diffStackSet(log, cfn, stack, templateBody)
+					continue
+				}
+
 				// look to see if stack exists
 				describeStacksInput := cloudformation.DescribeStacksInput{StackName: aws.String(stack.Name)}
 				describeStacksOutput, describeStacksErr := cfn.DescribeStacks(&describeStacksInput)
@@ -72,43 +96,71 @@ Diff is an implementation of https://github.com/homeport/dyff
 					continue
 				}
 
-				diff(cfn, stack.Name, templateBody)
+				diff(log, cfn, stack.Name, templateBody)
+
+				settingsDiffs, settingsErr := stackSettingsDiffs(cfn, stack)
+				if settingsErr != nil {
+					log.Error(settingsErr)
+					continue
+				}
+				printSettingsDiffs(log, stack.Name, settingsDiffs)
 			}
 
 		})
 	},
 }
 
-func diff(cfn *cloudformation.CloudFormation, stackName, templateBody string) {
+func diff(stackLog *logger.Logger, cfn *cloudformation.CloudFormation, stackName, templateBody string) {
 	existingTemplate, err := cfn.GetTemplate(&cloudformation.GetTemplateInput{
 		StackName: &stackName,
 	})
 	if err != nil {
-		log.Error("Error getting template for stack", stackName)
+		stackLog.Error("Error getting template for stack", stackName)
 	} else {
-		r, _ := regexp.Compile("!(Base64|Cidr|FindInMap|GetAtt|GetAZs|ImportValue|Join|Select|Split|Sub|Transform|Ref|And|Equals|If|Not|Or)")
-		if r.MatchString(aws.StringValue(existingTemplate.TemplateBody)) {
-			log.Warn("The existing stack uses short intrinsic functions, unable to create diff: " + stackName)
-		} else {
-			existingDoc, _ := ytbx.LoadDocuments([]byte(aws.StringValue(existingTemplate.TemplateBody)))
-			doc, _ := ytbx.LoadDocuments([]byte(templateBody))
-			report, err := dyff.CompareInputFiles(
-				ytbx.InputFile{Documents: existingDoc},
-				ytbx.InputFile{Documents: doc},
-			)
-			if err != nil {
-				log.Error("Error creating template diff for stack: " + stackName)
-			} else {
-				if len(report.Diffs) > 0 {
-					reportWriter := &dyff.HumanReport{
-						Report:     report,
-						ShowBanner: false,
-					}
-					reportWriter.WriteReport(os.Stdout)
-				}
-			}
+		diffTemplates(stackLog, stackName, aws.StringValue(existingTemplate.TemplateBody), templateBody)
+	}
+}
+
+// diffTemplates renders the differences between the existing template of a stack or stack set and the exported template
+func diffTemplates(stackLog *logger.Logger, stackName, existingBody, templateBody string) {
+	r, _ := regexp.Compile("!(Base64|Cidr|FindInMap|GetAtt|GetAZs|ImportValue|Join|Select|Split|Sub|Transform|Ref|And|Equals|If|Not|Or)")
+	if r.MatchString(existingBody) {
+		stackLog.Warn("The existing stack uses short intrinsic functions, unable to create diff: " + stackName)
+	} else {
+		if err := writeDyff(stackLog, []byte(existingBody), []byte(templateBody)); err != nil {
+			stackLog.Error("Error creating template diff for stack: " + stackName)
+		}
+	}
+}
+
+// writeDyff renders the differences between two yaml or json documents, writing nothing if they are the same
+func writeDyff(stackLog *logger.Logger, from, to []byte) error {
+	fromDoc, fromErr := ytbx.LoadDocuments(from)
+	if fromErr != nil {
+		return fromErr
+	}
+	toDoc, toErr := ytbx.LoadDocuments(to)
+	if toErr != nil {
+		return toErr
+	}
+	report, err := dyff.CompareInputFiles(
+		ytbx.InputFile{Documents: fromDoc},
+		ytbx.InputFile{Documents: toDoc},
+	)
+	if err != nil {
+		return err
+	}
+	if len(report.Diffs) > 0 {
+		reportWriter := &dyff.HumanReport{
+			Report:     report,
+			ShowBanner: false,
 		}
+		// render into a buffer so the report is written as a single block
+		var reportBuf bytes.Buffer
+		reportWriter.WriteReport(&reportBuf)
+		stackLog.Stdout().Write(reportBuf.Bytes())
 	}
+	return nil
 }
 
 func init() {
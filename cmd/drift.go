@@ -0,0 +1,245 @@
+package cmd
+
+import (
+	"context"
+	"os"
+	"time"
+
+	"cuelang.org/go/cue"
+	"cuelang.org/go/cue/build"
+	"github.com/TangoGroup/stx/stx"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/cloudformation"
+	"github.com/olekukonko/tablewriter"
+	"github.com/spf13/cobra"
+)
+
+// driftTimeout bounds how long drift waits for every detection it started to finish
+const driftTimeout = 30 * time.Minute
+
+// driftDetection is a drift detection that has been started for a stack, or for a stack set if operationID is set
+type driftDetection struct {
+	stack       stx.Stack
+	cfn         *cloudformation.CloudFormation
+	detectionID string
+	operationID string
+}
+
+// driftCmd represents the drift command
+var driftCmd = &cobra.Command{
+	Use:   "drift",
+	Short: "Detects drift between the evaluated stacks and their resources.",
+	Long: `Drift operates on every stack found in the evaluated cue files.
+
+Drift starts drift detection on every stack, waits for each to finish, and
+prints a table of each resource's logical ID, type, and drift status. For
+modified resources, the differences between the expected and actual
+properties are shown the same way diff shows template changes.
+
+Stacks that declare a StackSet have drift detected on every stack instance.
+The result of each instance is shown, along with the drift status of the stack
+set.
+
+Drift logs an error for every stack that has drifted or could not be checked,
+so the exit code is non-zero whenever drift exists. This makes it suitable for
+running on a schedule. Detections that have not finished within 30 minutes are
+reported as errors.
+`,
+	Run: func(cmd *cobra.Command, args []string) {
+		defer log.Flush()
+		if sessionErr := stx.EnsureSession(config); sessionErr != nil {
+			log.Fatal(sessionErr)
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), driftTimeout)
+		defer cancel()
+
+		// detection takes a while, so start it on every stack before waiting on any
+		var detections []driftDetection
+		buildInstances := stx.GetBuildInstances(args, config.PackageName)
+		stx.Process(buildInstances, flags, log, func(buildInstance *build.Instance, cueInstance *cue.Instance) {
+			stacksIterator, stacksIteratorErr := stx.NewStacksIterator(cueInstance, flags, log)
+			if stacksIteratorErr != nil {
+				log.Fatal(stacksIteratorErr)
+			}
+
+			for stacksIterator.Next() {
+				stackValue := stacksIterator.Value()
+				var stack stx.Stack
+				decodeErr := stackValue.Decode(&stack)
+				if decodeErr != nil {
+					log.Error(decodeErr)
+					continue
+				}
+
+				session, sessionErr := stx.GetStackSession(stack)
+				if sessionErr != nil {
+					log.Error(sessionErr)
+					continue
+				}
+				if accountErr := stx.VerifyAccount(session, stack); accountErr != nil {
+					log.Error(accountErr)
+					continue
+				}
+				cfn := cloudformation.New(session, aws.NewConfig().WithRegion(stack.Region))
+
+				log.Infof("%s %s %s %s:%s\n", au.White("Detecting drift"), au.White("⤏"), au.Magenta(stack.Name), au.Green(stack.Profile), au.Cyan(stack.Region))
+				if stack.StackSet != nil {
+					detectSetOutput, detectSetErr := cfn.DetectStackSetDriftWithContext(ctx, &cloudformation.DetectStackSetDriftInput{
+						StackSetName:         aws.String(stack.Name),
+						OperationPreferences: operationPreferences(stack.StackSet.OperationPreferences),
+					})
+					if detectSetErr != nil {
+						log.Error(detectSetErr)
+						continue
+					}
+					detections = append(detections, driftDetection{stack: stack, cfn: cfn, operationID: aws.StringValue(detectSetOutput.OperationId)})
+					continue
+				}
+				detectOutput, detectErr := cfn.DetectStackDriftWithContext(ctx, &cloudformation.DetectStackDriftInput{StackName: aws.String(stack.Name)})
+				if detectErr != nil {
+					log.Error(detectErr)
+					continue
+				}
+				detections = append(detections, driftDetection{stack: stack, cfn: cfn, detectionID: aws.StringValue(detectOutput.StackDriftDetectionId)})
+			}
+		})
+
+		for _, detection := range detections {
+			if detection.operationID != "" {
+				printStackSetDrift(ctx, detection)
+				continue
+			}
+			printDrift(ctx, detection)
+		}
+	},
+}
+
+// printDrift waits for drift detection to finish, then renders the drift of each resource
+func printDrift(ctx context.Context, detection driftDetection) {
+	stack, cfn := detection.stack, detection.cfn
+
+	var status *cloudformation.DescribeStackDriftDetectionStatusOutput
+	for {
+		var statusErr error
+		status, statusErr = cfn.DescribeStackDriftDetectionStatusWithContext(ctx, &cloudformation.DescribeStackDriftDetectionStatusInput{StackDriftDetectionId: aws.String(detection.detectionID)})
+		if statusErr != nil {
+			log.Errorf("%s %s\n", au.Magenta(stack.Name), statusErr)
+			return
+		}
+		if aws.StringValue(status.DetectionStatus) != cloudformation.StackDriftDetectionStatusDetectionInProgress {
+			break
+		}
+		select {
+		case <-ctx.Done():
+			log.Errorf("%s %s\n", au.Magenta(stack.Name), au.Red("drift detection did not finish in time"))
+			return
+		case <-time.After(5 * time.Second):
+		}
+	}
+
+	// detection can fail for some resources and still report on the rest
+	if aws.StringValue(status.DetectionStatus) == cloudformation.StackDriftDetectionStatusDetectionFailed {
+		log.Errorf("%s %s: %s\n", au.Magenta(stack.Name), au.Red(aws.StringValue(status.DetectionStatus)), aws.StringValue(status.DetectionStatusReason))
+	}
+
+	var drifts []*cloudformation.StackResourceDrift
+	driftsInput := cloudformation.DescribeStackResourceDriftsInput{StackName: aws.String(stack.Name)}
+	for {
+		page, driftsErr := cfn.DescribeStackResourceDriftsWithContext(ctx, &driftsInput)
+		if driftsErr != nil {
+			log.Error(driftsErr)
+			return
+		}
+		drifts = append(drifts, page.StackResourceDrifts...)
+		if page.NextToken == nil {
+			break
+		}
+		driftsInput.NextToken = page.NextToken
+	}
+
+	stackDriftStatus := aws.StringValue(status.StackDriftStatus)
+	if stackDriftStatus == cloudformation.StackDriftStatusInSync {
+		log.Infof("%s %s\n", au.Magenta(stack.Name), au.BrightGreen(stackDriftStatus))
+	} else {
+		log.Errorf("%s %s\n", au.Magenta(stack.Name), au.Red(stackDriftStatus))
+	}
+
+	table := tablewriter.NewWriter(os.Stdout)
+	table.SetAutoWrapText(false)
+	table.SetHeader([]string{"Resource", "Type", "Drift"})
+	table.SetHeaderColor(tablewriter.Colors{tablewriter.FgWhiteColor}, tablewriter.Colors{tablewriter.FgWhiteColor}, tablewriter.Colors{tablewriter.FgWhiteColor})
+
+	var modified []*cloudformation.StackResourceDrift
+	for _, drift := range drifts {
+		driftStatus := aws.StringValue(drift.StackResourceDriftStatus)
+		switch driftStatus {
+		case cloudformation.StackResourceDriftStatusInSync:
+			driftStatus = au.BrightGreen(driftStatus).String()
+		case cloudformation.StackResourceDriftStatusModified:
+			modified = append(modified, drift)
+			driftStatus = au.Red(driftStatus).String()
+		case cloudformation.StackResourceDriftStatusDeleted:
+			driftStatus = au.Red(driftStatus).String()
+		default:
+			driftStatus = au.Gray(11, driftStatus).String()
+		}
+		table.Append([]string{aws.StringValue(drift.LogicalResourceId), aws.StringValue(drift.ResourceType), driftStatus})
+	}
+	if table.NumLines() > 0 {
+		table.Render()
+	}
+
+	for _, drift := range modified {
+		log.Infof("%s %s\n", au.White("Expected ⤏ actual properties of"), au.Magenta(aws.StringValue(drift.LogicalResourceId)))
+		if dyffErr := writeDyff(log, []byte(aws.StringValue(drift.ExpectedProperties)), []byte(aws.StringValue(drift.ActualProperties))); dyffErr != nil {
+			log.Error(dyffErr)
+		}
+	}
+}
+
+// printStackSetDrift waits for the stack set drift detection operation to finish, then renders the drift of each instance
+func printStackSetDrift(ctx context.Context, detection driftDetection) {
+	stack, cfn := detection.stack, detection.cfn
+
+	status, waitErr := waitForStackSetOperation(ctx, log, cfn, stack.Name, detection.operationID)
+	if ctx.Err() != nil {
+		log.Errorf("%s %s\n", au.Magenta(stack.Name), au.Red("drift detection did not finish in time"))
+		return
+	}
+	if waitErr != nil {
+		log.Errorf("%s %s\n", au.Magenta(stack.Name), waitErr)
+		return
+	}
+	// detection can fail for some instances and still report on the rest
+	if status != cloudformation.StackSetOperationStatusSucceeded {
+		log.Errorf("%s %s\n", au.Magenta(stack.Name), operationStatus(status))
+	}
+
+	existing, describeErr := describeStackSet(ctx, cfn, stack.Name)
+	if describeErr != nil {
+		log.Error(describeErr)
+		return
+	}
+	if existing == nil || existing.StackSetDriftDetectionDetails == nil {
+		log.Errorf("%s %s\n", au.Magenta(stack.Name), "stack set has no drift detection details")
+		return
+	}
+	stackSetDriftStatus := aws.StringValue(existing.StackSetDriftDetectionDetails.DriftStatus)
+	if stackSetDriftStatus == cloudformation.StackSetDriftStatusInSync {
+		log.Infof("%s %s\n", au.Magenta(stack.Name), au.BrightGreen(stackSetDriftStatus))
+	} else {
+		log.Errorf("%s %s\n", au.Magenta(stack.Name), au.Red(stackSetDriftStatus))
+	}
+
+	summaries, listErr := listStackInstances(ctx, cfn, stack.Name)
+	if listErr != nil {
+		log.Error(listErr)
+		return
+	}
+	renderStackInstances(log.Stdout(), stack.StackSet, summaries, nil)
+}
+
+func init() {
+	rootCmd.AddCommand(driftCmd)
+}
@@ -1,11 +1,14 @@
 package cmd
 
 import (
+	"context"
 	"os"
 	"strings"
+	"time"
 
 	"cuelang.org/go/cue"
 	"cuelang.org/go/cue/build"
+	"github.com/TangoGroup/stx/logger"
 	"github.com/TangoGroup/stx/stx"
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/service/cloudformation"
@@ -19,11 +22,15 @@ var eventsCmd = &cobra.Command{
 	Short: "Shows the latest events from the evaluated stacks.",
 	Long: `Events operates on every stack found in the evaluated cue files.
 	
-For each stack, events will query CloudFormation and return a list of events.`,
+For each stack, events will query CloudFormation and return a list of events.
+For stacks that declare a StackSet, the latest stack set operations are listed
+instead.`,
 	Run: func(cmd *cobra.Command, args []string) {
 		// TODO add debug messages
 		defer log.Flush()
-		stx.EnsureVaultSession(config)
+		if sessionErr := stx.EnsureSession(config); sessionErr != nil {
+			log.Fatal(sessionErr)
+		}
 
 		buildInstances := stx.GetBuildInstances(args, config.PackageName)
 
@@ -43,8 +50,19 @@ For each stack, events will query CloudFormation and return a list of events.`,
 				}
 
 				// get a session and cloudformation service client
-				session := stx.GetSession(stack.Profile)
+				session, sessionErr := stx.GetStackSession(stack)
+				if sessionErr != nil {
+					log.Error(sessionErr)
+					continue
+				}
 				cfn := cloudformation.New(session, aws.NewConfig().WithRegion(stack.Region))
+				numberStacksToDisplay, _ := cmd.Flags().GetInt("number")
+
+				if stack.StackSet != nil {
+					printStackSetOperations(cfn, stack, numberStacksToDisplay)
+					continue
+				}
+
 				describeStackEventsInput := cloudformation.DescribeStackEventsInput{StackName: aws.String(stack.Name)}
 				describeStackEventsOutput, describeStackEventsErr := cfn.DescribeStackEvents(&describeStackEventsInput)
 				if describeStackEventsErr != nil {
@@ -54,7 +72,6 @@ For each stack, events will query CloudFormation and return a list of events.`,
 				// TODO add --aws-output(?) to be used in conjunction with --debug
 				// log.Debugf("%+v\n", describeStackEventsOutput)
 
-				numberStacksToDisplay, _ := cmd.Flags().GetInt("number")
 				if numberStacksToDisplay < 0 {
 					numberStacksToDisplay = len(describeStackEventsOutput.StackEvents)
 				}
@@ -68,26 +85,7 @@ For each stack, events will query CloudFormation and return a list of events.`,
 					if i >= numberStacksToDisplay {
 						break
 					}
-					reason := "-"
-					if event.ResourceStatusReason != nil {
-						reason = aws.StringValue(event.ResourceStatusReason)
-					}
-					status := aws.StringValue(event.ResourceStatus)
-					if strings.Contains(aws.StringValue(event.ResourceStatus), "COMPLETE") {
-						status = au.BrightGreen(aws.StringValue(event.ResourceStatus)).String()
-					}
-					// if strings.Contains(aws.StringValue(event.ResourceStatus), "PROGRESS") {
-					// 	status = au.Yellow(aws.StringValue(event.ResourceStatus)).String()
-					// }
-					if strings.Contains(aws.StringValue(event.ResourceStatus), "FAIL") || strings.Contains(aws.StringValue(event.ResourceStatus), "ROLLBACK") {
-						status = au.Red(aws.StringValue(event.ResourceStatus)).String()
-						reason = au.Red(reason).String()
-					}
-					resource := *event.LogicalResourceId
-					if strings.Contains(*event.LogicalResourceId, stack.Name) {
-						resource = au.Magenta(resource).String()
-					}
-
+					resource, status, reason := eventColumns(event, stack.Name)
 					table.Append([]string{resource, status, event.Timestamp.Local().String(), reason})
 				}
 
@@ -98,6 +96,136 @@ For each stack, events will query CloudFormation and return a list of events.`,
 	},
 }
 
+// eventColumns returns the resource, status, and reason of an event, coloured by status
+func eventColumns(event *cloudformation.StackEvent, stackName string) (string, string, string) {
+	reason := "-"
+	if event.ResourceStatusReason != nil {
+		reason = aws.StringValue(event.ResourceStatusReason)
+	}
+	status := aws.StringValue(event.ResourceStatus)
+	if strings.Contains(aws.StringValue(event.ResourceStatus), "COMPLETE") {
+		status = au.BrightGreen(aws.StringValue(event.ResourceStatus)).String()
+	}
+	if strings.Contains(aws.StringValue(event.ResourceStatus), "FAIL") || strings.Contains(aws.StringValue(event.ResourceStatus), "ROLLBACK") {
+		status = au.Red(aws.StringValue(event.ResourceStatus)).String()
+		reason = au.Red(reason).String()
+	}
+	resource := aws.StringValue(event.LogicalResourceId)
+	if strings.Contains(resource, stackName) {
+		resource = au.Magenta(resource).String()
+	}
+	return resource, status, reason
+}
+
+// eventStream prints the events of a stack as they arrive, starting after the events that existed when it was created
+type eventStream struct {
+	stackLog    *logger.Logger
+	cfn         *cloudformation.CloudFormation
+	stackName   string
+	since       time.Time
+	lastEventID string
+	failures    []*cloudformation.StackEvent
+}
+
+// newEventStream remembers the latest event of the stack so that only newer events are printed
+func newEventStream(stackLog *logger.Logger, cfn *cloudformation.CloudFormation, stackName string) *eventStream {
+	stream := &eventStream{stackLog: stackLog, cfn: cfn, stackName: stackName, since: time.Now()}
+	describeStackEventsOutput, describeStackEventsErr := cfn.DescribeStackEvents(&cloudformation.DescribeStackEventsInput{StackName: aws.String(stackName)})
+	if describeStackEventsErr == nil && len(describeStackEventsOutput.StackEvents) > 0 {
+		stream.lastEventID = aws.StringValue(describeStackEventsOutput.StackEvents[0].EventId)
+	}
+	return stream
+}
+
+// poll prints events that arrived since the last poll, oldest first
+func (s *eventStream) poll(ctx context.Context) {
+	var events []*cloudformation.StackEvent
+	input := cloudformation.DescribeStackEventsInput{StackName: aws.String(s.stackName)}
+	// events are returned newest first, so stop paging at the last event already printed
+	pageErr := s.cfn.DescribeStackEventsPagesWithContext(ctx, &input, func(page *cloudformation.DescribeStackEventsOutput, lastPage bool) bool {
+		for _, event := range page.StackEvents {
+			// the time check guards against a lastEventID that has aged out of the event history
+			if aws.StringValue(event.EventId) == s.lastEventID || aws.TimeValue(event.Timestamp).Before(s.since.Add(-time.Minute)) {
+				return false
+			}
+			events = append(events, event)
+		}
+		return true
+	})
+	if pageErr != nil {
+		s.stackLog.Debug("Unable to describe stack events:", pageErr)
+		return
+	}
+	if len(events) == 0 {
+		return
+	}
+	s.lastEventID = aws.StringValue(events[0].EventId)
+
+	for i := len(events) - 1; i >= 0; i-- {
+		event := events[i]
+		if strings.HasSuffix(aws.StringValue(event.ResourceStatus), "_FAILED") {
+			s.failures = append(s.failures, event)
+		}
+		resource, status, reason := eventColumns(event, s.stackName)
+		s.stackLog.Infof("  %s %s %s %s\n", au.Gray(11, event.Timestamp.Local().Format("15:04:05")), resource, status, au.Gray(11, reason))
+	}
+}
+
+// follow polls for events every interval until ctx is done, then polls a final time
+func (s *eventStream) follow(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			s.poll(context.Background())
+			return
+		case <-ticker.C:
+			s.poll(ctx)
+		}
+	}
+}
+
+// causes returns the failed events that explain a failure, oldest first.
+// Cancellations caused by an earlier failure are left out when there are other failures.
+func (s *eventStream) causes() []*cloudformation.StackEvent {
+	var causes []*cloudformation.StackEvent
+	for _, event := range s.failures {
+		if !strings.Contains(aws.StringValue(event.ResourceStatusReason), "cancelled") {
+			causes = append(causes, event)
+		}
+	}
+	if len(causes) == 0 {
+		return s.failures
+	}
+	return causes
+}
+
+// rootCause describes the first failure, or returns an empty string if no event failed
+func (s *eventStream) rootCause() string {
+	causes := s.causes()
+	if len(causes) == 0 {
+		return ""
+	}
+	return aws.StringValue(causes[0].LogicalResourceId) + " " + aws.StringValue(causes[0].ResourceStatusReason)
+}
+
+// printFailures summarises the first failed events, which usually explain why the stack rolled back
+func (s *eventStream) printFailures(max int) {
+	causes := s.causes()
+	if len(causes) == 0 {
+		return
+	}
+	if len(causes) > max {
+		causes = causes[:max]
+	}
+
+	s.stackLog.Infof("%s %s\n", au.Red("Failures in"), au.Magenta(s.stackName))
+	for _, event := range causes {
+		s.stackLog.Infof("  %s %s %s\n", au.White(aws.StringValue(event.LogicalResourceId)), au.Red(aws.StringValue(event.ResourceStatus)), aws.StringValue(event.ResourceStatusReason))
+	}
+}
+
 func init() {
 	rootCmd.AddCommand(eventsCmd)
 
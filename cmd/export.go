@@ -8,6 +8,7 @@ import (
 	"cuelang.org/go/cue"
 	"cuelang.org/go/cue/build"
 	"cuelang.org/go/pkg/encoding/yaml"
+	"github.com/TangoGroup/stx/logger"
 	"github.com/TangoGroup/stx/stx"
 	"github.com/spf13/cobra"
 )
@@ -34,6 +35,10 @@ infrastructure/
 | ...
 |-yml/
 | |-cloudformation/
+
+Local paths referenced by Lambda Code, Serverless CodeUri, nested stack
+TemplateURL, and RestApi BodyS3Location are uploaded and rewritten first; see
+stx package --help. Use --no-package to export templates as they are.
 `,
 	Run: func(cmd *cobra.Command, args []string) {
 		defer log.Flush()
@@ -54,7 +59,11 @@ infrastructure/
 					log.Error(decodeErr)
 					continue
 				}
-				_, saveErr := saveStackAsYml(stack, buildInstance, stackValue)
+				var uploader *stackUploader
+				if !flags.ExportNoPackage {
+					uploader = &stackUploader{stack: stack}
+				}
+				_, _, saveErr := exportStack(log, stack, buildInstance, stackValue, uploader)
 				if saveErr != nil {
 					log.Error(saveErr)
 				}
@@ -63,24 +72,52 @@ infrastructure/
 	},
 }
 
-func saveStackAsYml(stack stx.Stack, buildInstance *build.Instance, stackValue cue.Value) (string, error) {
+// saveStackAsYml exports and packages the stack's template, returning the file name.
+// With dryRun, local artifacts are rewritten to the S3 locations they would be packaged to, but nothing is uploaded.
+func saveStackAsYml(stackLog *logger.Logger, stack stx.Stack, buildInstance *build.Instance, stackValue cue.Value, dryRun bool) (string, error) {
+	fileName, _, err := exportStack(stackLog, stack, buildInstance, stackValue, &stackUploader{stack: stack, dryRun: dryRun})
+	return fileName, err
+}
+
+// exportStack writes the stack's template to the YmlPath, after packaging its local artifacts with the uploader unless it is nil.
+// Progress goes to stackLog, so stacks deployed in parallel keep their prefixes.
+func exportStack(stackLog *logger.Logger, stack stx.Stack, buildInstance *build.Instance, stackValue cue.Value, uploader *stackUploader) (string, []stx.Artifact, error) {
 	dir := filepath.Clean(config.CueRoot + "/" + config.Cmd.Export.YmlPath + "/" + stack.Profile)
 	os.MkdirAll(dir, 0755)
 
 	fileName := dir + "/" + stack.Name + ".cfn.yml"
-	log.Infof("%s %s %s %s\n", au.White("Exported"), au.Magenta(stack.Name), au.White("⤏"), fileName)
 	template := stackValue.Lookup("Template")
 	yml, ymlErr := yaml.Marshal(template)
 	if ymlErr != nil {
-		return "", ymlErr
+		return "", nil, ymlErr
 	}
-	writeErr := ioutil.WriteFile(fileName, []byte(yml), 0644)
+
+	templateBytes := []byte(yml)
+	var artifacts []stx.Artifact
+	if uploader != nil {
+		packaged, packagedArtifacts, packageErr := stx.PackageTemplate(templateBytes, buildInstance.Dir, uploader)
+		if packageErr != nil {
+			return "", nil, packageErr
+		}
+		templateBytes, artifacts = packaged, packagedArtifacts
+		verb := "Packaged"
+		if uploader.dryRun {
+			verb = "Would package"
+		}
+		for _, artifact := range artifacts {
+			stackLog.Infof("%s %s.%s %s %s\n", au.White(verb), au.Magenta(artifact.Resource), artifact.Property, au.White("⤏"), "s3://"+artifact.Location.Bucket+"/"+artifact.Location.Key)
+		}
+	}
+
+	stackLog.Infof("%s %s %s %s\n", au.White("Exported"), au.Magenta(stack.Name), au.White("⤏"), fileName)
+	writeErr := ioutil.WriteFile(fileName, templateBytes, 0644)
 	if writeErr != nil {
-		return "", writeErr
+		return "", nil, writeErr
 	}
-	return fileName, nil
+	return fileName, artifacts, nil
 }
 
 func init() {
 	rootCmd.AddCommand(exportCmd)
+	exportCmd.Flags().BoolVar(&flags.ExportNoPackage, "no-package", false, "Export templates without packaging local artifacts.")
 }
@@ -0,0 +1,204 @@
+package cmd
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"cuelang.org/go/cue"
+	"cuelang.org/go/cue/build"
+	"github.com/TangoGroup/stx/graph"
+	"github.com/TangoGroup/stx/stx"
+	"github.com/spf13/cobra"
+)
+
+func init() {
+	rootCmd.AddCommand(graphCmd)
+	graphCmd.Flags().StringVarP(&flags.GraphFormat, "format", "f", "tree", "Output format: tree, dot, or mermaid.")
+	graphCmd.Flags().StringVar(&flags.GraphDependenciesOf, "dependencies-of", "", "Only show the stacks this stack depends on, directly or transitively.")
+	graphCmd.Flags().StringVar(&flags.GraphDependentsOf, "dependents-of", "", "Only show the stacks that depend on this stack, directly or transitively.")
+}
+
+// graphCmd represents the graph command
+var graphCmd = &cobra.Command{
+	Use:   "graph",
+	Short: "Prints the dependency graph of the evaluated stacks.",
+	Long: `Graph operates on every stack found in the evaluated cue files.
+
+Graph builds the same dependency graph from Stacks:DependsOn that
+deploy --dependencies uses, and prints it as an indented tree (default),
+Graphviz DOT, or a Mermaid flowchart. Edges point from a stack to the stack it
+depends on. Global filters apply, so dependencies on stacks that were filtered
+out are shown as missing.
+
+Use --dependencies-of to show only what a stack depends on, or --dependents-of
+to show only what depends on a stack. Both include transitive relationships.
+
+Graph does not require AWS credentials.
+
+Examples:
+  stx graph --format dot | dot -Tsvg > stacks.svg
+  stx graph --dependents-of dev-vpc-usw2
+`,
+	Run: func(cmd *cobra.Command, args []string) {
+
+		defer log.Flush()
+
+		if flags.GraphDependenciesOf != "" && flags.GraphDependentsOf != "" {
+			log.Fatal("Cannot use --dependencies-of and --dependents-of together.")
+		}
+
+		stacksGraph := graph.NewGraph()
+		buildInstances := stx.GetBuildInstances(args, config.PackageName)
+
+		stx.Process(buildInstances, flags, log, func(buildInstance *build.Instance, cueInstance *cue.Instance) {
+			stacksIterator, stacksIteratorErr := stx.NewStacksIterator(cueInstance, flags, log)
+			if stacksIteratorErr != nil {
+				log.Fatal(stacksIteratorErr)
+			}
+
+			for stacksIterator.Next() {
+				stackValue := stacksIterator.Value()
+				var stack stx.Stack
+				decodeErr := stackValue.Decode(&stack)
+				if decodeErr != nil {
+					log.Error(decodeErr)
+					continue
+				}
+				stacksGraph.AddNode(stack.Name, stack.DependsOn...)
+			}
+		})
+
+		// roots are where the tree starts, next decides which way the tree grows from them
+		roots := graphRoots(stacksGraph)
+		next := stacksGraph.Dependencies
+
+		if flags.GraphDependenciesOf != "" {
+			if !stacksGraph.Has(flags.GraphDependenciesOf) {
+				log.Fatalf("Stack %s not found among the evaluated stacks.\n", flags.GraphDependenciesOf)
+			}
+			roots = []string{flags.GraphDependenciesOf}
+			stacksGraph = stacksGraph.Subgraph(append(stacksGraph.Ancestors(flags.GraphDependenciesOf), flags.GraphDependenciesOf)...)
+		}
+
+		if flags.GraphDependentsOf != "" {
+			if !stacksGraph.Has(flags.GraphDependentsOf) {
+				log.Fatalf("Stack %s not found among the evaluated stacks.\n", flags.GraphDependentsOf)
+			}
+			roots = []string{flags.GraphDependentsOf}
+			stacksGraph = stacksGraph.Subgraph(append(stacksGraph.Descendants(flags.GraphDependentsOf), flags.GraphDependentsOf)...)
+			next = stacksGraph.Dependents
+		}
+
+		switch flags.GraphFormat {
+		case "tree":
+			for _, root := range roots {
+				printGraphTree(stacksGraph, root, next, "", "", map[string]bool{})
+			}
+		case "dot":
+			printGraphDot(stacksGraph)
+		case "mermaid":
+			printGraphMermaid(stacksGraph)
+		default:
+			log.Fatalf("Unknown --format %s. Use tree, dot, or mermaid.\n", flags.GraphFormat)
+		}
+	},
+}
+
+// graphRoots returns the stacks that no other stack depends on. If every stack is part of a cycle, all stacks are returned.
+func graphRoots(stacksGraph *graph.Graph) []string {
+	var roots []string
+	for _, name := range stacksGraph.Names() {
+		if len(stacksGraph.Dependents(name)) == 0 {
+			roots = append(roots, name)
+		}
+	}
+	if len(roots) == 0 {
+		roots = stacksGraph.Names()
+	}
+	sort.Strings(roots)
+	return roots
+}
+
+// printGraphTree prints name and then recursively every name returned by next, indented beneath it
+func printGraphTree(stacksGraph *graph.Graph, name string, next func(string) []string, prefix, branch string, path map[string]bool) {
+	label := au.Magenta(name).String()
+	if !stacksGraph.Has(name) {
+		label = au.Red(name + " (missing)").String()
+	} else if path[name] {
+		label = au.Red(name + " (circular)").String()
+	}
+	log.Infof("%s%s%s\n", prefix, au.Gray(11, branch), label)
+
+	if path[name] {
+		return
+	}
+	path[name] = true
+	defer delete(path, name)
+
+	if branch == "├── " {
+		prefix += au.Gray(11, "│   ").String()
+	} else if branch == "└── " {
+		prefix += "    "
+	}
+
+	children := next(name)
+	for i, child := range children {
+		childBranch := "├── "
+		if i == len(children)-1 {
+			childBranch = "└── "
+		}
+		printGraphTree(stacksGraph, child, next, prefix, childBranch, path)
+	}
+}
+
+// printGraphDot prints the graph in Graphviz DOT format
+func printGraphDot(stacksGraph *graph.Graph) {
+	log.Info("digraph stacks {")
+	log.Info("  rankdir=LR;")
+	log.Info("  node [shape=box];")
+	for _, name := range stacksGraph.Names() {
+		log.Infof("  %q;\n", name)
+	}
+	for _, name := range stacksGraph.Names() {
+		for _, dep := range stacksGraph.Dependencies(name) {
+			if stacksGraph.Has(dep) {
+				log.Infof("  %q -> %q;\n", name, dep)
+			} else {
+				log.Infof("  %q -> %q [style=dashed, color=red];\n", name, dep)
+			}
+		}
+	}
+	log.Info("}")
+}
+
+// printGraphMermaid prints the graph as a Mermaid flowchart
+func printGraphMermaid(stacksGraph *graph.Graph) {
+	// stack names may contain characters mermaid does not allow in ids, so number them
+	ids := make(map[string]string)
+	id := func(name string) string {
+		if _, ok := ids[name]; !ok {
+			ids[name] = fmt.Sprintf("s%d", len(ids))
+		}
+		return ids[name]
+	}
+
+	log.Info("graph LR")
+	for _, name := range stacksGraph.Names() {
+		log.Infof("  %s[%q]\n", id(name), name)
+	}
+	var missing []string
+	for _, name := range stacksGraph.Names() {
+		for _, dep := range stacksGraph.Dependencies(name) {
+			if !stacksGraph.Has(dep) && ids[dep] == "" {
+				missing = append(missing, id(dep))
+				log.Infof("  %s[%q]\n", id(dep), dep+" (missing)")
+			}
+			log.Infof("  %s --> %s\n", id(name), id(dep))
+		}
+	}
+	if len(missing) > 0 {
+		log.Info("  classDef missing stroke:#f00,stroke-dasharray:5")
+		log.Infof("  class %s missing\n", strings.Join(missing, ","))
+	}
+}
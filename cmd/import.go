@@ -37,10 +37,16 @@ Stacks pattern, and save it as a formatted Cue file.
 
 		flags.StackNameRegexPattern = "^" + flags.ImportStack + "$"
 
-		stx.EnsureVaultSession(config)
+		if sessionErr := stx.EnsureSession(config); sessionErr != nil {
+			log.Fatal(sessionErr)
+		}
 
 		// get a session and cloudformation service client
-		session := stx.GetSession(flags.Profile)
+		session, sessionErr := stx.GetSession(flags.Profile)
+		if sessionErr != nil {
+			log.Fatal(sessionErr)
+			return
+		}
 		cfn := cloudformation.New(session, aws.NewConfig().WithRegion(flags.ImportRegion))
 		log.Infof("%s %s...", au.White("Importing"), au.Magenta(flags.ImportStack))
 
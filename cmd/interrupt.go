@@ -0,0 +1,136 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/cloudformation"
+)
+
+// exitInterrupted is the exit code after SIGINT or SIGTERM, distinct from the error counts logger.Flush exits with
+const exitInterrupted = 130
+
+// inFlight tracks change sets that were created but not executed, and change sets that are executing,
+// so that an interrupt can clean up after them
+var inFlight = struct {
+	sync.Mutex
+	created   map[*stackDeployment]bool
+	executing map[*stackDeployment]bool
+}{created: make(map[*stackDeployment]bool), executing: make(map[*stackDeployment]bool)}
+
+// trackCreated records a change set that must be deleted if deploy is interrupted before executing it
+func trackCreated(d *stackDeployment) {
+	inFlight.Lock()
+	defer inFlight.Unlock()
+	inFlight.created[d] = true
+}
+
+// trackExecuting records a change set that has been executed
+func trackExecuting(d *stackDeployment) {
+	inFlight.Lock()
+	defer inFlight.Unlock()
+	delete(inFlight.created, d)
+	inFlight.executing[d] = true
+}
+
+// untrack forgets a change set once it has been deleted or its stack has finished
+func untrack(d *stackDeployment) {
+	inFlight.Lock()
+	defer inFlight.Unlock()
+	delete(inFlight.created, d)
+	delete(inFlight.executing, d)
+}
+
+// handleInterrupts returns a context that is cancelled on SIGINT or SIGTERM. After cancelling it, in-flight change sets
+// are cleaned up and the returned channel is closed; waitForInterrupt then exits with exitInterrupted. A second signal exits immediately.
+func handleInterrupts() (context.Context, <-chan struct{}, func()) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cleanedUp := make(chan struct{})
+	signals := make(chan os.Signal, 2)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+
+	go func() {
+		sig := <-signals
+		// deployments untrack themselves as soon as their waiters see the cancelled context, so take the snapshot first
+		created, executing := snapshotInFlight()
+		cancel()
+		// a NoEcho prompt may have turned echo off, and the cleanup may prompt too
+		restoreTerminal()
+		log.Infof("\n%s %s\n", au.Red("Interrupted by "+sig.String()+"."), au.Gray(11, "Cleaning up; interrupt again to exit immediately."))
+		go func() {
+			<-signals
+			restoreTerminal()
+			os.Exit(exitInterrupted)
+		}()
+		cleanUpInFlight(created, executing)
+		restoreTerminal()
+		close(cleanedUp)
+	}()
+
+	return ctx, cleanedUp, func() {
+		signal.Stop(signals)
+		cancel()
+	}
+}
+
+// waitForInterrupt returns at once unless ctx has been cancelled by an interrupt. Then it waits for handleInterrupts
+// to finish cleaning up, and exits with exitInterrupted.
+func waitForInterrupt(ctx context.Context, cleanedUp <-chan struct{}) {
+	if ctx.Err() == nil {
+		return
+	}
+	<-cleanedUp
+	os.Exit(exitInterrupted)
+}
+
+// snapshotInFlight returns the change sets that are created but not executed, and those that are executing
+func snapshotInFlight() (created, executing []*stackDeployment) {
+	inFlight.Lock()
+	defer inFlight.Unlock()
+	for d := range inFlight.created {
+		created = append(created, d)
+	}
+	for d := range inFlight.executing {
+		executing = append(executing, d)
+	}
+	return created, executing
+}
+
+// cleanUpInFlight deletes change sets that were never executed, and offers to cancel stack updates that are in progress
+func cleanUpInFlight(created, executing []*stackDeployment) {
+	for _, d := range created {
+		d.deleteChangeSet()
+	}
+
+	for _, d := range executing {
+		stackStatus, statusErr := describeStackStatus(d.cfn, d.stack.Name)
+		if statusErr != nil {
+			log.Error(statusErr)
+			continue
+		}
+		if stackStatus != "UPDATE_IN_PROGRESS" {
+			log.Infof("%s %s %s\n", au.Magenta(d.stack.Name), au.Yellow(stackStatus), au.Gray(11, "continues in CloudFormation."))
+			continue
+		}
+		if flags.DeployYes {
+			log.Infof("%s %s %s\n", au.Magenta(d.stack.Name), au.Yellow(stackStatus), au.Gray(11, "continues in CloudFormation; run aws cloudformation cancel-update-stack to roll it back."))
+			continue
+		}
+
+		// prompts that were waiting for input gave up when the context was cancelled, so this one can take promptMu
+		if !promptYes(context.Background(), fmt.Sprintf("%s %s:%s:%s %s", au.Index(255-88, "Cancel update and roll back"), au.Magenta(d.stack.Name), au.Green(d.stack.Profile), au.Cyan(d.stack.Region), au.Index(255-88, "?"))) {
+			continue
+		}
+		_, cancelErr := d.cfn.CancelUpdateStack(&cloudformation.CancelUpdateStackInput{StackName: aws.String(d.stack.Name)})
+		if cancelErr != nil {
+			log.Error(cancelErr)
+			continue
+		}
+		log.Infof("%s %s\n", au.White("Cancelled update of"), au.Magenta(d.stack.Name))
+	}
+}
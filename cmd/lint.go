@@ -0,0 +1,298 @@
+package cmd
+
+import (
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"cuelang.org/go/cue"
+	"cuelang.org/go/cue/build"
+	"cuelang.org/go/cue/token"
+	"github.com/TangoGroup/stx/stx"
+	"github.com/olekukonko/tablewriter"
+	"github.com/spf13/cobra"
+)
+
+func init() {
+	rootCmd.AddCommand(lintCmd)
+	lintCmd.Flags().StringVarP(&flags.LintFormat, "format", "f", "text", "Output format: text, json, or sarif.")
+}
+
+// lintCmd represents the lint command
+var lintCmd = &cobra.Command{
+	Use:   "lint",
+	Short: "Checks stack templates against lint rules without calling AWS.",
+	Long: `Lint operates on every stack found in the evaluated cue files.
+
+Each stack's Template is checked against a set of rules, offline, so lint can
+run in CI next to validate. The built-in rules are:
+
+  S3BucketPublicAccessBlock  S3 buckets set BlockPublicAcls, BlockPublicPolicy,
+                             IgnorePublicAcls, and RestrictPublicBuckets
+  LogGroupRetention          log groups set RetentionInDays
+  StackTags                  the stack declares Tags and sets TagsEnabled, so
+                             CloudFormation tags every resource
+  StatefulDeletionPolicy     stateful resources, such as buckets, tables, and
+                             databases, have DeletionPolicy Retain
+
+More rules can be written in cue in config.stx.cue. A rule's hidden _Constraint
+is unified with each resource of the listed Types, or with every resource if
+Types is empty. A resource that conflicts with the constraint, leaves a field
+of it incomplete, or lacks a field it sets, violates the rule:
+
+Cmd: Lint: Rules: QueueEncryption: {
+	Description: "Queues are encrypted"
+	Severity:    "warning"
+	Types: ["AWS::SQS::Queue"]
+	_Constraint: Properties: KmsMasterKeyId: string
+}
+
+Severities are error (default), warning, and note. Cmd:Lint:Severity overrides
+the severity of any rule by ID, and "off" disables it:
+
+Cmd: Lint: Severity: LogGroupRetention: "warning"
+
+To suppress rules for one resource, list their IDs in its Metadata. Listing
+them in the Template's Metadata suppresses them for the whole stack:
+
+Metadata: Stx: Lint: Suppress: ["StatefulDeletionPolicy"]
+
+Results are printed as a table for each stack (text, the default), or for all
+stacks at the end as json or SARIF for code scanning tools. Each stack with
+results of severity error is counted as an error, so the exit code is non-zero.
+`,
+	Run: func(cmd *cobra.Command, args []string) {
+		defer log.Flush()
+
+		switch flags.LintFormat {
+		case "text", "json", "sarif":
+		default:
+			log.Fatalf("Unknown --format %s. Use text, json, or sarif.\n", flags.LintFormat)
+		}
+
+		cueRules, cueRulesErr := stx.CueLintRules(config)
+		if cueRulesErr != nil {
+			log.Fatal(cueRulesErr)
+		}
+		rules := append(stx.LintRules(), cueRules...)
+
+		var allResults []stx.LintResult
+		buildInstances := stx.GetBuildInstances(args, config.PackageName)
+		stx.Process(buildInstances, flags, log, func(buildInstance *build.Instance, cueInstance *cue.Instance) {
+			stacksIterator, stacksIteratorErr := stx.NewStacksIterator(cueInstance, flags, log)
+			if stacksIteratorErr != nil {
+				log.Fatal(stacksIteratorErr)
+			}
+
+			for stacksIterator.Next() {
+				stackValue := stacksIterator.Value()
+				var stack stx.Stack
+				decodeErr := stackValue.Decode(&stack)
+				if decodeErr != nil {
+					log.Error(decodeErr)
+					continue
+				}
+
+				results, lintErr := stx.Lint(stack, stackValue, rules, config.Cmd.Lint.Severity)
+				if lintErr != nil {
+					log.Errorf("%s %s\n", au.Magenta(stack.Name), lintErr)
+					continue
+				}
+				allResults = append(allResults, results...)
+
+				if flags.LintFormat == "text" {
+					if len(results) < 1 {
+						log.Infof("%s %s\n", au.Magenta(stack.Name), au.BrightGreen("✓"))
+					} else {
+						renderLintResults(log.Stdout(), results)
+					}
+				}
+				errorCount := 0
+				for _, result := range results {
+					if result.Severity == stx.SeverityError {
+						errorCount++
+					}
+				}
+				if errorCount > 0 {
+					log.Errorf("%s has %d lint errors\n", au.Magenta(stack.Name), errorCount)
+				}
+			}
+		})
+
+		switch flags.LintFormat {
+		case "json":
+			writeLintJSON(log.Stdout(), allResults)
+		case "sarif":
+			writeLintSarif(log.Stdout(), rules, allResults)
+		}
+	},
+}
+
+// renderLintResults writes a table of lint results for one stack
+func renderLintResults(w io.Writer, results []stx.LintResult) {
+	table := tablewriter.NewWriter(w)
+	table.SetAutoWrapText(false)
+	table.SetHeader([]string{"Severity", "Rule", "Resource", "Position", "Message"})
+	table.SetHeaderColor(tablewriter.Colors{tablewriter.FgWhiteColor}, tablewriter.Colors{tablewriter.FgWhiteColor}, tablewriter.Colors{tablewriter.FgWhiteColor}, tablewriter.Colors{tablewriter.FgWhiteColor}, tablewriter.Colors{tablewriter.FgWhiteColor})
+	for _, result := range results {
+		severity := au.Red(result.Severity)
+		switch result.Severity {
+		case stx.SeverityWarning:
+			severity = au.Yellow(result.Severity)
+		case stx.SeverityNote:
+			severity = au.BrightBlue(result.Severity)
+		}
+		resource := result.Resource
+		if resource == "" {
+			resource = "-"
+		}
+		position := "-"
+		if result.Pos.IsValid() {
+			position = cuePosition(result.Pos)
+		}
+		table.Append([]string{severity.String(), result.RuleID, resource, position, result.Message})
+	}
+	table.Render()
+}
+
+// cuePosition formats a position relative to the cue root
+func cuePosition(pos token.Pos) string {
+	return strings.TrimPrefix(pos.String(), config.CueRoot+string(os.PathSeparator))
+}
+
+// lintResultJSON is a lint result as written by lint --format json
+type lintResultJSON struct {
+	RuleID, Severity, Stack, Resource, Message string
+	File                                       string
+	Line, Column                               int
+}
+
+// writeLintJSON writes the results of every stack as a json list
+func writeLintJSON(w io.Writer, results []stx.LintResult) {
+	list := make([]lintResultJSON, 0, len(results))
+	for _, result := range results {
+		item := lintResultJSON{RuleID: result.RuleID, Severity: result.Severity, Stack: result.Stack, Resource: result.Resource, Message: result.Message}
+		if result.Pos.IsValid() {
+			position := result.Pos.Position()
+			item.File, item.Line, item.Column = relativeFile(position.Filename), position.Line, position.Column
+		}
+		list = append(list, item)
+	}
+	listBytes, _ := json.MarshalIndent(list, "", "  ")
+	w.Write(append(listBytes, '\n'))
+}
+
+// relativeFile returns a file name relative to the cue root, with forward slashes
+func relativeFile(fileName string) string {
+	if rel, relErr := filepath.Rel(config.CueRoot, fileName); relErr == nil {
+		fileName = rel
+	}
+	return filepath.ToSlash(fileName)
+}
+
+// sarifLog is the subset of SARIF 2.1.0 that lint writes
+type sarifLog struct {
+	Schema  string     `json:"$schema"`
+	Version string     `json:"version"`
+	Runs    []sarifRun `json:"runs"`
+}
+
+type sarifRun struct {
+	Tool struct {
+		Driver struct {
+			Name           string      `json:"name"`
+			InformationURI string      `json:"informationUri"`
+			Rules          []sarifRule `json:"rules"`
+		} `json:"driver"`
+	} `json:"tool"`
+	Results []sarifResult `json:"results"`
+}
+
+type sarifRule struct {
+	ID               string       `json:"id"`
+	ShortDescription sarifMessage `json:"shortDescription"`
+	DefaultConfig    struct {
+		Level string `json:"level"`
+	} `json:"defaultConfiguration"`
+}
+
+type sarifMessage struct {
+	Text string `json:"text"`
+}
+
+type sarifResult struct {
+	RuleID    string          `json:"ruleId"`
+	Level     string          `json:"level"`
+	Message   sarifMessage    `json:"message"`
+	Locations []sarifLocation `json:"locations,omitempty"`
+}
+
+type sarifLocation struct {
+	PhysicalLocation *sarifPhysicalLocation `json:"physicalLocation,omitempty"`
+	LogicalLocations []sarifLogicalLocation `json:"logicalLocations,omitempty"`
+}
+
+type sarifPhysicalLocation struct {
+	ArtifactLocation struct {
+		URI string `json:"uri"`
+	} `json:"artifactLocation"`
+	Region struct {
+		StartLine   int `json:"startLine"`
+		StartColumn int `json:"startColumn"`
+	} `json:"region"`
+}
+
+type sarifLogicalLocation struct {
+	FullyQualifiedName string `json:"fullyQualifiedName"`
+	Kind               string `json:"kind"`
+}
+
+// writeLintSarif writes the results of every stack as a SARIF log, with file positions relative to the cue root
+func writeLintSarif(w io.Writer, rules []stx.LintRule, results []stx.LintResult) {
+	run := sarifRun{Results: make([]sarifResult, 0, len(results))}
+	run.Tool.Driver.Name = "stx"
+	run.Tool.Driver.InformationURI = "https://github.com/TangoGroup/stx"
+	for _, rule := range rules {
+		sr := sarifRule{ID: rule.ID(), ShortDescription: sarifMessage{Text: rule.Description()}}
+		sr.DefaultConfig.Level = rule.Severity()
+		if override, ok := config.Cmd.Lint.Severity[rule.ID()]; ok {
+			sr.DefaultConfig.Level = override
+		}
+		// rules that are off are not run, so SARIF has no level for them
+		if sr.DefaultConfig.Level == stx.SeverityOff {
+			continue
+		}
+		run.Tool.Driver.Rules = append(run.Tool.Driver.Rules, sr)
+	}
+
+	for _, result := range results {
+		location := sarifLocation{}
+		if result.Pos.IsValid() {
+			position := result.Pos.Position()
+			location.PhysicalLocation = &sarifPhysicalLocation{}
+			location.PhysicalLocation.ArtifactLocation.URI = relativeFile(position.Filename)
+			location.PhysicalLocation.Region.StartLine = position.Line
+			location.PhysicalLocation.Region.StartColumn = position.Column
+		}
+		logicalName, kind := result.Stack, "module"
+		if result.Resource != "" {
+			logicalName, kind = result.Stack+"/"+result.Resource, "resource"
+		}
+		location.LogicalLocations = []sarifLogicalLocation{{FullyQualifiedName: logicalName, Kind: kind}}
+		run.Results = append(run.Results, sarifResult{
+			RuleID:    result.RuleID,
+			Level:     result.Severity,
+			Message:   sarifMessage{Text: result.Message},
+			Locations: []sarifLocation{location},
+		})
+	}
+
+	logBytes, _ := json.MarshalIndent(sarifLog{
+		Schema:  "https://json.schemastore.org/sarif-2.1.0.json",
+		Version: "2.1.0",
+		Runs:    []sarifRun{run},
+	}, "", "  ")
+	w.Write(append(logBytes, '\n'))
+}
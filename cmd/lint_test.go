@@ -0,0 +1,62 @@
+package cmd
+
+import (
+	"bytes"
+	"encoding/json"
+	"reflect"
+	"testing"
+
+	"github.com/TangoGroup/stx/stx"
+)
+
+// severityRule is a lint rule that only has an ID and a severity
+type severityRule struct{ id, severity string }
+
+func (r severityRule) ID() string          { return r.id }
+func (r severityRule) Description() string { return r.id + " description" }
+func (r severityRule) Severity() string    { return r.severity }
+func (r severityRule) Check(stx.LintTemplate) ([]stx.LintFinding, error) {
+	return nil, nil
+}
+
+func TestWriteLintSarifRules(t *testing.T) {
+	defer func(previous *stx.Config) { config = previous }(config)
+	config = &stx.Config{}
+	config.Cmd.Lint.Severity = map[string]string{"Overridden": stx.SeverityNote, "TurnedOff": stx.SeverityOff, "TurnedOn": stx.SeverityWarning}
+
+	rules := []stx.LintRule{
+		severityRule{"Default", stx.SeverityError},
+		severityRule{"Overridden", stx.SeverityError},
+		severityRule{"TurnedOff", stx.SeverityError},
+		severityRule{"Off", stx.SeverityOff},
+		severityRule{"TurnedOn", stx.SeverityOff},
+	}
+	results := []stx.LintResult{{RuleID: "Default", Severity: stx.SeverityError, Stack: "test-stack", Resource: "Bucket", Message: "message"}}
+
+	var buf bytes.Buffer
+	writeLintSarif(&buf, rules, results)
+	var sarif sarifLog
+	if unmarshalErr := json.Unmarshal(buf.Bytes(), &sarif); unmarshalErr != nil {
+		t.Fatal(unmarshalErr)
+	}
+
+	levels := make(map[string]string)
+	for _, rule := range sarif.Runs[0].Tool.Driver.Rules {
+		levels[rule.ID] = rule.DefaultConfig.Level
+	}
+	want := map[string]string{"Default": "error", "Overridden": "note", "TurnedOn": "warning"}
+	if !reflect.DeepEqual(levels, want) {
+		t.Errorf("got rule levels %v, want %v", levels, want)
+	}
+
+	if len(sarif.Runs[0].Results) != 1 {
+		t.Fatalf("got %d results, want 1", len(sarif.Runs[0].Results))
+	}
+	location := sarif.Runs[0].Results[0].Locations[0]
+	if location.PhysicalLocation != nil {
+		t.Error("a result without a position has a physical location")
+	}
+	if logical := location.LogicalLocations[0]; logical.FullyQualifiedName != "test-stack/Bucket" || logical.Kind != "resource" {
+		t.Errorf("got logical location %+v", logical)
+	}
+}
@@ -0,0 +1,126 @@
+package cmd
+
+import (
+	"errors"
+	"os"
+
+	"cuelang.org/go/cue"
+	"cuelang.org/go/cue/build"
+	"github.com/TangoGroup/stx/stx"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/olekukonko/tablewriter"
+	"github.com/spf13/cobra"
+)
+
+// packageCmd represents the package command
+var packageCmd = &cobra.Command{
+	Use:   "package",
+	Short: "Exports stacks and uploads the local artifacts their templates reference.",
+	Long: `Package operates on every stack found in the evaluated cue files.
+
+Package exports each stack like export does, then uploads local paths
+referenced by the following properties and rewrites them to point at the
+uploads, the way aws cloudformation package does:
+
+  AWS::Lambda::Function         Code            (zipped)
+  AWS::Serverless::Function     CodeUri         (zipped)
+  AWS::CloudFormation::Stack    TemplateURL     (packaged recursively)
+  AWS::ApiGateway::RestApi      BodyS3Location
+
+Relative paths are resolved from the directory of the cue files that define
+the stack; paths in a nested template are resolved from that template's
+directory. Directories are zipped, as are files other than .zip and .jar.
+Artifacts are uploaded to the stack's TemplateBucket, or
+Cmd:Deploy:TemplateBucket, under a key derived from their sha1, so unchanged
+artifacts are not uploaded again.
+
+Export, deploy, and diff package templates automatically. Package also prints
+a table of every artifact it uploaded. AWS credentials are only needed when a
+template references a local path.
+`,
+	Run: func(cmd *cobra.Command, args []string) {
+		defer log.Flush()
+
+		table := tablewriter.NewWriter(os.Stdout)
+		table.SetAutoWrapText(false)
+		table.SetHeader([]string{"Stack", "Resource", "Property", "Path", "Location"})
+		table.SetHeaderColor(tablewriter.Colors{tablewriter.FgWhiteColor}, tablewriter.Colors{tablewriter.FgWhiteColor}, tablewriter.Colors{tablewriter.FgWhiteColor}, tablewriter.Colors{tablewriter.FgWhiteColor}, tablewriter.Colors{tablewriter.FgWhiteColor})
+
+		buildInstances := stx.GetBuildInstances(args, config.PackageName)
+
+		stx.Process(buildInstances, flags, log, func(buildInstance *build.Instance, cueInstance *cue.Instance) {
+			stacksIterator, stacksIteratorErr := stx.NewStacksIterator(cueInstance, flags, log)
+			if stacksIteratorErr != nil {
+				log.Fatal(stacksIteratorErr)
+			}
+
+			for stacksIterator.Next() {
+				stackValue := stacksIterator.Value()
+				var stack stx.Stack
+				decodeErr := stackValue.Decode(&stack)
+				if decodeErr != nil {
+					log.Error(decodeErr)
+					continue
+				}
+				_, artifacts, exportErr := exportStack(log, stack, buildInstance, stackValue, &stackUploader{stack: stack})
+				if exportErr != nil {
+					log.Error(exportErr)
+					continue
+				}
+				for _, artifact := range artifacts {
+					table.Append([]string{stack.Name, artifact.Resource, artifact.Property, artifact.Path, "s3://" + artifact.Location.Bucket + "/" + artifact.Location.Key})
+				}
+			}
+		})
+
+		if table.NumLines() > 0 {
+			table.Render()
+		} else {
+			log.Info("No local artifacts found.")
+		}
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(packageCmd)
+}
+
+// templateBucket returns the bucket templates and artifacts of the stack are uploaded to
+func templateBucket(stack stx.Stack) string {
+	if stack.TemplateBucket != "" {
+		return stack.TemplateBucket
+	}
+	return config.Cmd.Deploy.TemplateBucket
+}
+
+// stackUploader uploads artifacts for a stack, only getting a session once the first artifact is found
+type stackUploader struct {
+	stack    stx.Stack
+	dryRun   bool // computes the S3 locations without uploading, for commands that only read
+	uploader *stx.S3Uploader
+}
+
+// Upload implements stx.Uploader
+func (u *stackUploader) Upload(body []byte, extension string) (stx.S3Location, error) {
+	if u.uploader == nil {
+		bucket := templateBucket(u.stack)
+		if bucket == "" {
+			return stx.S3Location{}, errors.New("no bucket to upload to. Set Cmd:Deploy:TemplateBucket in config.stx.cue or TemplateBucket on the stack")
+		}
+		if sessionErr := stx.EnsureSessionOnce(config); sessionErr != nil {
+			return stx.S3Location{}, sessionErr
+		}
+		session, sessionErr := stx.GetStackSession(u.stack)
+		if sessionErr != nil {
+			return stx.S3Location{}, sessionErr
+		}
+		if !u.dryRun {
+			if accountErr := stx.VerifyAccount(session, u.stack); accountErr != nil {
+				return stx.S3Location{}, accountErr
+			}
+		}
+		client := stx.NewS3Client(session, aws.NewConfig().WithRegion(u.stack.Region))
+		u.uploader = &stx.S3Uploader{Client: client, Bucket: bucket, DryRun: u.dryRun}
+	}
+	return u.uploader.Upload(body, extension)
+}
@@ -0,0 +1,487 @@
+package cmd
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"cuelang.org/go/cue"
+	"cuelang.org/go/cue/build"
+	"github.com/TangoGroup/stx/logger"
+	"github.com/TangoGroup/stx/stx"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/cloudformation"
+	"golang.org/x/crypto/ssh/terminal"
+	"gopkg.in/yaml.v2"
+)
+
+// stackParameters loads parameter values from the stack's Overrides, encoded as declared in Template.Parameters
+func stackParameters(ctx context.Context, stack stx.Stack, buildInstance *build.Instance, stackValue cue.Value, stackLog *logger.Logger) ([]*cloudformation.Parameter, error) {
+	templateParameters, templateParametersErr := stx.GetTemplateParameters(stackValue)
+	if templateParametersErr != nil {
+		return nil, templateParametersErr
+	}
+	if len(templateParameters) < 1 {
+		return nil, nil
+	}
+
+	var parameters []*cloudformation.Parameter
+
+	if flags.DeployPrevious {
+		// deploy using previous values
+		stackLog.Infof("%s", au.Gray(11, "  Using previous parameters..."))
+		for _, key := range sortedParameterKeys(templateParameters) {
+			parameters = append(parameters, &cloudformation.Parameter{ParameterKey: aws.String(key), UsePreviousValue: aws.Bool(true)})
+		}
+		stackLog.Check()
+		return parameters, nil
+	}
+
+	// load overrides
+	overrides, missing, overridesErr := loadOverrides(stack, buildInstance, templateParameters)
+	if overridesErr != nil {
+		return nil, overridesErr
+	}
+
+	// prompting can't happen without a user, so fail before anything is decrypted
+	if missingErr := missingOverridesError(stack, missing); missingErr != nil {
+		return nil, missingErr
+	}
+
+	parametersMap := make(map[string]string)
+
+	for _, override := range overrides {
+		stackLog.Infof("%s", au.Gray(11, "  Applying overrides: "+override.path+" "))
+
+		values := override.values
+		if override.behavior.SopsProfile != "" {
+			// decrypt the file contents
+			yamlBytes, yamlBytesErr := stx.DecryptSecrets(override.file, override.behavior.SopsProfile)
+			if yamlBytesErr != nil {
+				return nil, yamlBytesErr
+			}
+			values = nil
+			yamlUnmarshalErr := yaml.Unmarshal(yamlBytes, &values)
+			if yamlUnmarshalErr != nil {
+				return nil, fmt.Errorf("%s: %s", override.path, yamlUnmarshalErr)
+			}
+		}
+
+		for paramKey, valueKey := range override.keys {
+			encoded, encodeErr := templateParameters[paramKey].Encode(values[valueKey].value)
+			if encodeErr != nil {
+				return nil, fmt.Errorf("Parameter %s from %s: %s", paramKey, override.path, encodeErr)
+			}
+			parametersMap[paramKey] = encoded
+		}
+		stackLog.Check()
+	}
+
+	if len(missing) > 0 {
+		answers, promptErr := promptParameters(ctx, stack, templateParameters, missing)
+		if promptErr != nil {
+			return nil, promptErr
+		}
+		for paramKey, answer := range answers {
+			parametersMap[paramKey] = answer
+		}
+
+		if flags.DeploySaveOverrides != "" {
+			saveErr := saveOverrides(stack, buildInstance, templateParameters, answers)
+			if saveErr != nil {
+				// the answers can still be used for this deploy
+				stackLog.Error(saveErr)
+			}
+		}
+	}
+
+	// apply parameters to changeset
+	for _, key := range sortedKeys(parametersMap) {
+		parameters = append(parameters, &cloudformation.Parameter{ParameterKey: aws.String(key), ParameterValue: aws.String(parametersMap[key])})
+	}
+
+	return parameters, nil
+}
+
+// missingOverridesError returns an error for parameters without a value when --yes leaves no one to prompt
+func missingOverridesError(stack stx.Stack, missing []string) error {
+	if len(missing) < 1 || !flags.DeployYes {
+		return nil
+	}
+	var problems []string
+	for _, paramKey := range missing {
+		problems = append(problems, fmt.Sprintf("Parameter %s has no value in Overrides and no Default", paramKey))
+	}
+	return fmt.Errorf("Invalid overrides for stack %s:\n  %s", stack.Name, strings.Join(problems, "\n  "))
+}
+
+// checkOverrides loads the overrides of every stack without calling AWS, logging each stack whose overrides are invalid.
+// Returns the number of invalid stacks.
+func checkOverrides(stacks map[string]deployArgs) int {
+	if flags.DeployPrevious {
+		return 0
+	}
+	var names []string
+	for name := range stacks {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	invalid := 0
+	for _, name := range names {
+		dplArgs := stacks[name]
+		templateParameters, err := stx.GetTemplateParameters(dplArgs.stackValue)
+		if err == nil && len(templateParameters) > 0 {
+			var missing []string
+			_, missing, err = loadOverrides(dplArgs.stack, dplArgs.buildInstance, templateParameters)
+			if err == nil {
+				err = missingOverridesError(dplArgs.stack, missing)
+			}
+		}
+		if err != nil {
+			log.Error(err)
+			invalid++
+		}
+	}
+	return invalid
+}
+
+// overrideFile holds an overrides file and the parameter keys it supplies
+type overrideFile struct {
+	path, file string
+	behavior   stx.Override
+	// values holds the parsed yaml. Values in sops encrypted files are still encrypted.
+	values map[string]overrideValue
+	// keys maps parameter keys to keys in values
+	keys map[string]string
+}
+
+// overrideValue is a value from an overrides file. Scalars keep their literal text, because yaml would read e.g. no as false,
+// 1.10 as 1.1, and 0755 as 493. Lists keep the literal text of each item, and Encode only accepts them for list Types.
+type overrideValue struct {
+	value interface{} // a string, a []interface{} of strings, or anything else for Encode to reject
+}
+
+// UnmarshalYAML reads a scalar or a list of scalars as text, and anything else, such as the sops metadata, as it is
+func (v *overrideValue) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var scalar string
+	if unmarshal(&scalar) == nil {
+		v.value = scalar
+		return nil
+	}
+	var items []string
+	if unmarshal(&items) == nil {
+		list := make([]interface{}, len(items))
+		for i, item := range items {
+			list[i] = item
+		}
+		v.value = list
+		return nil
+	}
+	return unmarshal(&v.value)
+}
+
+// loadOverrides reads every overrides file of the stack and checks the keys they supply against Template.Parameters.
+// Keys of sops encrypted files are stored in plain text, so no AWS call is needed.
+// Also returns the parameters that have neither an override nor a Default.
+func loadOverrides(stack stx.Stack, buildInstance *build.Instance, templateParameters map[string]stx.TemplateParameter) ([]overrideFile, []string, error) {
+	var overrides []overrideFile
+	var problems []string
+	sources := make(map[string][]string) // parameter key -> paths that supply it
+
+	var overridePaths []string
+	for k := range stack.Overrides {
+		overridePaths = append(overridePaths, k)
+	}
+	sort.Strings(overridePaths)
+
+	for _, k := range overridePaths {
+		path := strings.Replace(k, "${STX::CuePath}", strings.Replace(buildInstance.Dir, buildInstance.Root+"/", "", 1), 1)
+		override := overrideFile{path: path, file: filepath.Clean(buildInstance.Root + "/" + path), behavior: stack.Overrides[k], keys: make(map[string]string)}
+
+		yamlBytes, yamlBytesErr := ioutil.ReadFile(override.file)
+		if yamlBytesErr != nil {
+			return nil, nil, yamlBytesErr
+		}
+
+		// values are encoded according to Template.Parameters[key].Type
+		yamlUnmarshalErr := yaml.Unmarshal(yamlBytes, &override.values)
+		if yamlUnmarshalErr != nil {
+			return nil, nil, fmt.Errorf("%s: %s", path, yamlUnmarshalErr)
+		}
+		if override.behavior.SopsProfile != "" {
+			delete(override.values, "sops")
+		}
+
+		if len(override.behavior.Map) > 0 {
+			// map the yaml key:value to parameter key:value, in order so that duplicates are reported the same way every time
+			var fromKeys []string
+			for fromKey := range override.behavior.Map {
+				fromKeys = append(fromKeys, fromKey)
+			}
+			sort.Strings(fromKeys)
+			for _, fromKey := range fromKeys {
+				toKey := override.behavior.Map[fromKey]
+				if _, ok := override.values[fromKey]; !ok {
+					problems = append(problems, fmt.Sprintf("%s has no key %s to map to Parameter %s", path, fromKey, toKey))
+					continue
+				}
+				if mappedKey, ok := override.keys[toKey]; ok {
+					problems = append(problems, fmt.Sprintf("Parameter %s is duplicated in %s, mapped from both %s and %s", toKey, path, mappedKey, fromKey))
+					continue
+				}
+				override.keys[toKey] = fromKey
+			}
+		} else {
+			// just do a straight copy, keys should align 1:1
+			for overrideKey := range override.values {
+				override.keys[overrideKey] = overrideKey
+			}
+		}
+
+		for paramKey := range override.keys {
+			sources[paramKey] = append(sources[paramKey], path)
+		}
+		overrides = append(overrides, override)
+	}
+
+	var sourceKeys []string
+	for paramKey := range sources {
+		sourceKeys = append(sourceKeys, paramKey)
+	}
+	sort.Strings(sourceKeys)
+
+	for _, paramKey := range sourceKeys {
+		if len(sources[paramKey]) > 1 {
+			problems = append(problems, fmt.Sprintf("Parameter %s is duplicated in %s", paramKey, strings.Join(sources[paramKey], " and ")))
+		}
+		if _, ok := templateParameters[paramKey]; !ok {
+			problems = append(problems, fmt.Sprintf("Parameter %s from %s does not exist in Template.Parameters", paramKey, strings.Join(sources[paramKey], ", ")))
+		}
+	}
+
+	var missing []string
+	for _, paramKey := range sortedParameterKeys(templateParameters) {
+		if _, ok := sources[paramKey]; !ok && templateParameters[paramKey].Default == nil {
+			missing = append(missing, paramKey)
+		}
+	}
+
+	if len(problems) > 0 {
+		return nil, nil, fmt.Errorf("Invalid overrides for stack %s:\n  %s", stack.Name, strings.Join(problems, "\n  "))
+	}
+	return overrides, missing, nil
+}
+
+// sortedParameterKeys returns the names of the parameters in order
+func sortedParameterKeys(templateParameters map[string]stx.TemplateParameter) []string {
+	var keys []string
+	for key := range templateParameters {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// sortedKeys returns the keys of the map in order
+func sortedKeys(m map[string]string) []string {
+	var keys []string
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// promptMu keeps prompts from overlapping, whether they come from stacks deployed in parallel or from the cleanup after an interrupt.
+// Everything that reads stdin holds it while asking and reading.
+var promptMu sync.Mutex
+
+// stdinReader reads whole lines, so that values may contain spaces. Every prompt reads through it, since a second
+// reader would miss the lines this one has already buffered when input is piped.
+var stdinReader = bufio.NewReader(os.Stdin)
+
+// stdinLine is a line read from stdin
+type stdinLine struct {
+	line string
+	err  error
+}
+
+// stdin hands lines to prompts from a goroutine, so that a prompt can stop waiting once its context is cancelled.
+// A line that is still being read when its prompt gives up goes to the next prompt instead.
+var stdin = struct {
+	sync.Mutex
+	reading bool
+	lines   chan stdinLine
+}{lines: make(chan stdinLine)}
+
+// readInput reads a line from stdin, hiding the input if noEcho is set and stdin is a terminal.
+// It returns ctx.Err() if ctx is cancelled first.
+func readInput(ctx context.Context, noEcho bool) (string, error) {
+	stdin.Lock()
+	if !stdin.reading {
+		stdin.reading = true
+		go func() {
+			line, err := readStdin(noEcho)
+			stdin.lines <- stdinLine{line, err}
+		}()
+	}
+	stdin.Unlock()
+
+	select {
+	case read := <-stdin.lines:
+		stdin.Lock()
+		stdin.reading = false
+		stdin.Unlock()
+		return read.line, read.err
+	case <-ctx.Done():
+		return "", ctx.Err()
+	}
+}
+
+// readLine reads a line from stdin without its line ending, unless ctx is cancelled first
+func readLine(ctx context.Context) (string, error) {
+	return readInput(ctx, false)
+}
+
+// readStdin reads a line from stdin without its line ending. The last line may end at EOF instead.
+func readStdin(noEcho bool) (string, error) {
+	fd := int(os.Stdin.Fd())
+	if noEcho && terminal.IsTerminal(fd) {
+		state, stateErr := terminal.GetState(fd)
+		if stateErr != nil {
+			return "", stateErr
+		}
+		hiddenInput.Lock()
+		hiddenInput.fd, hiddenInput.state = fd, state
+		hiddenInput.Unlock()
+		defer restoreTerminal()
+
+		password, passwordErr := terminal.ReadPassword(fd)
+		log.Info()
+		return string(password), passwordErr
+	}
+
+	line, lineErr := stdinReader.ReadString('\n')
+	if lineErr != nil && !(lineErr == io.EOF && line != "") {
+		return "", lineErr
+	}
+	return strings.TrimRight(line, "\r\n"), nil
+}
+
+// promptParameters asks for a value for each of the named parameters, repeating until the value is valid or ctx is cancelled
+func promptParameters(ctx context.Context, stack stx.Stack, templateParameters map[string]stx.TemplateParameter, paramKeys []string) (map[string]string, error) {
+	promptMu.Lock()
+	defer promptMu.Unlock()
+
+	answers := make(map[string]string)
+	log.Infof("%s %s\n", au.Index(255-88, "Enter values for parameters without overrides in"), au.Magenta(stack.Name))
+	for _, paramKey := range paramKeys {
+		templateParameter := templateParameters[paramKey]
+		log.Infof("%s %s\n", au.White(paramKey), au.Gray(11, "("+templateParameter.Type+")"))
+		if templateParameter.Description != "" {
+			log.Infof("  %s\n", au.Gray(11, templateParameter.Description))
+		}
+		if len(templateParameter.AllowedValues) > 0 {
+			log.Infof("  %s %s\n", au.Gray(11, "AllowedValues:"), strings.Join(templateParameter.AllowedValueStrings(), ", "))
+		}
+		if templateParameter.ConstraintDescription != "" {
+			log.Infof("  %s %s\n", au.Gray(11, "Constraint:"), templateParameter.ConstraintDescription)
+		}
+
+		for {
+			log.Infof("%s", au.Gray(11, "▶︎"))
+			answer, answerErr := readInput(ctx, templateParameter.IsNoEcho())
+			if answerErr != nil {
+				return nil, answerErr
+			}
+			validateErr := templateParameter.Validate(answer)
+			if validateErr == nil {
+				answers[paramKey] = answer
+				break
+			}
+			log.Warnf("%s\n", validateErr)
+		}
+	}
+	return answers, nil
+}
+
+// hiddenInput holds the state of the terminal while input is hidden, so an interrupt can turn echo back on before exiting
+var hiddenInput = struct {
+	sync.Mutex
+	fd    int
+	state *terminal.State
+}{}
+
+// restoreTerminal turns echo back on if input is being hidden
+func restoreTerminal() {
+	hiddenInput.Lock()
+	defer hiddenInput.Unlock()
+	if hiddenInput.state != nil {
+		terminal.Restore(hiddenInput.fd, hiddenInput.state)
+		hiddenInput.state = nil
+	}
+}
+
+// saveOverrides writes the answers to the file named by --save-overrides, encrypting with sops if --sops-kms-arn is set.
+// Answers to NoEcho parameters are only saved encrypted, and are left out otherwise.
+func saveOverrides(stack stx.Stack, buildInstance *build.Instance, templateParameters map[string]stx.TemplateParameter, answers map[string]string) error {
+	if flags.DeploySopsKmsArn == "" {
+		var noEcho []string
+		plain := make(map[string]string)
+		for _, paramKey := range sortedKeys(answers) {
+			if templateParameters[paramKey].IsNoEcho() {
+				noEcho = append(noEcho, paramKey)
+				continue
+			}
+			plain[paramKey] = answers[paramKey]
+		}
+		if len(noEcho) > 0 {
+			log.Warnf("Not saving NoEcho parameters %s of %s without encryption. Use --sops-kms-arn to save them.\n", strings.Join(noEcho, ", "), stack.Name)
+		}
+		if len(plain) < 1 {
+			return nil
+		}
+		answers = plain
+	}
+
+	path := strings.Replace(flags.DeploySaveOverrides, "${STX::CuePath}", strings.Replace(buildInstance.Dir, buildInstance.Root+"/", "", 1), 1)
+	path = strings.Replace(path, "${STX::StackName}", stack.Name, 1)
+	fileName := filepath.Clean(buildInstance.Root + "/" + path)
+
+	if _, statErr := os.Stat(fileName); !os.IsNotExist(statErr) {
+		return fmt.Errorf("Not saving overrides, %s already exists", fileName)
+	}
+
+	yamlBytes, yamlErr := yaml.Marshal(answers)
+	if yamlErr != nil {
+		return yamlErr
+	}
+
+	override := "{}"
+	if flags.DeploySopsKmsArn != "" {
+		var encryptErr error
+		yamlBytes, encryptErr = stx.EncryptSecrets(yamlBytes, flags.DeploySopsKmsArn, stack.Profile)
+		if encryptErr != nil {
+			return encryptErr
+		}
+		override = fmt.Sprintf("{SopsProfile: %q}", stack.Profile)
+	}
+
+	os.MkdirAll(filepath.Dir(fileName), 0755)
+	writeErr := ioutil.WriteFile(fileName, yamlBytes, 0600)
+	if writeErr != nil {
+		return writeErr
+	}
+
+	log.Infof("%s %s %s %s\n", au.White("Saved overrides"), au.Magenta(stack.Name), au.White("⤏"), fileName)
+	log.Infof("%s\n  Overrides: %q: %s\n", au.Gray(11, "Add it to the stack to use it next time:"), path, override)
+	return nil
+}
@@ -0,0 +1,209 @@
+package cmd
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"cuelang.org/go/cue/build"
+	"github.com/TangoGroup/stx/logger"
+	"github.com/TangoGroup/stx/stx"
+	"github.com/logrusorgru/aurora"
+	"gopkg.in/yaml.v2"
+)
+
+// TestMain sets up the console output that rootCmd would, without color
+func TestMain(m *testing.M) {
+	log = logger.NewLogger(false, true)
+	au = aurora.NewAurora(false)
+	os.Exit(m.Run())
+}
+
+func TestOverrideValueKeepsLiteralText(t *testing.T) {
+	var values map[string]overrideValue
+	yamlErr := yaml.Unmarshal([]byte(`
+Enabled: no
+Version: 1.10
+Mode: 0755
+Size: 1e3
+Empty: ""
+Subnets: [subnet-1, 0755]
+sops: {kms: [{arn: key}]}
+`), &values)
+	if yamlErr != nil {
+		t.Fatal(yamlErr)
+	}
+
+	want := map[string]interface{}{
+		"Enabled": "no",
+		"Version": "1.10",
+		"Mode":    "0755",
+		"Size":    "1e3",
+		"Empty":   "",
+		"Subnets": []interface{}{"subnet-1", "0755"},
+	}
+	for key, value := range want {
+		if !reflect.DeepEqual(values[key].value, value) {
+			t.Errorf("%s: got %#v, want %#v", key, values[key].value, value)
+		}
+	}
+	if _, ok := values["sops"].value.(map[interface{}]interface{}); !ok {
+		t.Errorf("sops: got %#v, want a map", values["sops"].value)
+	}
+}
+
+func TestLoadOverrides(t *testing.T) {
+	root, dirErr := ioutil.TempDir("", "stx-overrides")
+	if dirErr != nil {
+		t.Fatal(dirErr)
+	}
+	defer os.RemoveAll(root)
+	buildInstance := &build.Instance{Root: root, Dir: filepath.Join(root, "stacks")}
+	os.MkdirAll(buildInstance.Dir, 0755)
+	files := map[string]string{
+		"common.yml": "Env: dev\nSize: 2\n",
+		"env.yml":    "Env: prod\n",
+		"extra.yml":  "Env: dev\nTypo: 1\n",
+		"mapped.yml": "environment: dev\nstage: dev\n",
+	}
+	for name, contents := range files {
+		if writeErr := ioutil.WriteFile(filepath.Join(buildInstance.Dir, name), []byte(contents), 0644); writeErr != nil {
+			t.Fatal(writeErr)
+		}
+	}
+	templateParameters := map[string]stx.TemplateParameter{
+		"Env":    {Type: "String"},
+		"Size":   {Type: "Number"},
+		"Secret": {Type: "String"},
+		"Port":   {Type: "Number", Default: 443},
+	}
+
+	tests := []struct {
+		name      string
+		overrides map[string]stx.Override
+		missing   []string
+		problems  []string
+	}{
+		{
+			name:      "one file",
+			overrides: map[string]stx.Override{"${STX::CuePath}/common.yml": {}},
+			missing:   []string{"Secret"},
+		},
+		{
+			name:      "duplicate keys across files",
+			overrides: map[string]stx.Override{"${STX::CuePath}/common.yml": {}, "${STX::CuePath}/env.yml": {}},
+			problems:  []string{"Parameter Env is duplicated in stacks/common.yml and stacks/env.yml"},
+		},
+		{
+			name:      "unknown key",
+			overrides: map[string]stx.Override{"${STX::CuePath}/extra.yml": {}},
+			problems:  []string{"Parameter Typo from stacks/extra.yml does not exist in Template.Parameters"},
+		},
+		{
+			name:      "mapped keys",
+			overrides: map[string]stx.Override{"${STX::CuePath}/mapped.yml": {Map: map[string]string{"environment": "Env"}}},
+			missing:   []string{"Secret", "Size"},
+		},
+		{
+			name:      "duplicate mapped keys",
+			overrides: map[string]stx.Override{"${STX::CuePath}/mapped.yml": {Map: map[string]string{"environment": "Env", "stage": "Env"}}},
+			problems:  []string{"Parameter Env is duplicated in stacks/mapped.yml, mapped from both environment and stage"},
+		},
+		{
+			name:      "mapped key not in the file",
+			overrides: map[string]stx.Override{"${STX::CuePath}/mapped.yml": {Map: map[string]string{"region": "Env"}}},
+			problems:  []string{"stacks/mapped.yml has no key region to map to Parameter Env"},
+		},
+	}
+	for _, test := range tests {
+		stack := stx.Stack{Name: "test-stack", Overrides: test.overrides}
+		_, missing, err := loadOverrides(stack, buildInstance, templateParameters)
+		if len(test.problems) > 0 {
+			if err == nil {
+				t.Errorf("%s: got no error, want %v", test.name, test.problems)
+				continue
+			}
+			for _, problem := range test.problems {
+				if !strings.Contains(err.Error(), problem) {
+					t.Errorf("%s: got error %q, want it to report %q", test.name, err, problem)
+				}
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: got error %v", test.name, err)
+			continue
+		}
+		if !reflect.DeepEqual(missing, test.missing) {
+			t.Errorf("%s: got missing %v, want %v", test.name, missing, test.missing)
+		}
+	}
+}
+
+func TestSaveOverridesLeavesOutNoEcho(t *testing.T) {
+	root, dirErr := ioutil.TempDir("", "stx-save-overrides")
+	if dirErr != nil {
+		t.Fatal(dirErr)
+	}
+	defer os.RemoveAll(root)
+	originalFlags := flags
+	defer func() { flags = originalFlags }()
+	flags.DeploySaveOverrides, flags.DeploySopsKmsArn = "${STX::StackName}.yml", ""
+
+	templateParameters := map[string]stx.TemplateParameter{
+		"Env":      {Type: "String"},
+		"Password": {Type: "String", NoEcho: true},
+	}
+	buildInstance := &build.Instance{Root: root, Dir: root}
+	saveErr := saveOverrides(stx.Stack{Name: "test-stack"}, buildInstance, templateParameters, map[string]string{"Env": "dev", "Password": "hunter2"})
+	if saveErr != nil {
+		t.Fatal(saveErr)
+	}
+	saved, readErr := ioutil.ReadFile(filepath.Join(root, "test-stack.yml"))
+	if readErr != nil {
+		t.Fatal(readErr)
+	}
+	if want := "Env: dev\n"; string(saved) != want {
+		t.Errorf("saved %q, want %q", saved, want)
+	}
+
+	// with only NoEcho answers, nothing is written
+	flags.DeploySaveOverrides = "secrets.yml"
+	saveErr = saveOverrides(stx.Stack{Name: "test-stack"}, buildInstance, templateParameters, map[string]string{"Password": "hunter2"})
+	if saveErr != nil {
+		t.Fatal(saveErr)
+	}
+	if _, statErr := os.Stat(filepath.Join(root, "secrets.yml")); !os.IsNotExist(statErr) {
+		t.Error("saved a file with only NoEcho answers")
+	}
+}
+
+func TestReadInputAfterCancel(t *testing.T) {
+	pipeReader, pipeWriter := io.Pipe()
+	defer pipeWriter.Close()
+	originalReader := stdinReader
+	defer func() { stdinReader = originalReader }()
+	stdinReader = bufio.NewReader(pipeReader)
+
+	// a prompt that gives up leaves its read pending
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := readInput(ctx, false); err != context.Canceled {
+		t.Fatalf("got %v from a cancelled prompt, want context.Canceled", err)
+	}
+
+	// the line typed next goes to the next prompt, and later lines to the prompts after it
+	go pipeWriter.Write([]byte("yes\nsecond line\n"))
+	for _, want := range []string{"yes", "second line"} {
+		line, err := readLine(context.Background())
+		if err != nil || line != want {
+			t.Errorf("got %q, %v; want %q", line, err, want)
+		}
+	}
+}
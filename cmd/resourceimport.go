@@ -0,0 +1,153 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"sort"
+	"strings"
+
+	"github.com/TangoGroup/stx/stx"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/cloudformation"
+	"github.com/ghodss/yaml"
+)
+
+// importFile holds the identifiers read from --import-file, by stack name and then logical ID
+var importFile map[string]map[string]interface{}
+
+// readImportFile reads the yaml or json file given to --import-file
+func readImportFile(path string) error {
+	fileBytes, readErr := ioutil.ReadFile(path)
+	if readErr != nil {
+		return readErr
+	}
+	if unmarshalErr := yaml.Unmarshal(fileBytes, &importFile); unmarshalErr != nil {
+		return fmt.Errorf("%s: %s", path, unmarshalErr)
+	}
+	return nil
+}
+
+// declaredImports returns the identifiers declared for the stack, with --import-file taking precedence over Stack.Import
+func declaredImports(stack stx.Stack) map[string]interface{} {
+	declared := make(map[string]interface{})
+	for logicalID, identifier := range stack.Import {
+		declared[logicalID] = identifier
+	}
+	for logicalID, identifier := range importFile[stack.Name] {
+		declared[logicalID] = identifier
+	}
+	return declared
+}
+
+// resourcesToImport builds the ResourcesToImport of an IMPORT change set from the identifiers declared for the stack.
+// Every imported resource must be in the template with DeletionPolicy Retain. Resources the stack already manages are left out.
+func resourcesToImport(ctx context.Context, cfn *cloudformation.CloudFormation, stack stx.Stack, templateBody, templateURL string, stackExists bool) ([]*cloudformation.ResourceToImport, error) {
+	declared := declaredImports(stack)
+	if len(declared) < 1 {
+		return nil, nil
+	}
+
+	var template struct {
+		Resources map[string]struct {
+			Type, DeletionPolicy string
+		}
+	}
+	if unmarshalErr := yaml.Unmarshal([]byte(templateBody), &template); unmarshalErr != nil {
+		return nil, unmarshalErr
+	}
+
+	managed := make(map[string]bool)
+	if stackExists {
+		listInput := cloudformation.ListStackResourcesInput{StackName: aws.String(stack.Name)}
+		listErr := cfn.ListStackResourcesPagesWithContext(ctx, &listInput, func(page *cloudformation.ListStackResourcesOutput, lastPage bool) bool {
+			for _, resource := range page.StackResourceSummaries {
+				managed[aws.StringValue(resource.LogicalResourceId)] = true
+			}
+			return true
+		})
+		if listErr != nil {
+			return nil, listErr
+		}
+	}
+
+	logicalIDs := make([]string, 0, len(declared))
+	for logicalID := range declared {
+		logicalIDs = append(logicalIDs, logicalID)
+	}
+	sort.Strings(logicalIDs)
+
+	var problems []string
+	var imports []*cloudformation.ResourceToImport
+	var identifierProperties map[string][]string
+	for _, logicalID := range logicalIDs {
+		resource, ok := template.Resources[logicalID]
+		if !ok {
+			problems = append(problems, logicalID+" is not a resource in the template")
+			continue
+		}
+		if resource.DeletionPolicy != "Retain" {
+			problems = append(problems, logicalID+" must have DeletionPolicy: Retain to be imported")
+			continue
+		}
+		if managed[logicalID] {
+			continue
+		}
+
+		identifier := make(map[string]*string)
+		switch value := declared[logicalID].(type) {
+		case map[string]interface{}:
+			for property, propertyValue := range value {
+				identifier[property] = aws.String(fmt.Sprint(propertyValue))
+			}
+		case string:
+			// a plain physical ID is only enough when the resource type has a single identifier property
+			if identifierProperties == nil {
+				var summaryErr error
+				identifierProperties, summaryErr = resourceIdentifierProperties(ctx, cfn, templateBody, templateURL)
+				if summaryErr != nil {
+					return nil, summaryErr
+				}
+			}
+			properties := identifierProperties[resource.Type]
+			if len(properties) != 1 {
+				problems = append(problems, fmt.Sprintf("%s is identified by %s; declare a map of each property to its value", logicalID, strings.Join(properties, ", ")))
+				continue
+			}
+			identifier[properties[0]] = aws.String(value)
+		default:
+			problems = append(problems, logicalID+" must be declared as a physical ID or a map of identifier properties")
+			continue
+		}
+
+		imports = append(imports, &cloudformation.ResourceToImport{
+			LogicalResourceId:  aws.String(logicalID),
+			ResourceType:       aws.String(resource.Type),
+			ResourceIdentifier: identifier,
+		})
+	}
+
+	if len(problems) > 0 {
+		return nil, fmt.Errorf("cannot import into %s:\n  %s", stack.Name, strings.Join(problems, "\n  "))
+	}
+	return imports, nil
+}
+
+// resourceIdentifierProperties returns the properties that identify each resource type in the template
+func resourceIdentifierProperties(ctx context.Context, cfn *cloudformation.CloudFormation, templateBody, templateURL string) (map[string][]string, error) {
+	summaryInput := cloudformation.GetTemplateSummaryInput{}
+	if templateURL != "" {
+		summaryInput.SetTemplateURL(templateURL)
+	} else {
+		summaryInput.SetTemplateBody(templateBody)
+	}
+	summaryOutput, summaryErr := cfn.GetTemplateSummaryWithContext(ctx, &summaryInput)
+	if summaryErr != nil {
+		return nil, summaryErr
+	}
+	properties := make(map[string][]string)
+	for _, summary := range summaryOutput.ResourceIdentifierSummaries {
+		properties[aws.StringValue(summary.ResourceType)] = aws.StringValueSlice(summary.ResourceIdentifiers)
+	}
+	return properties, nil
+}
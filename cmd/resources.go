@@ -30,7 +30,9 @@ resources currently managed in the stack.
 
 		// TODO add debug messages
 		defer log.Flush()
-		stx.EnsureVaultSession(config)
+		if sessionErr := stx.EnsureSession(config); sessionErr != nil {
+			log.Fatal(sessionErr)
+		}
 
 		buildInstances := stx.GetBuildInstances(args, config.PackageName)
 
@@ -50,7 +52,11 @@ resources currently managed in the stack.
 				}
 
 				// get a session and cloudformation service client
-				session := stx.GetSession(stack.Profile)
+				session, sessionErr := stx.GetStackSession(stack)
+				if sessionErr != nil {
+					log.Error(sessionErr)
+					continue
+				}
 				cfn := cloudformation.New(session, aws.NewConfig().WithRegion(stack.Region))
 				log.Infof("%s %s...\n", au.White("Describing"), au.Magenta(stack.Name))
 
@@ -0,0 +1,89 @@
+package cmd
+
+import (
+	"os"
+	"sync"
+
+	"github.com/TangoGroup/stx/logger"
+	"github.com/TangoGroup/stx/stx"
+	"github.com/olekukonko/tablewriter"
+)
+
+// outcomes of deploying a stack, shown in the summary at the end of a run
+const (
+	outcomeSucceeded = "Succeeded"
+	outcomeExecuted  = "Executed" // executed without --wait, so the final status is unknown
+	outcomeNoChanges = "No changes"
+	outcomePlanned   = "Planned"
+	outcomeCancelled = "Cancelled"
+	outcomeSkipped   = "Skipped"
+	outcomeFailed    = "Failed"
+)
+
+// deployResult is the outcome of one stack in the run
+type deployResult struct {
+	stack            stx.Stack
+	outcome, details string
+}
+
+// deployResults collects the outcome of every stack in the run, in the order they finished
+var deployResults = struct {
+	sync.Mutex
+	results []deployResult
+}{}
+
+// recordResult adds the outcome of a stack to the summary
+func recordResult(stack stx.Stack, outcome, details string) {
+	deployResults.Lock()
+	defer deployResults.Unlock()
+	deployResults.results = append(deployResults.results, deployResult{stack: stack, outcome: outcome, details: details})
+}
+
+// deployFailed logs err for the stack, records it as failed, and returns nil so callers can return it directly
+func deployFailed(stackLog *logger.Logger, stack stx.Stack, err error) *stackDeployment {
+	stackLog.Error(err)
+	recordResult(stack, outcomeFailed, err.Error())
+	return nil
+}
+
+// stackFailed returns true if a stack in names has failed in this run
+func stackFailed(names ...string) bool {
+	deployResults.Lock()
+	defer deployResults.Unlock()
+	for _, result := range deployResults.results {
+		for _, name := range names {
+			if result.stack.Name == name && result.outcome == outcomeFailed {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// printDeployResults renders a table of every stack in the run. Failures have already been logged as errors, so the exit code reflects them.
+func printDeployResults() {
+	deployResults.Lock()
+	defer deployResults.Unlock()
+	if len(deployResults.results) < 1 {
+		return
+	}
+
+	table := tablewriter.NewWriter(os.Stdout)
+	table.SetAutoWrapText(false)
+	table.SetHeader([]string{"Stack", "Profile", "Region", "Result", "Details"})
+	table.SetHeaderColor(tablewriter.Colors{tablewriter.FgWhiteColor}, tablewriter.Colors{tablewriter.FgWhiteColor}, tablewriter.Colors{tablewriter.FgWhiteColor}, tablewriter.Colors{tablewriter.FgWhiteColor}, tablewriter.Colors{tablewriter.FgWhiteColor})
+
+	for _, result := range deployResults.results {
+		outcome := result.outcome
+		switch outcome {
+		case outcomeSucceeded, outcomeExecuted, outcomePlanned:
+			outcome = au.BrightGreen(outcome).String()
+		case outcomeCancelled, outcomeSkipped:
+			outcome = au.Yellow(outcome).String()
+		case outcomeFailed:
+			outcome = au.Red(outcome).String()
+		}
+		table.Append([]string{result.stack.Name, result.stack.Profile, result.stack.Region, outcome, result.details})
+	}
+	table.Render()
+}
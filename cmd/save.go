@@ -50,7 +50,9 @@ as spaces or hyphens will be removed from folder and package names.
 	Run: func(cmd *cobra.Command, args []string) {
 
 		defer log.Flush()
-		stx.EnsureVaultSession(config)
+		if sessionErr := stx.EnsureSession(config); sessionErr != nil {
+			log.Fatal(sessionErr)
+		}
 
 		buildInstances := stx.GetBuildInstances(args, config.PackageName)
 
@@ -81,7 +83,10 @@ as spaces or hyphens will be removed from folder and package names.
 func saveStackOutputs(buildInstance *build.Instance, stack stx.Stack) error {
 
 	// get a session and cloudformation service client
-	session := stx.GetSession(stack.Profile)
+	session, sessionErr := stx.GetStackSession(stack)
+	if sessionErr != nil {
+		return sessionErr
+	}
 	cfn := cloudformation.New(session, aws.NewConfig().WithRegion(stack.Region))
 	describeStacksInput := cloudformation.DescribeStacksInput{StackName: aws.String(stack.Name)}
 	describeStacksOutput, describeStacksErr := cfn.DescribeStacks(&describeStacksInput)
@@ -0,0 +1,224 @@
+package cmd
+
+import (
+	"bytes"
+	"encoding/json"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/TangoGroup/stx/logger"
+	"github.com/TangoGroup/stx/stx"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/cloudformation"
+	"github.com/olekukonko/tablewriter"
+)
+
+// settingDiff is a stack setting whose live value differs from the one declared on the stack
+type settingDiff struct {
+	setting, live, declared string
+}
+
+// stackSettingsDiffs compares the settings declared on the stack with the live stack. Settings that are not declared are not compared.
+// TimeoutInMinutes can only be set when a stack is created without a change set, so it is not compared.
+func stackSettingsDiffs(cfn *cloudformation.CloudFormation, stack stx.Stack) ([]settingDiff, error) {
+	var diffs []settingDiff
+	describeStacksOutput, describeStacksErr := cfn.DescribeStacks(&cloudformation.DescribeStacksInput{StackName: aws.String(stack.Name)})
+	if describeStacksErr != nil {
+		return nil, describeStacksErr
+	}
+	live := describeStacksOutput.Stacks[0]
+
+	if stack.EnableTerminationProtection != nil && *stack.EnableTerminationProtection != aws.BoolValue(live.EnableTerminationProtection) {
+		diffs = append(diffs, settingDiff{
+			setting:  "EnableTerminationProtection",
+			live:     strconv.FormatBool(aws.BoolValue(live.EnableTerminationProtection)),
+			declared: strconv.FormatBool(*stack.EnableTerminationProtection),
+		})
+	}
+
+	if stack.StackPolicy != nil {
+		policyOutput, policyErr := cfn.GetStackPolicy(&cloudformation.GetStackPolicyInput{StackName: aws.String(stack.Name)})
+		if policyErr != nil {
+			return nil, policyErr
+		}
+		// round trip the declared policy so that both sides decode to the same types
+		declaredPolicy, _ := json.Marshal(stack.StackPolicy)
+		var declared, livePolicy interface{}
+		json.Unmarshal(declaredPolicy, &declared)
+		json.Unmarshal([]byte(aws.StringValue(policyOutput.StackPolicyBody)), &livePolicy)
+		if !reflect.DeepEqual(declared, livePolicy) {
+			liveBody := aws.StringValue(policyOutput.StackPolicyBody)
+			if liveBody == "" {
+				liveBody = "-"
+			}
+			diffs = append(diffs, settingDiff{setting: "StackPolicy", live: liveBody, declared: string(declaredPolicy)})
+		}
+	}
+
+	if stack.RollbackConfiguration != nil {
+		declared := rollbackConfiguration(stack.RollbackConfiguration)
+		liveConfiguration := normalizeRollbackConfiguration(live.RollbackConfiguration)
+		if !reflect.DeepEqual(normalizeRollbackConfiguration(declared), liveConfiguration) {
+			liveJSON, _ := json.Marshal(liveConfiguration)
+			declaredJSON, _ := json.Marshal(normalizeRollbackConfiguration(declared))
+			diffs = append(diffs, settingDiff{setting: "RollbackConfiguration", live: string(liveJSON), declared: string(declaredJSON)})
+		}
+	}
+
+	if stack.NotificationARNs != nil {
+		declared := notificationARNs(stack)
+		liveArns := aws.StringValueSlice(live.NotificationARNs)
+		sort.Strings(declared)
+		sort.Strings(liveArns)
+		if strings.Join(declared, ",") != strings.Join(liveArns, ",") {
+			diffs = append(diffs, settingDiff{setting: "NotificationARNs", live: strings.Join(liveArns, ", "), declared: strings.Join(declared, ", ")})
+		}
+	}
+
+	return diffs, nil
+}
+
+// newStackSettingsDiffs returns the settings declared on a stack that has not been created yet, against the defaults it is created with
+func newStackSettingsDiffs(stack stx.Stack) []settingDiff {
+	var diffs []settingDiff
+	if stack.EnableTerminationProtection != nil && *stack.EnableTerminationProtection {
+		diffs = append(diffs, settingDiff{setting: "EnableTerminationProtection", live: "false", declared: "true"})
+	}
+	if stack.StackPolicy != nil {
+		declaredPolicy, _ := json.Marshal(stack.StackPolicy)
+		diffs = append(diffs, settingDiff{setting: "StackPolicy", live: "-", declared: string(declaredPolicy)})
+	}
+	return diffs
+}
+
+// appliedSettings returns the diffs applyStackSettings applies. RollbackConfiguration and NotificationARNs are part of the change set instead.
+func appliedSettings(diffs []settingDiff) []settingDiff {
+	var applied []settingDiff
+	for _, diff := range diffs {
+		if diff.setting == "EnableTerminationProtection" || diff.setting == "StackPolicy" {
+			applied = append(applied, diff)
+		}
+	}
+	return applied
+}
+
+// settingsApproveViolations returns the reasons Cmd:Deploy:AutoApprove:Policy refuses to apply the settings diffs.
+// Under "Safe", settings may add protection but not take it away: termination protection may be enabled but not
+// disabled, and a stack policy may be set on a stack without one but not replace a live policy.
+func settingsApproveViolations(diffs []settingDiff) []string {
+	var violations []string
+	if config.Cmd.Deploy.AutoApprove.Policy == "Any" {
+		return violations
+	}
+	for _, diff := range diffs {
+		switch diff.setting {
+		case "EnableTerminationProtection":
+			if diff.declared == "false" {
+				violations = append(violations, "EnableTerminationProtection would be disabled")
+			}
+		case "StackPolicy":
+			if diff.live != "-" {
+				violations = append(violations, "StackPolicy would replace the live stack policy")
+			}
+		}
+	}
+	return violations
+}
+
+// applyStackSettings sets the stack policy and termination protection of a stack to the declared values in the approved diffs
+func applyStackSettings(stackLog *logger.Logger, cfn *cloudformation.CloudFormation, stackName string, diffs []settingDiff) error {
+	for _, diff := range appliedSettings(diffs) {
+		switch diff.setting {
+		case "EnableTerminationProtection":
+			stackLog.Infof("%s %s %s %s\n", au.White("Setting EnableTerminationProtection"), diff.live, au.White("⤏"), diff.declared)
+			_, updateErr := cfn.UpdateTerminationProtection(&cloudformation.UpdateTerminationProtectionInput{
+				StackName:                   aws.String(stackName),
+				EnableTerminationProtection: aws.Bool(diff.declared == "true"),
+			})
+			if updateErr != nil {
+				return updateErr
+			}
+		case "StackPolicy":
+			stackLog.Infof("%s %s\n", au.White("Setting StackPolicy"), au.Gray(11, diff.declared))
+			_, setErr := cfn.SetStackPolicy(&cloudformation.SetStackPolicyInput{
+				StackName:       aws.String(stackName),
+				StackPolicyBody: aws.String(diff.declared),
+			})
+			if setErr != nil {
+				return setErr
+			}
+		}
+	}
+	return nil
+}
+
+// rollbackConfiguration converts the declared rollback configuration for the change set input
+func rollbackConfiguration(declared *stx.RollbackConfiguration) *cloudformation.RollbackConfiguration {
+	configuration := &cloudformation.RollbackConfiguration{
+		MonitoringTimeInMinutes: aws.Int64(declared.MonitoringTimeInMinutes),
+		RollbackTriggers:        []*cloudformation.RollbackTrigger{},
+	}
+	for _, trigger := range declared.RollbackTriggers {
+		triggerType := trigger.Type
+		if triggerType == "" {
+			triggerType = "AWS::CloudWatch::Alarm"
+		}
+		configuration.RollbackTriggers = append(configuration.RollbackTriggers, &cloudformation.RollbackTrigger{Arn: aws.String(trigger.Arn), Type: aws.String(triggerType)})
+	}
+	return configuration
+}
+
+// normalizeRollbackConfiguration returns a comparable copy of a rollback configuration, with triggers sorted by arn
+func normalizeRollbackConfiguration(configuration *cloudformation.RollbackConfiguration) stx.RollbackConfiguration {
+	var normalized stx.RollbackConfiguration
+	if configuration == nil {
+		return normalized
+	}
+	normalized.MonitoringTimeInMinutes = aws.Int64Value(configuration.MonitoringTimeInMinutes)
+	for _, trigger := range configuration.RollbackTriggers {
+		normalized.RollbackTriggers = append(normalized.RollbackTriggers, stx.RollbackTrigger{Arn: aws.StringValue(trigger.Arn), Type: aws.StringValue(trigger.Type)})
+	}
+	sort.Slice(normalized.RollbackTriggers, func(i, j int) bool {
+		return normalized.RollbackTriggers[i].Arn < normalized.RollbackTriggers[j].Arn
+	})
+	return normalized
+}
+
+// notificationARNs returns the declared NotificationARNs of the stack, plus the Cmd:Deploy:Notify:TopicArn if one is configured
+func notificationARNs(stack stx.Stack) []string {
+	arns := append([]string{}, stack.NotificationARNs...)
+	if topicArn := config.Cmd.Deploy.Notify.TopicArn; topicArn != "" {
+		found := false
+		for _, arn := range arns {
+			if arn == topicArn {
+				found = true
+			}
+		}
+		if !found {
+			arns = append(arns, topicArn)
+		}
+	}
+	return arns
+}
+
+// printSettingsDiffs renders a table of stack settings that differ from the live stack
+func printSettingsDiffs(stackLog *logger.Logger, stackName string, diffs []settingDiff) {
+	if len(diffs) < 1 {
+		return
+	}
+	stackLog.Warnf("%s %s\n", au.Magenta(stackName), au.Yellow("settings differ from the declared settings:"))
+
+	// render into a buffer so the table is written as a single block
+	var tableBuf bytes.Buffer
+	table := tablewriter.NewWriter(&tableBuf)
+	table.SetAutoWrapText(false)
+	table.SetHeader([]string{"Setting", "Live", "Declared"})
+	table.SetHeaderColor(tablewriter.Colors{tablewriter.FgWhiteColor}, tablewriter.Colors{tablewriter.FgWhiteColor}, tablewriter.Colors{tablewriter.FgWhiteColor})
+	for _, diff := range diffs {
+		table.Append([]string{diff.setting, au.Red(diff.live).String(), au.Green(diff.declared).String()})
+	}
+	table.Render()
+	stackLog.Stdout().Write(tableBuf.Bytes())
+}
@@ -0,0 +1,67 @@
+package cmd
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/TangoGroup/stx/stx"
+)
+
+func TestSettingsApproveViolations(t *testing.T) {
+	defer func(previous *stx.Config) { config = previous }(config)
+	config = &stx.Config{}
+
+	tests := []struct {
+		name  string
+		diffs []settingDiff
+		safe  []string
+	}{
+		{"enable termination protection", []settingDiff{{"EnableTerminationProtection", "false", "true"}}, nil},
+		{"disable termination protection", []settingDiff{{"EnableTerminationProtection", "true", "false"}}, []string{"EnableTerminationProtection would be disabled"}},
+		{"new stack policy", []settingDiff{{"StackPolicy", "-", `{"Statement":[]}`}}, nil},
+		{"replaced stack policy", []settingDiff{{"StackPolicy", `{"Statement":[{}]}`, `{"Statement":[]}`}}, []string{"StackPolicy would replace the live stack policy"}},
+		{"notification arns", []settingDiff{{"NotificationARNs", "a", "b"}}, nil},
+	}
+	for _, test := range tests {
+		config.Cmd.Deploy.AutoApprove.Policy = "Safe"
+		if violations := settingsApproveViolations(test.diffs); !reflect.DeepEqual(violations, test.safe) {
+			t.Errorf("%s: got %q under Safe, want %q", test.name, violations, test.safe)
+		}
+		config.Cmd.Deploy.AutoApprove.Policy = "Any"
+		if violations := settingsApproveViolations(test.diffs); len(violations) > 0 {
+			t.Errorf("%s: got %q under Any, want none", test.name, violations)
+		}
+	}
+}
+
+func TestNewStackSettingsDiffs(t *testing.T) {
+	enabled, disabled := true, false
+	if diffs := newStackSettingsDiffs(stx.Stack{EnableTerminationProtection: &disabled}); len(diffs) > 0 {
+		t.Errorf("disabled termination protection is the default for a new stack, got %v", diffs)
+	}
+
+	diffs := newStackSettingsDiffs(stx.Stack{
+		EnableTerminationProtection: &enabled,
+		StackPolicy:                 map[string]interface{}{"Statement": []interface{}{}},
+	})
+	want := []settingDiff{
+		{"EnableTerminationProtection", "false", "true"},
+		{"StackPolicy", "-", `{"Statement":[]}`},
+	}
+	if !reflect.DeepEqual(diffs, want) {
+		t.Errorf("got %v, want %v", diffs, want)
+	}
+}
+
+func TestAppliedSettings(t *testing.T) {
+	diffs := []settingDiff{
+		{"EnableTerminationProtection", "false", "true"},
+		{"RollbackConfiguration", "{}", `{"MonitoringTimeInMinutes":5}`},
+		{"StackPolicy", "-", "{}"},
+		{"NotificationARNs", "", "a"},
+	}
+	want := []settingDiff{diffs[0], diffs[2]}
+	if applied := appliedSettings(diffs); !reflect.DeepEqual(applied, want) {
+		t.Errorf("got %v, want %v", applied, want)
+	}
+}
@@ -0,0 +1,586 @@
+package cmd
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/TangoGroup/stx/logger"
+	"github.com/TangoGroup/stx/stx"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/cloudformation"
+	"github.com/ghodss/yaml"
+	"github.com/olekukonko/tablewriter"
+)
+
+// stackSetDeployment holds the operations deploy runs on a stack set once they are approved
+type stackSetDeployment struct {
+	create    *cloudformation.CreateStackSetInput
+	update    *cloudformation.UpdateStackSetInput
+	instances []*cloudformation.CreateStackInstancesInput
+}
+
+// stackSetInstance is a target account or organizational unit in a region
+type stackSetInstance struct {
+	target, region string
+}
+
+// stackSetTargets returns the declared instances of the stack set. SERVICE_MANAGED stack sets target organizational units, others target accounts.
+func stackSetTargets(stackSet *stx.StackSet) []stackSetInstance {
+	targets := stackSet.Accounts
+	if serviceManaged(stackSet) {
+		targets = stackSet.OrganizationalUnitIds
+	}
+	var instances []stackSetInstance
+	for _, target := range targets {
+		for _, region := range stackSet.Regions {
+			instances = append(instances, stackSetInstance{target: target, region: region})
+		}
+	}
+	return instances
+}
+
+// serviceManaged returns true if StackSets creates the roles it needs through AWS Organizations
+func serviceManaged(stackSet *stx.StackSet) bool {
+	return stackSet.PermissionModel == cloudformation.PermissionModelsServiceManaged
+}
+
+// describeStackSet returns the stack set, or nil if it does not exist
+func describeStackSet(ctx context.Context, cfn *cloudformation.CloudFormation, name string) (*cloudformation.StackSet, error) {
+	describeOutput, describeErr := cfn.DescribeStackSetWithContext(ctx, &cloudformation.DescribeStackSetInput{StackSetName: aws.String(name)})
+	if awsErr, ok := describeErr.(awserr.Error); ok && awsErr.Code() == cloudformation.ErrCodeStackSetNotFoundException {
+		return nil, nil
+	}
+	if describeErr != nil {
+		return nil, describeErr
+	}
+	return describeOutput.StackSet, nil
+}
+
+// listStackInstances returns every instance of the stack set
+func listStackInstances(ctx context.Context, cfn *cloudformation.CloudFormation, name string) ([]*cloudformation.StackInstanceSummary, error) {
+	var summaries []*cloudformation.StackInstanceSummary
+	listInput := cloudformation.ListStackInstancesInput{StackSetName: aws.String(name)}
+	for {
+		page, listErr := cfn.ListStackInstancesWithContext(ctx, &listInput)
+		if listErr != nil {
+			return nil, listErr
+		}
+		summaries = append(summaries, page.Summaries...)
+		if page.NextToken == nil {
+			break
+		}
+		listInput.NextToken = page.NextToken
+	}
+	return summaries, nil
+}
+
+// instanceKey returns the declared instance a live stack instance belongs to
+func instanceKey(stackSet *stx.StackSet, summary *cloudformation.StackInstanceSummary) stackSetInstance {
+	target := aws.StringValue(summary.Account)
+	if serviceManaged(stackSet) {
+		target = aws.StringValue(summary.OrganizationalUnitId)
+	}
+	return stackSetInstance{target: target, region: aws.StringValue(summary.Region)}
+}
+
+// missingInstances returns the declared instances that do not exist yet
+func missingInstances(stackSet *stx.StackSet, summaries []*cloudformation.StackInstanceSummary) []stackSetInstance {
+	existing := make(map[stackSetInstance]bool)
+	for _, summary := range summaries {
+		existing[instanceKey(stackSet, summary)] = true
+	}
+	var missing []stackSetInstance
+	for _, instance := range stackSetTargets(stackSet) {
+		if !existing[instance] {
+			missing = append(missing, instance)
+		}
+	}
+	return missing
+}
+
+// renderStackInstances writes a table of every live instance and every declared instance that does not exist yet
+func renderStackInstances(w io.Writer, stackSet *stx.StackSet, summaries []*cloudformation.StackInstanceSummary, missing []stackSetInstance) {
+	declared := make(map[stackSetInstance]bool)
+	for _, instance := range stackSetTargets(stackSet) {
+		declared[instance] = true
+	}
+
+	table := tablewriter.NewWriter(w)
+	table.SetAutoWrapText(false)
+	table.SetHeader([]string{"Account", "OU", "Region", "Status", "Drift", "Reason"})
+	table.SetHeaderColor(tablewriter.Colors{tablewriter.FgWhiteColor}, tablewriter.Colors{tablewriter.FgWhiteColor}, tablewriter.Colors{tablewriter.FgWhiteColor}, tablewriter.Colors{tablewriter.FgWhiteColor}, tablewriter.Colors{tablewriter.FgWhiteColor}, tablewriter.Colors{tablewriter.FgWhiteColor})
+
+	for _, summary := range summaries {
+		status := aws.StringValue(summary.Status)
+		switch status {
+		case cloudformation.StackInstanceStatusCurrent:
+			status = au.BrightGreen(status).String()
+		case cloudformation.StackInstanceStatusOutdated:
+			status = au.Yellow(status).String()
+		case cloudformation.StackInstanceStatusInoperable:
+			status = au.Red(status).String()
+		}
+		reason := aws.StringValue(summary.StatusReason)
+		if !declared[instanceKey(stackSet, summary)] {
+			reason = au.Yellow("not declared; left as is").String()
+		}
+		table.Append([]string{aws.StringValue(summary.Account), aws.StringValue(summary.OrganizationalUnitId), aws.StringValue(summary.Region), status, aws.StringValue(summary.DriftStatus), reason})
+	}
+	for _, instance := range missing {
+		account, ou := instance.target, ""
+		if serviceManaged(stackSet) {
+			account, ou = "", instance.target
+		}
+		table.Append([]string{account, ou, instance.region, au.Cyan("Create").String(), "", ""})
+	}
+	if table.NumLines() > 0 {
+		table.Render()
+	}
+}
+
+// createStackInstancesInputs groups missing instances into as few CreateStackInstances calls as possible.
+// Each call creates every target in every region it names, so targets are grouped by the regions they are missing.
+func createStackInstancesInputs(stack stx.Stack, missing []stackSetInstance) []*cloudformation.CreateStackInstancesInput {
+	regionsByTarget := make(map[string][]string)
+	var targets []string
+	for _, instance := range missing {
+		if _, ok := regionsByTarget[instance.target]; !ok {
+			targets = append(targets, instance.target)
+		}
+		regionsByTarget[instance.target] = append(regionsByTarget[instance.target], instance.region)
+	}
+
+	var inputs []*cloudformation.CreateStackInstancesInput
+	inputsByRegions := make(map[string]*cloudformation.CreateStackInstancesInput)
+	for _, target := range targets {
+		regions := regionsByTarget[target]
+		sort.Strings(regions)
+		key := strings.Join(regions, ",")
+		input, ok := inputsByRegions[key]
+		if !ok {
+			input = &cloudformation.CreateStackInstancesInput{
+				StackSetName:         aws.String(stack.Name),
+				Regions:              aws.StringSlice(regions),
+				OperationPreferences: operationPreferences(stack.StackSet.OperationPreferences),
+			}
+			if serviceManaged(stack.StackSet) {
+				input.SetDeploymentTargets(&cloudformation.DeploymentTargets{})
+			}
+			inputsByRegions[key] = input
+			inputs = append(inputs, input)
+		}
+		if serviceManaged(stack.StackSet) {
+			input.DeploymentTargets.OrganizationalUnitIds = append(input.DeploymentTargets.OrganizationalUnitIds, aws.String(target))
+		} else {
+			input.Accounts = append(input.Accounts, aws.String(target))
+		}
+	}
+	return inputs
+}
+
+// operationPreferences converts the declared preferences, leaving out zero values so that CloudFormation applies its defaults
+func operationPreferences(declared *stx.StackSetOperationPreferences) *cloudformation.StackSetOperationPreferences {
+	if declared == nil {
+		return nil
+	}
+	preferences := &cloudformation.StackSetOperationPreferences{}
+	if declared.FailureToleranceCount > 0 {
+		preferences.SetFailureToleranceCount(declared.FailureToleranceCount)
+	}
+	if declared.FailureTolerancePercentage > 0 {
+		preferences.SetFailureTolerancePercentage(declared.FailureTolerancePercentage)
+	}
+	if declared.MaxConcurrentCount > 0 {
+		preferences.SetMaxConcurrentCount(declared.MaxConcurrentCount)
+	}
+	if declared.MaxConcurrentPercentage > 0 {
+		preferences.SetMaxConcurrentPercentage(declared.MaxConcurrentPercentage)
+	}
+	if len(declared.RegionOrder) > 0 {
+		preferences.SetRegionOrder(aws.StringSlice(declared.RegionOrder))
+	}
+	return preferences
+}
+
+// autoDeployment converts the declared auto deployment settings
+func autoDeployment(declared *stx.StackSetAutoDeployment) *cloudformation.AutoDeployment {
+	if declared == nil {
+		return nil
+	}
+	return &cloudformation.AutoDeployment{
+		Enabled:                      aws.Bool(declared.Enabled),
+		RetainStacksOnAccountRemoval: aws.Bool(declared.RetainStacksOnAccountRemoval),
+	}
+}
+
+// templateResources returns the logical IDs of the resources in a yaml or json template
+func templateResources(templateBody string) map[string]bool {
+	var template struct {
+		Resources map[string]interface{}
+	}
+	yaml.Unmarshal([]byte(templateBody), &template)
+	resources := make(map[string]bool)
+	for logicalID := range template.Resources {
+		resources[logicalID] = true
+	}
+	return resources
+}
+
+// prepareStackSet compares the exported stack set with the live one, renders the differences and the instances to create,
+// and returns what to execute. It returns nil if there is nothing to execute.
+func prepareStackSet(ctx context.Context, dplArgs deployArgs, stackLog *logger.Logger, cfn *cloudformation.CloudFormation, templateBody, templateURL string, capabilities []*string, parameters []*cloudformation.Parameter) *stackDeployment {
+	stack := dplArgs.stack
+	if flags.DeployPlanOut != "" || flags.DeployImport {
+		stackLog.Warnf("%s %s\n", au.Magenta(stack.Name), "is a stack set; --plan-out and --import only apply to stacks.")
+		recordResult(stack, outcomeSkipped, "stack set")
+		return nil
+	}
+
+	stackLog.Infof("%s %s %s %s:%s\n", au.White("Describing stack set"), au.White("⤎"), au.Magenta(stack.Name), au.Green(stack.Profile), au.Cyan(stack.Region))
+	existing, describeErr := describeStackSet(ctx, cfn, stack.Name)
+	if describeErr != nil {
+		return deployFailed(stackLog, stack, describeErr)
+	}
+
+	stackSet := stack.StackSet
+	tags := stackTags(stack, dplArgs.buildInstance)
+	operation := &stackSetDeployment{}
+	var summaries []*cloudformation.StackInstanceSummary
+	if existing == nil {
+		operation.create = &cloudformation.CreateStackSetInput{
+			StackSetName:   aws.String(stack.Name),
+			Capabilities:   capabilities,
+			Parameters:     parameters,
+			Tags:           tags,
+			AutoDeployment: autoDeployment(stackSet.AutoDeployment),
+		}
+		if templateURL != "" {
+			operation.create.SetTemplateURL(templateURL)
+		} else {
+			operation.create.SetTemplateBody(templateBody)
+		}
+		if stackSet.PermissionModel != "" {
+			operation.create.SetPermissionModel(stackSet.PermissionModel)
+		}
+		if stackSet.AdministrationRoleARN != "" {
+			operation.create.SetAdministrationRoleARN(stackSet.AdministrationRoleARN)
+		}
+		if stackSet.ExecutionRoleName != "" {
+			operation.create.SetExecutionRoleName(stackSet.ExecutionRoleName)
+		}
+		stackLog.Infof("%s %s\n", au.Magenta(stack.Name), au.Cyan("does not exist and will be created."))
+	} else {
+		var listErr error
+		summaries, listErr = listStackInstances(ctx, cfn, stack.Name)
+		if listErr != nil {
+			return deployFailed(stackLog, stack, listErr)
+		}
+
+		diffTemplates(stackLog, stack.Name, aws.StringValue(existing.TemplateBody), templateBody)
+
+		// instances left OUTDATED by a failed operation are retried by updating them again
+		outdated := false
+		for _, summary := range summaries {
+			if aws.StringValue(summary.Status) == cloudformation.StackInstanceStatusOutdated {
+				outdated = true
+			}
+		}
+		existingTags := existing.Tags
+		if tags == nil {
+			existingTags = nil
+		}
+		templateParameters, templateParametersErr := stx.GetTemplateParameters(dplArgs.stackValue)
+		if templateParametersErr != nil {
+			return deployFailed(stackLog, stack, templateParametersErr)
+		}
+		changed := aws.StringValue(existing.TemplateBody) != templateBody ||
+			!sameParameters(templateParameters, existing.Parameters, parameters) ||
+			!reflect.DeepEqual(tagValues(existingTags), tagValues(tags)) ||
+			(stackSet.AutoDeployment != nil && !reflect.DeepEqual(autoDeployment(stackSet.AutoDeployment), existing.AutoDeployment))
+		if changed || outdated {
+			operation.update = &cloudformation.UpdateStackSetInput{
+				StackSetName:         aws.String(stack.Name),
+				Capabilities:         capabilities,
+				Parameters:           parameters,
+				Tags:                 tags,
+				AutoDeployment:       autoDeployment(stackSet.AutoDeployment),
+				OperationPreferences: operationPreferences(stackSet.OperationPreferences),
+			}
+			if templateURL != "" {
+				operation.update.SetTemplateURL(templateURL)
+			} else {
+				operation.update.SetTemplateBody(templateBody)
+			}
+			if stackSet.AdministrationRoleARN != "" {
+				operation.update.SetAdministrationRoleARN(stackSet.AdministrationRoleARN)
+			}
+			if stackSet.ExecutionRoleName != "" {
+				operation.update.SetExecutionRoleName(stackSet.ExecutionRoleName)
+			}
+			if !changed {
+				stackLog.Infof("%s\n", au.Yellow("Outdated instances will be updated again."))
+			}
+		}
+	}
+
+	missing := missingInstances(stackSet, summaries)
+	operation.instances = createStackInstancesInputs(stack, missing)
+
+	// render into a buffer so the table is written as a single block
+	var tableBuf bytes.Buffer
+	renderStackInstances(&tableBuf, stackSet, summaries, missing)
+	stackLog.Stdout().Write(tableBuf.Bytes())
+
+	if operation.create == nil && operation.update == nil && len(operation.instances) < 1 {
+		stackLog.Info(au.Yellow("No changes to deploy."))
+		recordResult(stack, outcomeNoChanges, "")
+		return nil
+	}
+
+	d := &stackDeployment{
+		ctx:           ctx,
+		deployArgs:    dplArgs,
+		log:           stackLog,
+		cfn:           cfn,
+		changeSetName: "stack set operations",
+		templateBody:  templateBody,
+		stackSet:      operation,
+	}
+
+	// a removed resource is deleted from every instance, which the Safe policy refuses
+	if existing != nil && operation.update != nil {
+		live := templateResources(aws.StringValue(existing.TemplateBody))
+		declared := templateResources(templateBody)
+		for logicalID := range live {
+			if !declared[logicalID] {
+				d.changes = append(d.changes, &cloudformation.Change{ResourceChange: &cloudformation.ResourceChange{
+					Action:            aws.String(cloudformation.ChangeActionRemove),
+					LogicalResourceId: aws.String(logicalID),
+				}})
+			}
+		}
+	}
+	return d
+}
+
+// stackSetApproveViolations explains why the Safe policy refuses stack set operations.
+// Without change sets, updates cannot be previewed, so only creating the stack set and its instances is safe.
+func (d *stackDeployment) stackSetApproveViolations() []string {
+	violations := autoApproveViolations(d.changes)
+	if config.Cmd.Deploy.AutoApprove.Policy != "Any" && d.stackSet.update != nil {
+		violations = append(violations, "stack set updates cannot be previewed for replacements")
+	}
+	return violations
+}
+
+// executeStackSet runs the stack set operations in order, waiting for each to finish before starting the next
+func (d *stackDeployment) executeStackSet() {
+	stack := d.stack
+	wait := flags.DeploySave || flags.DeployWait
+
+	var operationIDs []string
+	if d.stackSet.create != nil {
+		d.log.Infof("%s %s %s:%s\n", au.White("Creating stack set"), au.Magenta(stack.Name), au.Green(stack.Profile), au.Cyan(stack.Region))
+		if _, createErr := d.cfn.CreateStackSetWithContext(d.ctx, d.stackSet.create); createErr != nil {
+			deployFailed(d.log, stack, createErr)
+			return
+		}
+	}
+
+	steps := len(d.stackSet.instances)
+	if d.stackSet.update != nil {
+		steps++
+	}
+	for step := 0; step < steps; step++ {
+		var operationID string
+		if d.stackSet.update != nil && step == 0 {
+			d.log.Infof("%s %s %s:%s\n", au.White("Updating stack set"), au.Magenta(stack.Name), au.Green(stack.Profile), au.Cyan(stack.Region))
+			updateOutput, updateErr := d.cfn.UpdateStackSetWithContext(d.ctx, d.stackSet.update)
+			if updateErr != nil {
+				deployFailed(d.log, stack, updateErr)
+				return
+			}
+			operationID = aws.StringValue(updateOutput.OperationId)
+		} else {
+			input := d.stackSet.instances[step-(steps-len(d.stackSet.instances))]
+			targets := input.Accounts
+			if input.DeploymentTargets != nil {
+				targets = input.DeploymentTargets.OrganizationalUnitIds
+			}
+			d.log.Infof("%s %s %s %s\n", au.White("Creating stack instances"), strings.Join(aws.StringValueSlice(targets), ", "), au.White("⤏"), au.Cyan(strings.Join(aws.StringValueSlice(input.Regions), ", ")))
+			createOutput, createErr := d.cfn.CreateStackInstancesWithContext(d.ctx, input)
+			if createErr != nil {
+				deployFailed(d.log, stack, createErr)
+				return
+			}
+			operationID = aws.StringValue(createOutput.OperationId)
+		}
+		operationIDs = append(operationIDs, operationID)
+
+		// a stack set runs one operation at a time, so only the last may be left running
+		if step == steps-1 && !wait {
+			recordResult(stack, outcomeExecuted, "not waited for")
+			return
+		}
+		status, waitErr := waitForStackSetOperation(d.ctx, d.log, d.cfn, stack.Name, operationID)
+		if d.ctx.Err() != nil {
+			d.log.Infof("%s %s %s\n", au.Magenta(stack.Name), au.Yellow("operation "+operationID), au.Gray(11, "continues in CloudFormation."))
+			return
+		}
+		if waitErr != nil {
+			deployFailed(d.log, stack, waitErr)
+			return
+		}
+		if status != cloudformation.StackSetOperationStatusSucceeded {
+			deployFailed(d.log, stack, fmt.Errorf("stack set operation %s %s", operationID, status))
+			return
+		}
+	}
+
+	if flags.DeploySave {
+		d.log.Warn("Stack sets have no outputs to save.")
+	}
+	recordResult(stack, outcomeSucceeded, strings.Join(operationIDs, ", "))
+}
+
+// waitForStackSetOperation polls the operation until it finishes, then renders the result of each instance
+func waitForStackSetOperation(ctx context.Context, stackLog *logger.Logger, cfn *cloudformation.CloudFormation, stackSetName, operationID string) (string, error) {
+	stackLog.Infof("%s %s\n", au.Gray(11, "  Waiting for operation"), au.Gray(11, operationID))
+	describeInput := cloudformation.DescribeStackSetOperationInput{StackSetName: aws.String(stackSetName), OperationId: aws.String(operationID)}
+	var status string
+	for {
+		describeOutput, describeErr := cfn.DescribeStackSetOperationWithContext(ctx, &describeInput)
+		if describeErr != nil {
+			return "", describeErr
+		}
+		if current := aws.StringValue(describeOutput.StackSetOperation.Status); current != status {
+			status = current
+			stackLog.Infof("  %s %s\n", au.Gray(11, time.Now().Format("15:04:05")), operationStatus(status))
+		}
+		if status != cloudformation.StackSetOperationStatusRunning && status != cloudformation.StackSetOperationStatusStopping && status != "QUEUED" {
+			break
+		}
+		select {
+		case <-ctx.Done():
+			return status, ctx.Err()
+		case <-time.After(5 * time.Second):
+		}
+	}
+
+	var results []*cloudformation.StackSetOperationResultSummary
+	resultsInput := cloudformation.ListStackSetOperationResultsInput{StackSetName: aws.String(stackSetName), OperationId: aws.String(operationID)}
+	for {
+		page, resultsErr := cfn.ListStackSetOperationResultsWithContext(ctx, &resultsInput)
+		if resultsErr != nil {
+			return status, resultsErr
+		}
+		results = append(results, page.Summaries...)
+		if page.NextToken == nil {
+			break
+		}
+		resultsInput.NextToken = page.NextToken
+	}
+
+	var tableBuf bytes.Buffer
+	table := tablewriter.NewWriter(&tableBuf)
+	table.SetAutoWrapText(false)
+	table.SetHeader([]string{"Account", "OU", "Region", "Status", "Reason"})
+	table.SetHeaderColor(tablewriter.Colors{tablewriter.FgWhiteColor}, tablewriter.Colors{tablewriter.FgWhiteColor}, tablewriter.Colors{tablewriter.FgWhiteColor}, tablewriter.Colors{tablewriter.FgWhiteColor}, tablewriter.Colors{tablewriter.FgWhiteColor})
+	for _, result := range results {
+		reason := aws.StringValue(result.StatusReason)
+		if aws.StringValue(result.Status) == cloudformation.StackSetOperationResultStatusFailed {
+			reason = au.Red(reason).String()
+		}
+		table.Append([]string{aws.StringValue(result.Account), aws.StringValue(result.OrganizationalUnitId), aws.StringValue(result.Region), operationStatus(aws.StringValue(result.Status)), reason})
+	}
+	if table.NumLines() > 0 {
+		table.Render()
+		stackLog.Stdout().Write(tableBuf.Bytes())
+	}
+	return status, nil
+}
+
+// operationStatus colours the status of a stack set operation or of one of its instances
+func operationStatus(status string) string {
+	switch status {
+	case cloudformation.StackSetOperationStatusSucceeded:
+		return au.BrightGreen(status).String()
+	case cloudformation.StackSetOperationStatusFailed, cloudformation.StackSetOperationStatusStopped, cloudformation.StackSetOperationResultStatusCancelled:
+		return au.Red(status).String()
+	}
+	return au.Yellow(status).String()
+}
+
+// diffStackSet renders the differences between the exported template and the live stack set, and the instances deploy would create
+func diffStackSet(stackLog *logger.Logger, cfn *cloudformation.CloudFormation, stack stx.Stack, templateBody string) {
+	existing, describeErr := describeStackSet(context.Background(), cfn, stack.Name)
+	if describeErr != nil {
+		stackLog.Error(describeErr)
+		return
+	}
+	if existing == nil {
+		stackLog.Errorf("Stack set %s does not exist\n", stack.Name)
+		return
+	}
+	diffTemplates(stackLog, stack.Name, aws.StringValue(existing.TemplateBody), templateBody)
+
+	summaries, listErr := listStackInstances(context.Background(), cfn, stack.Name)
+	if listErr != nil {
+		stackLog.Error(listErr)
+		return
+	}
+	renderStackInstances(stackLog.Stdout(), stack.StackSet, summaries, missingInstances(stack.StackSet, summaries))
+}
+
+// printStackSetStatus renders the status of the stack set and of each of its instances
+func printStackSetStatus(cfn *cloudformation.CloudFormation, stack stx.Stack) {
+	existing, describeErr := describeStackSet(context.Background(), cfn, stack.Name)
+	if describeErr != nil {
+		log.Error(describeErr)
+		return
+	}
+	if existing == nil {
+		log.Errorf("Stack set %s does not exist\n", stack.Name)
+		return
+	}
+	log.Infof("%s %s %s\n", au.Magenta(stack.Name), au.White("stack set"), au.BrightGreen(aws.StringValue(existing.Status)))
+
+	summaries, listErr := listStackInstances(context.Background(), cfn, stack.Name)
+	if listErr != nil {
+		log.Error(listErr)
+		return
+	}
+	renderStackInstances(log.Stdout(), stack.StackSet, summaries, missingInstances(stack.StackSet, summaries))
+}
+
+// printStackSetOperations renders the latest operations of the stack set, newest first
+func printStackSetOperations(cfn *cloudformation.CloudFormation, stack stx.Stack, number int) {
+	listOutput, listErr := cfn.ListStackSetOperations(&cloudformation.ListStackSetOperationsInput{StackSetName: aws.String(stack.Name)})
+	if listErr != nil {
+		log.Error(listErr)
+		return
+	}
+
+	table := tablewriter.NewWriter(log.Stdout())
+	table.SetAutoWrapText(false)
+	table.SetHeader([]string{"Operation", "Action", "Status", "Created", "Ended"})
+	table.SetHeaderColor(tablewriter.Colors{tablewriter.FgWhiteColor}, tablewriter.Colors{tablewriter.FgWhiteColor}, tablewriter.Colors{tablewriter.FgWhiteColor}, tablewriter.Colors{tablewriter.FgWhiteColor}, tablewriter.Colors{tablewriter.FgWhiteColor})
+	for i, operation := range listOutput.Summaries {
+		if number >= 0 && i >= number {
+			break
+		}
+		ended := "-"
+		if operation.EndTimestamp != nil {
+			ended = operation.EndTimestamp.Local().String()
+		}
+		table.Append([]string{aws.StringValue(operation.OperationId), aws.StringValue(operation.Action), operationStatus(aws.StringValue(operation.Status)), operation.CreationTimestamp.Local().String(), ended})
+	}
+	table.Render()
+}
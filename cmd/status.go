@@ -20,12 +20,18 @@ var statusCmd = &cobra.Command{
 
 For each stack, status will query CloudFormation and return the current status.
 If the stack does not exist status will return an error.
+
+For stacks that declare a StackSet, status shows the stack set status and a
+table of every instance with its account, organizational unit, region, status
+and drift status. Declared instances that do not exist yet are listed as Create.
 `,
 	Run: func(cmd *cobra.Command, args []string) {
 		//TODO add debug messages
 		log.Debug("status command executing...")
 		defer log.Flush()
-		stx.EnsureVaultSession(config)
+		if sessionErr := stx.EnsureSession(config); sessionErr != nil {
+			log.Fatal(sessionErr)
+		}
 
 		buildInstances := stx.GetBuildInstances(args, config.PackageName)
 
@@ -45,9 +51,18 @@ If the stack does not exist status will return an error.
 					continue
 				}
 
-				session := stx.GetSession(stack.Profile)
+				session, sessionErr := stx.GetStackSession(stack)
+				if sessionErr != nil {
+					log.Error(sessionErr)
+					continue
+				}
 				cfn := cloudformation.New(session, aws.NewConfig().WithRegion(stack.Region))
 
+				if stack.StackSet != nil {
+					printStackSetStatus(cfn, stack)
+					continue
+				}
+
 				// use a struct to pass a string, it's GC'd!
 				log.Debug("Describing", stack.Name)
 				describeStacksInput := cloudformation.DescribeStacksInput{StackName: aws.String(stack.Name)}
@@ -0,0 +1,146 @@
+package cmd
+
+import (
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"cuelang.org/go/cue"
+	"cuelang.org/go/cue/build"
+	"github.com/TangoGroup/stx/stx"
+	"github.com/olekukonko/tablewriter"
+	"github.com/spf13/cobra"
+)
+
+// validateCmd represents the validate command
+var validateCmd = &cobra.Command{
+	Use:   "validate",
+	Short: "Validates exported templates without calling AWS.",
+	Long: `Validate operates on every stack found in the evaluated cue files.
+
+Each stack is exported without packaging, as with export --no-package, and the
+template is checked offline. No credentials are needed, so validate can run in
+CI before anything is deployed. It checks that:
+
+  - every Ref names a parameter, resource, or pseudo parameter
+  - every Fn::GetAtt names a resource
+  - every ${Name} and ${Resource.Attribute} in Fn::Sub is declared
+  - every DependsOn names a resource
+  - every condition in Conditions is used, and every condition used is declared
+  - the template is within CloudFormation's limits: 51,200 bytes (1 MB when
+    Cmd:Deploy:TemplateBucket or the stack's TemplateBucket is set), 500
+    resources, 200 outputs, and 200 parameters
+
+When Cmd:Validate:ResourceSpec is set, the Properties of every resource are
+also checked against that CloudFormation resource specification: required
+properties, unknown property names (with a suggestion for likely typos),
+primitive types, and lists or maps where the specification expects them. The
+path is relative to the cue root, so the specification can be vendored
+alongside the cue files, e.g.:
+
+Cmd: Validate: ResourceSpec: "cue.mod/CloudFormationResourceSpecification.json"
+
+Download it from the CloudFormation resource specification page for your
+region. Each property problem shows the position of the cue that declares it.
+Custom resource types and values computed by intrinsic functions are not
+checked. Deploy runs the same property checks before creating a change set.
+
+Templates that declare a Transform may reference resources the transform
+creates, so their references are not checked.
+
+Problems are listed for each stack, and each invalid stack is counted as an
+error, so the exit code is non-zero if any stack is invalid.
+`,
+	Run: func(cmd *cobra.Command, args []string) {
+		defer log.Flush()
+
+		buildInstances := stx.GetBuildInstances(args, config.PackageName)
+		stx.Process(buildInstances, flags, log, func(buildInstance *build.Instance, cueInstance *cue.Instance) {
+			stacksIterator, stacksIteratorErr := stx.NewStacksIterator(cueInstance, flags, log)
+			if stacksIteratorErr != nil {
+				log.Fatal(stacksIteratorErr)
+			}
+
+			for stacksIterator.Next() {
+				stackValue := stacksIterator.Value()
+				var stack stx.Stack
+				decodeErr := stackValue.Decode(&stack)
+				if decodeErr != nil {
+					log.Error(decodeErr)
+					continue
+				}
+
+				fileName, _, exportErr := exportStack(log, stack, buildInstance, stackValue, nil)
+				if exportErr != nil {
+					log.Error(exportErr)
+					continue
+				}
+				templateBytes, readErr := ioutil.ReadFile(fileName)
+				if readErr != nil {
+					log.Error(readErr)
+					continue
+				}
+
+				maxSize := stx.MaxTemplateBodySize
+				if templateBucket(stack) != "" {
+					maxSize = stx.MaxTemplateURLSize
+				}
+				problems, validateErr := stx.ValidateTemplate(templateBytes, maxSize)
+				if validateErr != nil {
+					log.Errorf("%s %s\n", au.Magenta(stack.Name), validateErr)
+					continue
+				}
+				propertyProblems, specErr := resourceSpecProblems(stackValue)
+				if specErr != nil {
+					log.Error(specErr)
+					continue
+				}
+				problems = append(problems, propertyProblems...)
+				if len(problems) < 1 {
+					log.Infof("%s %s\n", au.Magenta(stack.Name), au.BrightGreen("✓"))
+					continue
+				}
+
+				renderProblems(os.Stdout, problems)
+				log.Errorf("%s has %d problems\n", au.Magenta(stack.Name), len(problems))
+			}
+		})
+	},
+}
+
+// resourceSpecProblems checks the stack's resource properties against Cmd:Validate:ResourceSpec, or returns nothing if it is not set
+func resourceSpecProblems(stackValue cue.Value) ([]stx.TemplateProblem, error) {
+	if config.Cmd.Validate.ResourceSpec == "" {
+		return nil, nil
+	}
+	path := config.Cmd.Validate.ResourceSpec
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(config.CueRoot, path)
+	}
+	spec, specErr := stx.LoadResourceSpec(path)
+	if specErr != nil {
+		return nil, specErr
+	}
+	return spec.CheckResources(stackValue.Lookup("Template"))
+}
+
+// renderProblems writes a table of template problems, with the cue position of each when it is known
+func renderProblems(w io.Writer, problems []stx.TemplateProblem) {
+	table := tablewriter.NewWriter(w)
+	table.SetAutoWrapText(false)
+	table.SetHeader([]string{"Position", "Path", "Problem"})
+	table.SetHeaderColor(tablewriter.Colors{tablewriter.FgWhiteColor}, tablewriter.Colors{tablewriter.FgWhiteColor}, tablewriter.Colors{tablewriter.FgWhiteColor})
+	for _, problem := range problems {
+		position := "-"
+		if problem.Pos.IsValid() {
+			position = cuePosition(problem.Pos)
+		}
+		table.Append([]string{position, problem.Path, au.Red(problem.Message).String()})
+	}
+	table.Render()
+}
+
+func init() {
+	rootCmd.AddCommand(validateCmd)
+}
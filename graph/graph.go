@@ -25,7 +25,9 @@
 package graph
 
 import (
-	"errors"
+	"fmt"
+	"sort"
+	"strings"
 
 	mapset "github.com/deckarep/golang-set"
 )
@@ -43,6 +45,43 @@ type Graph struct {
 	nodes []*node
 }
 
+// CircularDependencyError is returned when the graph cannot be resolved because of a cycle
+type CircularDependencyError struct {
+	// Cycle is the path of the cycle, starting and ending with the same node
+	Cycle []string
+
+	// Blocked is every other unresolved node, each waiting on the cycle directly or indirectly
+	Blocked []string
+}
+
+func (e *CircularDependencyError) Error() string {
+	msg := "Circular dependency found: " + strings.Join(e.Cycle, " -> ")
+	if len(e.Blocked) > 0 {
+		msg += " (also blocked: " + strings.Join(e.Blocked, ", ") + ")"
+	}
+	return msg
+}
+
+// MissingDependencyError is returned when nodes depend on names that are not in the graph
+type MissingDependencyError struct {
+	// Missing maps the name of a node to the dependencies that are not in the graph
+	Missing map[string][]string
+}
+
+func (e *MissingDependencyError) Error() string {
+	var names []string
+	for name := range e.Missing {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var lines []string
+	for _, name := range names {
+		lines = append(lines, fmt.Sprintf("%s -> %s", name, strings.Join(e.Missing[name], ", ")))
+	}
+	return "Missing dependencies:\n  " + strings.Join(lines, "\n  ")
+}
+
 // NewGraph creates a new Graph and returns *Graph
 func NewGraph() *Graph {
 	return &Graph{}
@@ -53,8 +92,125 @@ func (graph *Graph) AddNode(name string, deps ...string) {
 	graph.nodes = append(graph.nodes, &node{name: name, deps: deps})
 }
 
-// Resolve resolves the dependency graph and returns a new *Graph
+// Names returns the names of all nodes in the order they were added
+func (graph *Graph) Names() []string {
+	var names []string
+	for _, node := range graph.nodes {
+		names = append(names, node.name)
+	}
+	return names
+}
+
+// Has returns true if the graph contains a node with the given name
+func (graph *Graph) Has(name string) bool {
+	return graph.node(name) != nil
+}
+
+// Dependencies returns the names the given node depends on directly
+func (graph *Graph) Dependencies(name string) []string {
+	node := graph.node(name)
+	if node == nil {
+		return nil
+	}
+	deps := append([]string{}, node.deps...)
+	sort.Strings(deps)
+	return deps
+}
+
+// Dependents returns the names of the nodes that depend on the given node directly
+func (graph *Graph) Dependents(name string) []string {
+	var dependents []string
+	for _, node := range graph.nodes {
+		for _, dep := range node.deps {
+			if dep == name {
+				dependents = append(dependents, node.name)
+				break
+			}
+		}
+	}
+	sort.Strings(dependents)
+	return dependents
+}
+
+// Ancestors returns the names the given node depends on, directly or transitively
+func (graph *Graph) Ancestors(name string) []string {
+	return graph.walk(name, graph.Dependencies)
+}
+
+// Descendants returns the names of the nodes that depend on the given node, directly or transitively
+func (graph *Graph) Descendants(name string) []string {
+	return graph.walk(name, graph.Dependents)
+}
+
+// Subgraph returns a new *Graph containing only the named nodes and the dependencies among them. Dependencies on names
+// that are not in the graph at all are kept, so the subgraph still shows them as missing rather than hiding the broken edge.
+func (graph *Graph) Subgraph(names ...string) *Graph {
+	include := mapset.NewSet()
+	for _, name := range names {
+		include.Add(name)
+	}
+
+	subgraph := NewGraph()
+	for _, node := range graph.nodes {
+		if !include.Contains(node.name) {
+			continue
+		}
+		var deps []string
+		for _, dep := range node.deps {
+			if include.Contains(dep) || !graph.Has(dep) {
+				deps = append(deps, dep)
+			}
+		}
+		subgraph.AddNode(node.name, deps...)
+	}
+	return subgraph
+}
+
+func (graph *Graph) node(name string) *node {
+	for _, node := range graph.nodes {
+		if node.name == name {
+			return node
+		}
+	}
+	return nil
+}
+
+// walk collects every name reachable from the given node through next, excluding the node itself
+func (graph *Graph) walk(name string, next func(string) []string) []string {
+	seen := mapset.NewSet(name)
+	queue := next(name)
+	var names []string
+	for len(queue) > 0 {
+		current := queue[0]
+		queue = queue[1:]
+		if seen.Contains(current) {
+			continue
+		}
+		seen.Add(current)
+		names = append(names, current)
+		queue = append(queue, next(current)...)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Resolve resolves the dependency graph and returns a flat, ordered list of node names
 func (graph *Graph) Resolve() ([]string, error) {
+	layers, err := graph.Layers()
+	if err != nil {
+		return nil, err
+	}
+
+	var resolved []string
+	for _, layer := range layers {
+		resolved = append(resolved, layer...)
+	}
+	return resolved, nil
+}
+
+// Layers resolves the dependency graph into ordered sets of node names. The nodes within
+// a layer do not depend on one another, and depend only on nodes in earlier layers.
+func (graph *Graph) Layers() ([][]string, error) {
 	// A map containing the node names and the actual node object
 	nodeNames := make(map[string]*node)
 
@@ -72,10 +228,23 @@ func (graph *Graph) Resolve() ([]string, error) {
 		nodeDependencies[node.name] = dependencySet
 	}
 
+	// Every dependency must be a node in the graph, otherwise it would never be resolved
+	missing := make(map[string][]string)
+	for _, node := range graph.nodes {
+		for _, dep := range node.deps {
+			if _, ok := nodeNames[dep]; !ok {
+				missing[node.name] = append(missing[node.name], dep)
+			}
+		}
+	}
+	if len(missing) > 0 {
+		return nil, &MissingDependencyError{Missing: missing}
+	}
+
 	// Iteratively find and remove nodes from the graph which have no dependencies.
 	// If at some point there are still nodes in the graph and we cannot find
 	// nodes without dependencies, that means we have a circular dependency
-	var resolved []string
+	var layers [][]string
 	for len(nodeDependencies) != 0 {
 		// Get all nodes from the graph which have no dependencies
 		readySet := mapset.NewSet()
@@ -87,20 +256,17 @@ func (graph *Graph) Resolve() ([]string, error) {
 
 		// If there aren't any ready nodes, then we have a cicular dependency
 		if readySet.Cardinality() == 0 {
-			// TODO add IsCircular to Graph
-			// var circ []string
-			// for name := range nodeDependencies {
-			// 	circ = append(circ, nodeNames[name].name)
-			// }
-
-			return nil, errors.New("Circular dependency found")
+			return nil, circularDependencyError(nodeDependencies)
 		}
 
-		// Remove the ready nodes and add them to the resolved graph
+		// Remove the ready nodes and add them to the resolved layers
+		var layer []string
 		for name := range readySet.Iter() {
 			delete(nodeDependencies, name.(string))
-			resolved = append(resolved, nodeNames[name.(string)].name)
+			layer = append(layer, nodeNames[name.(string)].name)
 		}
+		sort.Strings(layer)
+		layers = append(layers, layer)
 
 		// Also make sure to remove the ready nodes from the
 		// remaining node dependencies as well
@@ -110,5 +276,47 @@ func (graph *Graph) Resolve() ([]string, error) {
 		}
 	}
 
-	return resolved, nil
+	return layers, nil
+}
+
+// circularDependencyError finds a cycle among the unresolved nodes, each of which has at least one unresolved dependency
+func circularDependencyError(nodeDependencies map[string]mapset.Set) *CircularDependencyError {
+	var names []string
+	for name := range nodeDependencies {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	// walk from the first unresolved node along its dependencies until a node repeats
+	visited := make(map[string]int)
+	var path []string
+	current := names[0]
+	for {
+		if i, ok := visited[current]; ok {
+			path = append(path[i:], current)
+			break
+		}
+		visited[current] = len(path)
+		path = append(path, current)
+
+		var deps []string
+		for dep := range nodeDependencies[current].Iter() {
+			deps = append(deps, dep.(string))
+		}
+		sort.Strings(deps)
+		current = deps[0]
+	}
+
+	inCycle := make(map[string]bool)
+	for _, name := range path {
+		inCycle[name] = true
+	}
+	var blocked []string
+	for _, name := range names {
+		if !inCycle[name] {
+			blocked = append(blocked, name)
+		}
+	}
+
+	return &CircularDependencyError{Cycle: path, Blocked: blocked}
 }
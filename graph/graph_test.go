@@ -0,0 +1,154 @@
+package graph
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestLayers(t *testing.T) {
+	g := NewGraph()
+	g.AddNode("app", "db", "vpc")
+	g.AddNode("db", "vpc")
+	g.AddNode("dns")
+	g.AddNode("vpc")
+	g.AddNode("cdn", "app", "dns")
+
+	layers, err := g.Layers()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := [][]string{{"dns", "vpc"}, {"db"}, {"app"}, {"cdn"}}
+	if !reflect.DeepEqual(layers, want) {
+		t.Errorf("got layers %v, want %v", layers, want)
+	}
+
+	resolved, err := g.Resolve()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"dns", "vpc", "db", "app", "cdn"}; !reflect.DeepEqual(resolved, want) {
+		t.Errorf("got resolved %v, want %v", resolved, want)
+	}
+}
+
+func TestLayersCircularDependency(t *testing.T) {
+	g := NewGraph()
+	g.AddNode("a", "b")
+	g.AddNode("b", "c")
+	g.AddNode("c", "a")
+	g.AddNode("d", "a")
+	g.AddNode("e")
+
+	_, err := g.Layers()
+	circularErr, ok := err.(*CircularDependencyError)
+	if !ok {
+		t.Fatalf("got %v, want a *CircularDependencyError", err)
+	}
+	if want := []string{"a", "b", "c", "a"}; !reflect.DeepEqual(circularErr.Cycle, want) {
+		t.Errorf("got cycle %v, want %v", circularErr.Cycle, want)
+	}
+	if want := []string{"d"}; !reflect.DeepEqual(circularErr.Blocked, want) {
+		t.Errorf("got blocked %v, want %v", circularErr.Blocked, want)
+	}
+	if want := "Circular dependency found: a -> b -> c -> a (also blocked: d)"; err.Error() != want {
+		t.Errorf("got message %q, want %q", err.Error(), want)
+	}
+}
+
+func TestLayersSelfDependency(t *testing.T) {
+	g := NewGraph()
+	g.AddNode("a", "a")
+
+	_, err := g.Layers()
+	circularErr, ok := err.(*CircularDependencyError)
+	if !ok {
+		t.Fatalf("got %v, want a *CircularDependencyError", err)
+	}
+	if want := []string{"a", "a"}; !reflect.DeepEqual(circularErr.Cycle, want) {
+		t.Errorf("got cycle %v, want %v", circularErr.Cycle, want)
+	}
+}
+
+func TestLayersMissingDependency(t *testing.T) {
+	g := NewGraph()
+	g.AddNode("app", "db", "queue")
+	g.AddNode("worker", "queue")
+	g.AddNode("db")
+
+	_, err := g.Layers()
+	missingErr, ok := err.(*MissingDependencyError)
+	if !ok {
+		t.Fatalf("got %v, want a *MissingDependencyError", err)
+	}
+	want := map[string][]string{"app": {"queue"}, "worker": {"queue"}}
+	if !reflect.DeepEqual(missingErr.Missing, want) {
+		t.Errorf("got missing %v, want %v", missingErr.Missing, want)
+	}
+	if want := "Missing dependencies:\n  app -> queue\n  worker -> queue"; err.Error() != want {
+		t.Errorf("got message %q, want %q", err.Error(), want)
+	}
+}
+
+// newStacksGraph returns vpc <- db <- app <- cdn, with dns beside them and app also depending on a queue that is missing
+func newStacksGraph() *Graph {
+	g := NewGraph()
+	g.AddNode("vpc")
+	g.AddNode("db", "vpc")
+	g.AddNode("app", "db", "queue")
+	g.AddNode("cdn", "app", "dns")
+	g.AddNode("dns")
+	return g
+}
+
+func TestAncestorsDescendants(t *testing.T) {
+	g := newStacksGraph()
+
+	tests := []struct {
+		name                   string
+		ancestors, descendants []string
+	}{
+		{"vpc", nil, []string{"app", "cdn", "db"}},
+		{"app", []string{"db", "queue", "vpc"}, []string{"cdn"}},
+		{"cdn", []string{"app", "db", "dns", "queue", "vpc"}, nil},
+		{"dns", nil, []string{"cdn"}},
+	}
+	for _, test := range tests {
+		if ancestors := g.Ancestors(test.name); !reflect.DeepEqual(ancestors, test.ancestors) {
+			t.Errorf("Ancestors(%s): got %v, want %v", test.name, ancestors, test.ancestors)
+		}
+		if descendants := g.Descendants(test.name); !reflect.DeepEqual(descendants, test.descendants) {
+			t.Errorf("Descendants(%s): got %v, want %v", test.name, descendants, test.descendants)
+		}
+	}
+}
+
+func TestSubgraph(t *testing.T) {
+	g := newStacksGraph()
+
+	tests := []struct {
+		names []string
+		want  map[string][]string
+	}{
+		// the dependency on dns is outside the subgraph and dropped, the one on the missing queue is kept
+		{[]string{"app", "cdn"}, map[string][]string{"app": {"queue"}, "cdn": {"app"}}},
+		{append(g.Ancestors("db"), "db"), map[string][]string{"db": {"vpc"}, "vpc": nil}},
+		{append(g.Descendants("db"), "db"), map[string][]string{"app": {"db", "queue"}, "cdn": {"app"}, "db": nil}},
+	}
+	for _, test := range tests {
+		subgraph := g.Subgraph(test.names...)
+		got := make(map[string][]string)
+		for _, name := range subgraph.Names() {
+			got[name] = subgraph.Dependencies(name)
+			if len(got[name]) == 0 {
+				got[name] = nil
+			}
+		}
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("Subgraph(%v): got %v, want %v", test.names, got, test.want)
+		}
+	}
+
+	if _, err := g.Subgraph("app").Layers(); err == nil {
+		t.Error("a subgraph with a missing dependency resolved")
+	}
+}
@@ -2,17 +2,20 @@ package logger
 
 import (
 	"fmt"
+	"io"
 	"os"
 	"sync"
+	"sync/atomic"
 
 	"github.com/logrusorgru/aurora"
 )
 
 // Logger is a sugar coating around log.Logger
 type Logger struct {
-	debug  bool
-	errors int
-	au     aurora.Aurora
+	debug          bool
+	errors         *int32 // shared with loggers derived through WithOutput
+	au             aurora.Aurora
+	stdout, stderr io.Writer
 }
 
 var logger *Logger
@@ -23,13 +26,25 @@ func NewLogger(debug, noColor bool) *Logger {
 	once.Do(func() {
 		logger = &Logger{
 			debug:  debug,
-			errors: 0,
+			errors: new(int32),
 			au:     aurora.NewAurora(!noColor), // flip noColor. --no-color -> noColor=true therefore colors=!noColor=false
+			stdout: os.Stdout,
+			stderr: os.Stderr,
 		}
 	})
 	return logger
 }
 
+// WithOutput returns a *logger.Logger that writes to the given writers and shares its error count with l
+func (l *Logger) WithOutput(stdout, stderr io.Writer) *Logger {
+	return &Logger{debug: l.debug, errors: l.errors, au: l.au, stdout: stdout, stderr: stderr}
+}
+
+// Stdout returns the writer used for regular output, e.g. for rendering tables
+func (l *Logger) Stdout() io.Writer {
+	return l.stdout
+}
+
 // Debug prints to stdout only if --debug is set
 func (l *Logger) Debug(args ...interface{}) {
 	if l.debug {
@@ -48,34 +63,34 @@ func (l *Logger) Debugf(format string, args ...interface{}) {
 
 // Info prints to stdout
 func (l *Logger) Info(args ...interface{}) {
-	fmt.Fprintln(os.Stdout, args...)
+	fmt.Fprintln(l.stdout, args...)
 }
 
 // Infof prints formatted text to stdout
 func (l *Logger) Infof(format string, args ...interface{}) {
-	fmt.Fprintf(os.Stdout, format, args...)
+	fmt.Fprintf(l.stdout, format, args...)
 }
 
 // Warn prints to stderr
 func (l *Logger) Warn(args ...interface{}) {
-	fmt.Fprintln(os.Stdout, l.au.Yellow(fmt.Sprint(args...)))
+	fmt.Fprintln(l.stdout, l.au.Yellow(fmt.Sprint(args...)))
 }
 
 // Warnf prints to stderr
 func (l *Logger) Warnf(format string, args ...interface{}) {
-	fmt.Fprint(os.Stdout, l.au.Yellow(fmt.Sprintf(format, args...)))
+	fmt.Fprint(l.stdout, l.au.Yellow(fmt.Sprintf(format, args...)))
 }
 
 // Error prints to stderr
 func (l *Logger) Error(args ...interface{}) {
-	l.errors++
-	fmt.Fprintln(os.Stderr, l.au.Red(fmt.Sprint(args...)))
+	atomic.AddInt32(l.errors, 1)
+	fmt.Fprintln(l.stderr, l.au.Red(fmt.Sprint(args...)))
 }
 
 // Errorf prints to stderr
 func (l *Logger) Errorf(format string, args ...interface{}) {
-	l.errors++
-	fmt.Fprint(os.Stderr, l.au.Red(fmt.Sprintf(format, args...)))
+	atomic.AddInt32(l.errors, 1)
+	fmt.Fprint(l.stderr, l.au.Red(fmt.Sprintf(format, args...)))
 }
 
 // Fatal prints to stderr and exits 1
@@ -97,15 +112,21 @@ func (l *Logger) Check() {
 
 // Flush will call os.Exit if logger accumulated errors
 func (l *Logger) Flush() {
-	if l.errors > 0 {
-		if l.errors > 125 {
-			l.errors = 125
+	errors := l.NumErrors()
+	if errors > 0 {
+		for _, w := range []io.Writer{l.stdout, l.stderr} {
+			if pw, ok := w.(*PrefixWriter); ok {
+				pw.Flush()
+			}
+		}
+		if errors > 125 {
+			errors = 125
 		}
-		os.Exit(l.errors)
+		os.Exit(errors)
 	}
 }
 
 // NumErrors returns the number of errors counted so far
 func (l *Logger) NumErrors() int {
-	return l.errors
+	return int(atomic.LoadInt32(l.errors))
 }
@@ -0,0 +1,65 @@
+package logger
+
+import (
+	"bytes"
+	"io"
+	"sync"
+)
+
+// writeMu serializes writes from every PrefixWriter so lines from concurrent stacks never interleave
+var writeMu sync.Mutex
+
+// PrefixWriter buffers partial lines and writes each complete line to the underlying writer with a prefix
+type PrefixWriter struct {
+	w      io.Writer
+	prefix []byte
+	mu     sync.Mutex
+	buf    bytes.Buffer
+}
+
+// NewPrefixWriter returns *logger.PrefixWriter
+func NewPrefixWriter(w io.Writer, prefix string) *PrefixWriter {
+	return &PrefixWriter{w: w, prefix: []byte(prefix)}
+}
+
+// Write buffers p and writes out every complete line. All lines contained in p are written together.
+func (pw *PrefixWriter) Write(p []byte) (int, error) {
+	pw.mu.Lock()
+	defer pw.mu.Unlock()
+
+	pw.buf.Write(p)
+	var out bytes.Buffer
+	for {
+		i := bytes.IndexByte(pw.buf.Bytes(), '\n')
+		if i < 0 {
+			break
+		}
+		out.Write(pw.prefix)
+		out.Write(pw.buf.Next(i + 1))
+	}
+
+	if out.Len() > 0 {
+		writeMu.Lock()
+		_, err := pw.w.Write(out.Bytes())
+		writeMu.Unlock()
+		if err != nil {
+			return 0, err
+		}
+	}
+	return len(p), nil
+}
+
+// Flush writes out any buffered partial line
+func (pw *PrefixWriter) Flush() error {
+	pw.mu.Lock()
+	defer pw.mu.Unlock()
+
+	if pw.buf.Len() == 0 {
+		return nil
+	}
+	writeMu.Lock()
+	defer writeMu.Unlock()
+	_, err := pw.w.Write(append(append([]byte{}, pw.prefix...), append(pw.buf.Bytes(), '\n')...))
+	pw.buf.Reset()
+	return err
+}
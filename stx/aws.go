@@ -1,83 +1,178 @@
 package stx
 
 import (
-	"encoding/json"
 	"fmt"
-	"os"
-	"os/exec"
+	"sort"
 	"strings"
-
-	"github.com/logrusorgru/aurora"
+	"sync"
 
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/credentials/stscreds"
 	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/sts"
 )
 
-// EnsureVaultSession is used to prompt for MFA if aws-vault session has expired
-func EnsureVaultSession(config *Config) {
-	au := aurora.NewAurora(true)
-	_, existingVault := os.LookupEnv("AWS_VAULT")
-	if existingVault {
-		fmt.Println(au.Red("Cannot run in nested aws-vault session!"))
-		os.Exit(1)
+// AwsCredentials holds access keys and session token
+type AwsCredentials struct {
+	AccessKeyID                               string `json:"AccessKeyId"`
+	SecretAccessKey, SessionToken, Expiration string
+}
+
+// CredentialProvider resolves AWS credentials for the profiles named by stacks
+type CredentialProvider interface {
+	// EnsureSession is called once before any credentials are requested, e.g. to prompt for MFA
+	EnsureSession() error
+	// Credentials returns credentials for the given profile
+	Credentials(profile string) (AwsCredentials, error)
+}
+
+// credentialProviders maps the names allowed in Auth:Provider to provider constructors
+var credentialProviders = map[string]func(config *Config) CredentialProvider{
+	"AwsVault":     newAwsVaultProvider,
+	"SharedConfig": newSharedConfigProvider,
+	"Sso":          newSsoProvider,
+	"Environment":  newEnvironmentProvider,
+}
+
+// credentialProvider is selected by EnsureSession and defaults to aws-vault
+var credentialProvider CredentialProvider = &awsVaultProvider{}
+
+// ensuredSession records whether EnsureSession has been called, and its result
+var ensuredSession struct {
+	sync.Mutex
+	done bool
+	err  error
+}
+
+// EnsureSession selects the credential provider named by Auth:Provider and prepares it, e.g. by prompting for MFA
+func EnsureSession(config *Config) error {
+	newProvider, ok := credentialProviders[config.Auth.Provider]
+	if !ok {
+		var names []string
+		for name := range credentialProviders {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		return fmt.Errorf("Unknown Auth:Provider %q, expected one of: %s", config.Auth.Provider, strings.Join(names, ", "))
 	}
+	credentialProvider = newProvider(config)
+	configureCache(config)
 
-	sessionsOut, sessionsErr := exec.Command("aws-vault", "list", "--sessions").Output()
-	if sessionsErr != nil {
-		fmt.Println("Could not list aws-vault sessions: " + au.Red(sessionsErr).String())
-		os.Exit(1)
+	ensuredSession.Lock()
+	defer ensuredSession.Unlock()
+	ensuredSession.err = credentialProvider.EnsureSession()
+	ensuredSession.done = true
+	return ensuredSession.err
+}
+
+// EnsureSessionOnce calls EnsureSession unless it has already been called, for commands that only sometimes need AWS
+func EnsureSessionOnce(config *Config) error {
+	ensuredSession.Lock()
+	done, err := ensuredSession.done, ensuredSession.err
+	ensuredSession.Unlock()
+	if done {
+		return err
 	}
-	//fmt.Println(string(sessionsOut))
-	var mfa string
-	if len(sessionsOut) < 1 {
-		if len(config.Auth.Ykman.Profile) > 1 {
-			ykmanOutput, ykmanErr := exec.Command("ykman", "oath", "code", "-s", config.Auth.Ykman.Profile).Output()
-			if ykmanErr != nil {
-				fmt.Println(au.Red(ykmanErr))
-				os.Exit(1)
-			}
-			mfa = strings.TrimSpace(string(ykmanOutput))
-			fmt.Println("Pulled MFA from ykman profile ", config.Auth.Ykman.Profile)
-		} else {
-			fmt.Print("MFA: ")
-			fmt.Scanln(&mfa)
-		}
-		awsVaultExecErr := exec.Command("aws-vault", "exec", "-t", mfa, config.Auth.AwsVault.SourceProfile).Run()
-		if awsVaultExecErr != nil {
-			fmt.Println(au.Red("aws-vault error: " + awsVaultExecErr.Error()))
-			os.Exit(1)
+	return EnsureSession(config)
+}
+
+// GetProfileCredentials returns AwsCredentials for the given profile, cached until they expire
+func GetProfileCredentials(profile string) (AwsCredentials, error) {
+	profileCache.Lock()
+	defer profileCache.Unlock()
+
+	cached, err := cachedCredentials(profile)
+	if err != nil {
+		return AwsCredentials{}, fmt.Errorf("Unable to get credentials for profile %s: %s", profile, err)
+	}
+	return cached.credentials, nil
+}
+
+// GetSession returns aws session with credentials from profile, reused until the credentials expire
+func GetSession(profile string) (*session.Session, error) {
+	profileCache.Lock()
+	defer profileCache.Unlock()
+
+	cached, err := cachedCredentials(profile)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to get credentials for profile %s: %s", profile, err)
+	}
+
+	if cached.session == nil {
+		creds := cached.credentials
+		config := aws.NewConfig().WithCredentials(credentials.NewStaticCredentials(creds.AccessKeyID, creds.SecretAccessKey, creds.SessionToken))
+		sess, sessErr := session.NewSession(config)
+		if sessErr != nil {
+			return nil, sessErr
 		}
+		cached.session = sess
 	}
+	return cached.session, nil
 }
 
-// AwsCredentials holds access keys and session token
-type AwsCredentials struct {
-	AccessKeyID                               string `json:"AccessKeyId"`
-	SecretAccessKey, SessionToken, Expiration string
+// GetStackSession returns a session for the stack's Profile, assuming the stack's AssumeRoleArn if it has one
+func GetStackSession(stack Stack) (*session.Session, error) {
+	sess, sessErr := GetSession(stack.Profile)
+	if sessErr != nil || stack.AssumeRoleArn == "" {
+		return sess, sessErr
+	}
+
+	profileCache.Lock()
+	defer profileCache.Unlock()
+
+	key := stack.Profile + " " + stack.AssumeRoleArn
+	if assumed, ok := profileCache.assumed[key]; ok {
+		return assumed, nil
+	}
+
+	// stscreds refreshes the assumed role credentials as they expire, calling AssumeRole with the profile's current credentials
+	base, baseErr := session.NewSession(aws.NewConfig().WithCredentials(credentials.NewCredentials(&profileCredentialsProvider{profile: stack.Profile})))
+	if baseErr != nil {
+		return nil, baseErr
+	}
+	assumed, assumedErr := session.NewSession(aws.NewConfig().WithCredentials(stscreds.NewCredentials(base, stack.AssumeRoleArn)))
+	if assumedErr != nil {
+		return nil, assumedErr
+	}
+	profileCache.assumed[key] = assumed
+	return assumed, nil
 }
 
-// GetProfileCredentials returns AwsCredentials for the given profile
-func GetProfileCredentials(profile string) AwsCredentials {
-	au := aurora.NewAurora(true)
-	execOut, execErr := exec.Command("aws-vault", "exec", "--json", profile).Output()
+// profileCredentialsProvider retrieves a profile's credentials from the cache, so sessions built on it follow the cache as it refreshes them
+type profileCredentialsProvider struct {
+	profile     string
+	credentials AwsCredentials
+}
 
-	if execErr != nil {
-		fmt.Println(au.Red("Unable to get credentials for profile: "), au.Red(profile))
-		fmt.Println(au.Red(execErr))
-		os.Exit(1)
+// Retrieve returns the profile's cached credentials, fetching them again if they have expired
+func (p *profileCredentialsProvider) Retrieve() (credentials.Value, error) {
+	creds, err := GetProfileCredentials(p.profile)
+	if err != nil {
+		return credentials.Value{}, err
 	}
-	// TODO: cache credentials until expired
-	var credentials AwsCredentials
-	json.Unmarshal(execOut, &credentials)
+	p.credentials = creds
+	return credentials.Value{AccessKeyID: creds.AccessKeyID, SecretAccessKey: creds.SecretAccessKey, SessionToken: creds.SessionToken, ProviderName: "stx"}, nil
+}
 
-	return credentials
+// IsExpired returns true once the retrieved credentials are within the expiryWindow of their expiration
+func (p *profileCredentialsProvider) IsExpired() bool {
+	return p.credentials.expired()
 }
 
-// GetSession returns aws session with credentials from profile
-func GetSession(profile string) *session.Session {
-	creds := GetProfileCredentials(profile)
-	config := aws.NewConfig().WithCredentials(credentials.NewStaticCredentials(creds.AccessKeyID, creds.SecretAccessKey, creds.SessionToken))
-	sess, _ := session.NewSession(config)
-	return sess
+// VerifyAccount returns an error if the stack declares an AccountId that differs from the account of the session's credentials
+func VerifyAccount(sess *session.Session, stack Stack) error {
+	if stack.AccountId == "" {
+		return nil
+	}
+
+	identity, identityErr := sts.New(sess, aws.NewConfig().WithRegion(stack.Region)).GetCallerIdentity(&sts.GetCallerIdentityInput{})
+	if identityErr != nil {
+		return fmt.Errorf("Unable to verify account for stack %s: %s", stack.Name, identityErr)
+	}
+
+	if aws.StringValue(identity.Account) != stack.AccountId {
+		return fmt.Errorf("Refusing to modify stack %s: expected account %s but profile %s resolved to account %s (%s)", stack.Name, stack.AccountId, stack.Profile, aws.StringValue(identity.Account), aws.StringValue(identity.Arn))
+	}
+	return nil
 }
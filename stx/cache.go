@@ -0,0 +1,222 @@
+package stx
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/user"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/session"
+	"golang.org/x/crypto/scrypt"
+)
+
+// credentials are refreshed this long before they expire so that long running calls don't fail midway
+const expiryWindow = 5 * time.Minute
+
+// cachedProfile holds the credentials and session for a profile until the credentials expire
+type cachedProfile struct {
+	credentials AwsCredentials
+	session     *session.Session
+}
+
+// profileCache is an in-process cache of credentials and sessions keyed by profile.
+// When diskCache is enabled, credentials with an expiration are also kept in ~/.stx/credentials.cache, encrypted with a key derived from the passphrase.
+var profileCache = struct {
+	sync.Mutex
+	profiles   map[string]*cachedProfile
+	assumed    map[string]*session.Session // keyed by profile and role arn
+	diskCache  bool
+	provider   string
+	passphrase string
+	salt, key  []byte // the key derived from passphrase and salt, kept so scrypt runs once per process
+}{profiles: make(map[string]*cachedProfile), assumed: make(map[string]*session.Session)}
+
+// CachePassphraseEnv names the environment variable holding the passphrase the disk cache is encrypted with
+const CachePassphraseEnv = "STX_CACHE_PASSPHRASE"
+
+// the disk cache starts with the scrypt salt, followed by the AES-GCM nonce and the sealed entries
+const cacheSaltSize = 16
+
+// expired returns true if the credentials expire within the expiryWindow.
+// Credentials without an expiration are assumed to remain valid for the life of the process.
+func (creds AwsCredentials) expired() bool {
+	if creds.Expiration == "" {
+		return false
+	}
+	expiration, err := time.Parse(time.RFC3339, creds.Expiration)
+	if err != nil {
+		return true
+	}
+	return time.Now().Add(expiryWindow).After(expiration)
+}
+
+// configureCache resets the cache for the selected provider
+func configureCache(config *Config) {
+	profileCache.Lock()
+	defer profileCache.Unlock()
+	profileCache.profiles = make(map[string]*cachedProfile)
+	profileCache.assumed = make(map[string]*session.Session)
+	profileCache.diskCache = config.Auth.Cache.Disk
+	profileCache.provider = config.Auth.Provider
+	profileCache.passphrase = os.Getenv(CachePassphraseEnv)
+	profileCache.salt, profileCache.key = nil, nil
+	if profileCache.diskCache && profileCache.passphrase == "" {
+		fmt.Fprintf(os.Stderr, "Auth: Cache: Disk needs a passphrase in %s; credentials are only cached in memory.\n", CachePassphraseEnv)
+		profileCache.diskCache = false
+	}
+}
+
+// cachedCredentials returns cached credentials for the profile, fetching them from the provider if missing or expired.
+// The cache must be locked by the caller.
+func cachedCredentials(profile string) (*cachedProfile, error) {
+	if cached, ok := profileCache.profiles[profile]; ok && !cached.credentials.expired() {
+		return cached, nil
+	}
+
+	if profileCache.diskCache {
+		if creds, ok := readDiskCache()[profileCache.provider+"/"+profile]; ok && !creds.expired() {
+			cached := &cachedProfile{credentials: creds}
+			profileCache.profiles[profile] = cached
+			return cached, nil
+		}
+	}
+
+	creds, err := credentialProvider.Credentials(profile)
+	if err != nil {
+		return nil, err
+	}
+	cached := &cachedProfile{credentials: creds}
+	profileCache.profiles[profile] = cached
+
+	// only temporary credentials are written to disk
+	if profileCache.diskCache && creds.Expiration != "" {
+		diskCache := readDiskCache()
+		diskCache[profileCache.provider+"/"+profile] = creds
+		if writeErr := writeDiskCache(diskCache); writeErr != nil {
+			fmt.Fprintf(os.Stderr, "Unable to write credentials cache: %s\n", writeErr)
+		}
+	}
+	return cached, nil
+}
+
+// cacheDir returns ~/.stx, creating it if needed
+func cacheDir() (string, error) {
+	usr, usrErr := user.Current()
+	if usrErr != nil {
+		return "", usrErr
+	}
+	dir := filepath.Join(usr.HomeDir, ".stx")
+	return dir, os.MkdirAll(dir, 0700)
+}
+
+// cacheCipher returns an AES-GCM cipher with the key derived from the passphrase and salt. The cache must be locked by the caller.
+func cacheCipher(salt []byte) (cipher.AEAD, error) {
+	if !bytes.Equal(salt, profileCache.salt) || profileCache.key == nil {
+		key, keyErr := scrypt.Key([]byte(profileCache.passphrase), salt, 1<<15, 8, 1, 32)
+		if keyErr != nil {
+			return nil, keyErr
+		}
+		profileCache.salt, profileCache.key = salt, key
+	}
+	block, blockErr := aes.NewCipher(profileCache.key)
+	if blockErr != nil {
+		return nil, blockErr
+	}
+	return cipher.NewGCM(block)
+}
+
+// openDiskCache decrypts the entries of a disk cache
+func openDiskCache(sealed []byte) (map[string]AwsCredentials, error) {
+	if len(sealed) < cacheSaltSize {
+		return nil, fmt.Errorf("credentials cache is truncated")
+	}
+	gcm, gcmErr := cacheCipher(sealed[:cacheSaltSize])
+	if gcmErr != nil {
+		return nil, gcmErr
+	}
+	sealed = sealed[cacheSaltSize:]
+	if len(sealed) < gcm.NonceSize() {
+		return nil, fmt.Errorf("credentials cache is truncated")
+	}
+	plain, openErr := gcm.Open(nil, sealed[:gcm.NonceSize()], sealed[gcm.NonceSize():], nil)
+	if openErr != nil {
+		return nil, openErr
+	}
+	entries := make(map[string]AwsCredentials)
+	return entries, json.Unmarshal(plain, &entries)
+}
+
+// sealDiskCache encrypts the entries, reusing the salt of the cache that was read so the key is not derived again
+func sealDiskCache(entries map[string]AwsCredentials) ([]byte, error) {
+	salt := profileCache.salt
+	if salt == nil {
+		salt = make([]byte, cacheSaltSize)
+		if _, randErr := io.ReadFull(rand.Reader, salt); randErr != nil {
+			return nil, randErr
+		}
+	}
+	gcm, gcmErr := cacheCipher(salt)
+	if gcmErr != nil {
+		return nil, gcmErr
+	}
+	plain, marshalErr := json.Marshal(entries)
+	if marshalErr != nil {
+		return nil, marshalErr
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, randErr := io.ReadFull(rand.Reader, nonce); randErr != nil {
+		return nil, randErr
+	}
+	return gcm.Seal(append(append([]byte{}, salt...), nonce...), nonce, plain, nil), nil
+}
+
+// readDiskCache decrypts ~/.stx/credentials.cache. Any problem reading the cache, such as a different passphrase, results in an empty cache.
+func readDiskCache() map[string]AwsCredentials {
+	dir, dirErr := cacheDir()
+	if dirErr != nil {
+		return make(map[string]AwsCredentials)
+	}
+	sealed, readErr := ioutil.ReadFile(filepath.Join(dir, "credentials.cache"))
+	if readErr != nil {
+		return make(map[string]AwsCredentials)
+	}
+	entries, openErr := openDiskCache(sealed)
+	if openErr != nil {
+		return make(map[string]AwsCredentials)
+	}
+
+	// drop anything that has expired so it is not written back
+	for key, creds := range entries {
+		if creds.expired() {
+			delete(entries, key)
+		}
+	}
+	return entries
+}
+
+// writeDiskCache encrypts the entries to ~/.stx/credentials.cache, readable only by the current user.
+func writeDiskCache(entries map[string]AwsCredentials) error {
+	dir, dirErr := cacheDir()
+	if dirErr != nil {
+		return dirErr
+	}
+	sealed, sealErr := sealDiskCache(entries)
+	if sealErr != nil {
+		return sealErr
+	}
+	fileName := filepath.Join(dir, "credentials.cache")
+	if writeErr := ioutil.WriteFile(fileName, sealed, 0600); writeErr != nil {
+		return writeErr
+	}
+	// WriteFile keeps the mode of an existing file
+	return os.Chmod(fileName, 0600)
+}
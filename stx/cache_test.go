@@ -0,0 +1,60 @@
+package stx
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+)
+
+// withPassphrase sets the passphrase of the disk cache, returning a func that restores the previous one
+func withPassphrase(passphrase string) func() {
+	original, salt, key := profileCache.passphrase, profileCache.salt, profileCache.key
+	profileCache.passphrase, profileCache.salt, profileCache.key = passphrase, nil, nil
+	return func() {
+		profileCache.passphrase, profileCache.salt, profileCache.key = original, salt, key
+	}
+}
+
+func TestDiskCacheEncryption(t *testing.T) {
+	restore := withPassphrase("correct horse")
+	defer restore()
+
+	entries := map[string]AwsCredentials{"AwsVault/dev": {
+		AccessKeyID:     "ASIAEXAMPLE",
+		SecretAccessKey: "secret-access-key",
+		SessionToken:    "session-token",
+		Expiration:      "2099-01-01T00:00:00Z",
+	}}
+	sealed, sealErr := sealDiskCache(entries)
+	if sealErr != nil {
+		t.Fatal(sealErr)
+	}
+	for _, secret := range []string{"ASIAEXAMPLE", "secret-access-key", "session-token"} {
+		if bytes.Contains(sealed, []byte(secret)) {
+			t.Errorf("sealed cache contains %s in plain text", secret)
+		}
+	}
+
+	profileCache.salt, profileCache.key = nil, nil
+	opened, openErr := openDiskCache(sealed)
+	if openErr != nil {
+		t.Fatal(openErr)
+	}
+	if !reflect.DeepEqual(opened, entries) {
+		t.Errorf("got %v, want %v", opened, entries)
+	}
+
+	tampered := append([]byte{}, sealed...)
+	tampered[len(tampered)-1] ^= 1
+	if _, err := openDiskCache(tampered); err == nil {
+		t.Error("opened a tampered cache")
+	}
+	if _, err := openDiskCache(sealed[:cacheSaltSize]); err == nil {
+		t.Error("opened a truncated cache")
+	}
+
+	profileCache.passphrase, profileCache.salt, profileCache.key = "wrong horse", nil, nil
+	if _, err := openDiskCache(sealed); err == nil {
+		t.Error("opened the cache with a different passphrase")
+	}
+}
@@ -6,6 +6,7 @@ import (
 	"os/user"
 	"path/filepath"
 	"strings"
+	"time"
 
 	"cuelang.org/go/cue"
 	"cuelang.org/go/cue/build"
@@ -17,17 +18,36 @@ type Flags struct {
 	Environment, Profile, RegionCode, Exclude, Include, StackNameRegexPattern, Has, PrintPath, ImportStack, ImportRegion string
 	Debug, NoColor                                                                                                       bool
 	PrintOnlyErrors, PrintHideErrors, PrintOnlyNames, PrintHidePath, PrintOnlyPaths                                      bool
-	DeployWait, DeploySave, DeployDeps, DeployPrevious                                                                   bool
+	DeployWait, DeploySave, DeployDeps, DeployPrevious, DeployYes, DeployImport                                          bool
+	DeployParallel                                                                                                       int
+	GraphFormat, GraphDependenciesOf, GraphDependentsOf                                                                  string
+	DeploySaveOverrides, DeploySopsKmsArn, DeployPlanOut, DeployImportFile                                               string
+	LintFormat                                                                                                           string
+	ExportNoPackage, ChangeSetsMine                                                                                      bool
+	ChangeSetsOlderThan                                                                                                  time.Duration
 }
 
 const configCue = `package stx
 Auth: {
+	Provider: *"AwsVault" | "SharedConfig" | "Sso" | "Environment"
+	Cache: Disk: bool | *false
 	AwsVault: SourceProfile: string | *""
 	Ykman: Profile: string | *""
 }
 Cmd: {
 	Export: YmlPath: string | *"./yml"
+	Validate: ResourceSpec: string | *""
+	Lint: {
+		Severity: [string]: "error" | "warning" | "note" | "off"
+		Rules: [string]: {
+			Description: string | *""
+			Severity:    *"error" | "warning" | "note" | "off"
+			Types: [...string]
+		}
+	}
 	Deploy: {
+		AutoApprove: Policy: *"Safe" | "Any"
+		TemplateBucket: string | *""
 		Notify: {
 			Endpoint: string | *""
 			TopicArn: string | *""
@@ -43,6 +63,10 @@ type Config struct {
 	OsSeparator string
 	PackageName string
 	Auth        struct {
+		Provider string
+		Cache    struct {
+			Disk bool
+		}
 		AwsVault struct {
 			SourceProfile string
 		}
@@ -54,12 +78,25 @@ type Config struct {
 		Export struct {
 			YmlPath string
 		}
+		Validate struct {
+			ResourceSpec string
+		}
+		Lint struct {
+			Severity map[string]string
+			Rules    map[string]LintRuleConfig
+		}
 		Deploy struct {
-			Notify struct {
+			AutoApprove struct {
+				Policy string
+			}
+			TemplateBucket string
+			Notify         struct {
 				Endpoint, TopicArn string
 			}
 		}
 	}
+	// Value is the unified config, for hidden fields such as the _Constraint of lint rules, which are not decoded
+	Value cue.Value `json:"-"`
 }
 
 // LoadConfig looks for config.stx.cue to be colocated with cue.mod and unifies that with a built-in default config schema
@@ -119,7 +156,7 @@ func LoadConfig(log *logger.Logger) *Config {
 		log.Fatal(configErr.Error())
 	}
 
-	cfg := Config{CueRoot: path, OsSeparator: separator}
+	cfg := Config{CueRoot: path, OsSeparator: separator, Value: configValue}
 
 	log.Debug("Decoding config...")
 	decodeErr := configValue.Decode(&cfg)
@@ -0,0 +1,208 @@
+package stx
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/user"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/credentials/stscreds"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/sso"
+)
+
+// sharedConfigProvider gets credentials from the SDK's default chain using ~/.aws/config and ~/.aws/credentials
+type sharedConfigProvider struct{}
+
+func newSharedConfigProvider(config *Config) CredentialProvider {
+	return &sharedConfigProvider{}
+}
+
+// EnsureSession has nothing to prepare; MFA is prompted for as profiles are assumed
+func (p *sharedConfigProvider) EnsureSession() error {
+	return nil
+}
+
+// Credentials resolves the profile through the SDK, including assumed roles, credential_process, and instance profiles
+func (p *sharedConfigProvider) Credentials(profile string) (AwsCredentials, error) {
+	var creds AwsCredentials
+	sess, sessErr := session.NewSessionWithOptions(session.Options{
+		Profile:                 profile,
+		SharedConfigState:       session.SharedConfigEnable,
+		AssumeRoleTokenProvider: stscreds.StdinTokenProvider,
+	})
+	if sessErr != nil {
+		return creds, sessErr
+	}
+
+	value, valueErr := sess.Config.Credentials.Get()
+	if valueErr != nil {
+		return creds, valueErr
+	}
+	creds = AwsCredentials{AccessKeyID: value.AccessKeyID, SecretAccessKey: value.SecretAccessKey, SessionToken: value.SessionToken}
+
+	if expiresAt, expiresAtErr := sess.Config.Credentials.ExpiresAt(); expiresAtErr == nil {
+		creds.Expiration = expiresAt.Format(time.RFC3339)
+	}
+	return creds, nil
+}
+
+// environmentProvider uses the standard AWS_* environment variables for every profile
+type environmentProvider struct{}
+
+func newEnvironmentProvider(config *Config) CredentialProvider {
+	return &environmentProvider{}
+}
+
+// EnsureSession checks that credentials are present in the environment
+func (p *environmentProvider) EnsureSession() error {
+	_, err := p.Credentials("")
+	return err
+}
+
+// Credentials ignores the profile and returns the credentials from the environment
+func (p *environmentProvider) Credentials(profile string) (AwsCredentials, error) {
+	value, err := credentials.NewEnvCredentials().Get()
+	if err != nil {
+		return AwsCredentials{}, err
+	}
+	return AwsCredentials{AccessKeyID: value.AccessKeyID, SecretAccessKey: value.SecretAccessKey, SessionToken: value.SessionToken}, nil
+}
+
+// ssoProvider exchanges the access token cached by `aws sso login` for role credentials
+type ssoProvider struct{}
+
+func newSsoProvider(config *Config) CredentialProvider {
+	return &ssoProvider{}
+}
+
+// EnsureSession has nothing to prepare; tokens are cached by `aws sso login`
+func (p *ssoProvider) EnsureSession() error {
+	return nil
+}
+
+// ssoCachedToken is the format of the files written to ~/.aws/sso/cache
+type ssoCachedToken struct {
+	StartURL    string `json:"startUrl"`
+	Region      string `json:"region"`
+	AccessToken string `json:"accessToken"`
+	ExpiresAt   string `json:"expiresAt"`
+}
+
+// Credentials reads the sso_* settings of the profile from ~/.aws/config and gets role credentials using the cached token
+func (p *ssoProvider) Credentials(profile string) (AwsCredentials, error) {
+	var creds AwsCredentials
+	usr, _ := user.Current()
+
+	configFile := os.Getenv("AWS_CONFIG_FILE")
+	if configFile == "" {
+		configFile = filepath.Join(usr.HomeDir, ".aws", "config")
+	}
+	sections, sectionsErr := readIniFile(configFile)
+	if sectionsErr != nil {
+		return creds, sectionsErr
+	}
+
+	section, ok := sections["profile "+profile]
+	if !ok && profile == "default" {
+		section, ok = sections["default"]
+	}
+	if !ok {
+		return creds, fmt.Errorf("profile %s not found in %s", profile, configFile)
+	}
+	for _, key := range []string{"sso_start_url", "sso_region", "sso_account_id", "sso_role_name"} {
+		if section[key] == "" {
+			return creds, fmt.Errorf("profile %s is missing %s", profile, key)
+		}
+	}
+
+	token, tokenErr := findSsoToken(filepath.Join(usr.HomeDir, ".aws", "sso", "cache"), section["sso_start_url"])
+	if tokenErr != nil {
+		return creds, fmt.Errorf("%s; run `aws sso login --profile %s`", tokenErr, profile)
+	}
+
+	sess, sessErr := session.NewSession(aws.NewConfig().WithRegion(section["sso_region"]).WithCredentials(credentials.AnonymousCredentials))
+	if sessErr != nil {
+		return creds, sessErr
+	}
+	output, outputErr := sso.New(sess).GetRoleCredentials(&sso.GetRoleCredentialsInput{
+		AccessToken: aws.String(token.AccessToken),
+		AccountId:   aws.String(section["sso_account_id"]),
+		RoleName:    aws.String(section["sso_role_name"]),
+	})
+	if outputErr != nil {
+		return creds, outputErr
+	}
+
+	roleCredentials := output.RoleCredentials
+	creds = AwsCredentials{
+		AccessKeyID:     aws.StringValue(roleCredentials.AccessKeyId),
+		SecretAccessKey: aws.StringValue(roleCredentials.SecretAccessKey),
+		SessionToken:    aws.StringValue(roleCredentials.SessionToken),
+		Expiration:      time.Unix(0, aws.Int64Value(roleCredentials.Expiration)*int64(time.Millisecond)).Format(time.RFC3339),
+	}
+	return creds, nil
+}
+
+// findSsoToken returns the unexpired cached token for the start url
+func findSsoToken(cacheDir, startURL string) (ssoCachedToken, error) {
+	var token ssoCachedToken
+	files, _ := filepath.Glob(filepath.Join(cacheDir, "*.json"))
+	for _, file := range files {
+		fileBytes, readErr := ioutil.ReadFile(file)
+		if readErr != nil {
+			continue
+		}
+		var cached ssoCachedToken
+		if json.Unmarshal(fileBytes, &cached) != nil || cached.StartURL != startURL || cached.AccessToken == "" {
+			continue
+		}
+		// the cli has written expiresAt both with and without a zone offset
+		expiresAt, expiresAtErr := time.Parse(time.RFC3339, strings.Replace(cached.ExpiresAt, "UTC", "Z", 1))
+		if expiresAtErr != nil || expiresAt.Before(time.Now()) {
+			continue
+		}
+		return cached, nil
+	}
+	return token, errors.New("no unexpired SSO token cached for " + startURL)
+}
+
+// readIniFile parses the sections and key = value pairs of an aws config file
+func readIniFile(path string) (map[string]map[string]string, error) {
+	file, openErr := os.Open(path)
+	if openErr != nil {
+		return nil, openErr
+	}
+	defer file.Close()
+
+	sections := make(map[string]map[string]string)
+	var current map[string]string
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") || strings.HasPrefix(line, ";") {
+			continue
+		}
+		if strings.HasPrefix(line, "[") && strings.HasSuffix(line, "]") {
+			name := strings.Join(strings.Fields(line[1:len(line)-1]), " ")
+			current = make(map[string]string)
+			sections[name] = current
+			continue
+		}
+		if current == nil {
+			continue
+		}
+		if i := strings.Index(line, "="); i > 0 {
+			current[strings.TrimSpace(line[:i])] = strings.TrimSpace(line[i+1:])
+		}
+	}
+	return sections, scanner.Err()
+}
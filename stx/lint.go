@@ -0,0 +1,398 @@
+package stx
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"cuelang.org/go/cue"
+	"cuelang.org/go/cue/errors"
+	"cuelang.org/go/cue/format"
+	"cuelang.org/go/cue/token"
+)
+
+// Lint severities, named after SARIF levels. SeverityOff disables a rule.
+const (
+	SeverityError   = "error"
+	SeverityWarning = "warning"
+	SeverityNote    = "note"
+	SeverityOff     = "off"
+)
+
+// LintResource is a resource of the template being linted, as exported
+type LintResource struct {
+	Type           string
+	DeletionPolicy string                 `json:",omitempty"`
+	Properties     map[string]interface{} `json:",omitempty"`
+	Metadata       map[string]interface{} `json:",omitempty"`
+}
+
+// LintTemplate is what rules check: the stack and the resources of its Template
+type LintTemplate struct {
+	Stack     Stack
+	Resources map[string]LintResource
+}
+
+// LintFinding is one violation of a rule. Resource is empty for findings about the whole stack, and Path is relative to the resource.
+type LintFinding struct {
+	Resource, Message string
+	Path              []string
+}
+
+// LintRule checks a template. Rules written in Go are added with RegisterLintRule, rules written in CUE come from Cmd:Lint:Rules.
+type LintRule interface {
+	ID() string
+	Description() string
+	Severity() string
+	Check(template LintTemplate) ([]LintFinding, error)
+}
+
+// LintResult is a finding with the severity of its rule and the position of the CUE that declares it
+type LintResult struct {
+	RuleID, Severity, Stack, Resource, Message string
+	Pos                                        token.Pos
+}
+
+// goRule is a rule written in Go
+type goRule struct {
+	id, description, severity string
+	check                     func(template LintTemplate) []LintFinding
+}
+
+func (r goRule) ID() string          { return r.id }
+func (r goRule) Description() string { return r.description }
+func (r goRule) Severity() string    { return r.severity }
+func (r goRule) Check(template LintTemplate) ([]LintFinding, error) {
+	return r.check(template), nil
+}
+
+// lintRules holds the rules written in Go, in the order they were registered
+var lintRules = struct {
+	sync.Mutex
+	rules []LintRule
+}{}
+
+// RegisterLintRule adds a rule written in Go to the rules every lint runs
+func RegisterLintRule(rule LintRule) {
+	lintRules.Lock()
+	defer lintRules.Unlock()
+	lintRules.rules = append(lintRules.rules, rule)
+}
+
+// LintRules returns the rules written in Go
+func LintRules() []LintRule {
+	lintRules.Lock()
+	defer lintRules.Unlock()
+	return append([]LintRule{}, lintRules.rules...)
+}
+
+// statefulTypes are resource types whose data is lost when CloudFormation deletes them
+var statefulTypes = map[string]bool{
+	"AWS::Cognito::UserPool":             true,
+	"AWS::DocDB::DBCluster":              true,
+	"AWS::DynamoDB::Table":               true,
+	"AWS::EC2::Volume":                   true,
+	"AWS::EFS::FileSystem":               true,
+	"AWS::ElastiCache::ReplicationGroup": true,
+	"AWS::Elasticsearch::Domain":         true,
+	"AWS::KMS::Key":                      true,
+	"AWS::Neptune::DBCluster":            true,
+	"AWS::RDS::DBCluster":                true,
+	"AWS::RDS::DBInstance":               true,
+	"AWS::S3::Bucket":                    true,
+	"AWS::SecretsManager::Secret":        true,
+}
+
+func init() {
+	RegisterLintRule(goRule{
+		id:          "S3BucketPublicAccessBlock",
+		description: "S3 buckets must block public access with all four PublicAccessBlockConfiguration settings",
+		severity:    SeverityError,
+		check:       checkPublicAccessBlock,
+	})
+	RegisterLintRule(goRule{
+		id:          "LogGroupRetention",
+		description: "Log groups must set RetentionInDays",
+		severity:    SeverityError,
+		check: func(template LintTemplate) (findings []LintFinding) {
+			for _, name := range template.resourceNames("AWS::Logs::LogGroup") {
+				if _, ok := template.Resources[name].Properties["RetentionInDays"]; !ok {
+					findings = append(findings, LintFinding{Resource: name, Path: []string{"Properties"}, Message: "log group has no RetentionInDays, so its logs are kept forever"})
+				}
+			}
+			return findings
+		},
+	})
+	RegisterLintRule(goRule{
+		id:          "StackTags",
+		description: "Stacks must declare Stack.Tags and set TagsEnabled, so CloudFormation tags every resource",
+		severity:    SeverityError,
+		check: func(template LintTemplate) []LintFinding {
+			switch {
+			case len(template.Stack.Tags) < 1:
+				return []LintFinding{{Message: "stack declares no Tags, so its resources are not tagged"}}
+			case !template.Stack.TagsEnabled:
+				return []LintFinding{{Message: "stack declares Tags without TagsEnabled, so its resources are not tagged"}}
+			}
+			return nil
+		},
+	})
+	RegisterLintRule(goRule{
+		id:          "StatefulDeletionPolicy",
+		description: "Stateful resources must have DeletionPolicy Retain",
+		severity:    SeverityError,
+		check: func(template LintTemplate) (findings []LintFinding) {
+			for _, name := range template.resourceNames("") {
+				resource := template.Resources[name]
+				if statefulTypes[resource.Type] && resource.DeletionPolicy != "Retain" {
+					findings = append(findings, LintFinding{Resource: name, Path: []string{"DeletionPolicy"}, Message: fmt.Sprintf("%s must have DeletionPolicy Retain", resource.Type)})
+				}
+			}
+			return findings
+		},
+	})
+}
+
+// checkPublicAccessBlock reports buckets that do not set all four PublicAccessBlockConfiguration settings to true. Values computed by intrinsic functions are assumed to be true.
+func checkPublicAccessBlock(template LintTemplate) (findings []LintFinding) {
+	for _, name := range template.resourceNames("AWS::S3::Bucket") {
+		configuration, ok := template.Resources[name].Properties["PublicAccessBlockConfiguration"].(map[string]interface{})
+		if !ok {
+			findings = append(findings, LintFinding{Resource: name, Path: []string{"Properties"}, Message: "bucket has no PublicAccessBlockConfiguration"})
+			continue
+		}
+		var missing []string
+		for _, setting := range []string{"BlockPublicAcls", "BlockPublicPolicy", "IgnorePublicAcls", "RestrictPublicBuckets"} {
+			value := configuration[setting]
+			if value == true || value == "true" || isIntrinsic(value) {
+				continue
+			}
+			missing = append(missing, setting)
+		}
+		if len(missing) > 0 {
+			findings = append(findings, LintFinding{Resource: name, Path: []string{"Properties", "PublicAccessBlockConfiguration"}, Message: "PublicAccessBlockConfiguration does not set " + strings.Join(missing, ", ") + " to true"})
+		}
+	}
+	return findings
+}
+
+// resourceNames returns the names of the template's resources of resourceType, or of every resource if resourceType is empty, in order
+func (t LintTemplate) resourceNames(resourceType string) []string {
+	var names []string
+	for name, resource := range t.Resources {
+		if resourceType == "" || resource.Type == resourceType {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// LintRuleConfig is a rule written in CUE in config.stx.cue. The rule's hidden _Constraint field is unified with each resource of the
+// Types it applies to, or with every resource if Types is empty. A resource the constraint does not accept, or that lacks a field the
+// constraint sets, is a finding.
+type LintRuleConfig struct {
+	Description, Severity string
+	Types                 []string
+}
+
+// cueRule is a rule written in CUE, with its constraint formatted as source so it can be compiled next to each resource
+type cueRule struct {
+	id         string
+	config     LintRuleConfig
+	constraint string
+}
+
+func (r cueRule) ID() string          { return r.id }
+func (r cueRule) Description() string { return r.config.Description }
+func (r cueRule) Severity() string    { return r.config.Severity }
+
+// Check unifies the constraint with each resource. Both are compiled in the same runtime, since values from different runtimes cannot be unified.
+// Unification fills in fields the resource leaves out with the constraint's values, so a field that only the constraint has is a finding too.
+func (r cueRule) Check(template LintTemplate) ([]LintFinding, error) {
+	var findings []LintFinding
+	for _, name := range template.resourceNames("") {
+		resource := template.Resources[name]
+		if len(r.config.Types) > 0 && !containsString(r.config.Types, resource.Type) {
+			continue
+		}
+		resourceJSON, marshalErr := json.Marshal(resource)
+		if marshalErr != nil {
+			return nil, marshalErr
+		}
+		var runtime cue.Runtime
+		instance, compileErr := runtime.Compile(r.id, "constraint: "+r.constraint+"\nresource: "+string(resourceJSON))
+		if compileErr != nil {
+			return nil, fmt.Errorf("rule %s: %s", r.id, compileErr)
+		}
+		root := instance.Value()
+		unified := root.Lookup("constraint").Unify(root.Lookup("resource"))
+		if validateErr := unified.Validate(cue.Concrete(true)); validateErr != nil {
+			for _, err := range errors.Errors(validateErr) {
+				findings = append(findings, LintFinding{Resource: name, Path: err.Path(), Message: pathMessage(err.Path(), err.Error())})
+			}
+			continue
+		}
+
+		var unifiedValue, resourceValue interface{}
+		if decodeErr := unified.Decode(&unifiedValue); decodeErr != nil {
+			return nil, fmt.Errorf("rule %s: %s", r.id, decodeErr)
+		}
+		json.Unmarshal(resourceJSON, &resourceValue)
+		if path, required := missingField(unifiedValue, resourceValue, nil); path != nil {
+			requiredJSON, _ := json.Marshal(required)
+			findings = append(findings, LintFinding{Resource: name, Path: path, Message: pathMessage(path, "missing; the rule requires "+string(requiredJSON))})
+		}
+	}
+	return findings, nil
+}
+
+// pathMessage prefixes message with the path it is about, if there is one
+func pathMessage(path []string, message string) string {
+	if len(path) < 1 {
+		return message
+	}
+	return strings.Join(path, ".") + ": " + message
+}
+
+// missingField returns the path of the first field, in order, that unified has but resource does not, and the value unified has there
+func missingField(unified, resource interface{}, path []string) ([]string, interface{}) {
+	switch u := unified.(type) {
+	case map[string]interface{}:
+		r, _ := resource.(map[string]interface{})
+		keys := make([]string, 0, len(u))
+		for key := range u {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			fieldPath := append(append([]string{}, path...), key)
+			value, ok := r[key]
+			if !ok {
+				return fieldPath, u[key]
+			}
+			if missing, required := missingField(u[key], value, fieldPath); missing != nil {
+				return missing, required
+			}
+		}
+	case []interface{}:
+		r, _ := resource.([]interface{})
+		for i, item := range u {
+			itemPath := append(append([]string{}, path...), strconv.Itoa(i))
+			if i >= len(r) {
+				return itemPath, item
+			}
+			if missing, required := missingField(item, r[i], itemPath); missing != nil {
+				return missing, required
+			}
+		}
+	}
+	return nil, nil
+}
+
+// CueLintRules returns the rules written in CUE in Cmd:Lint:Rules, in order of their IDs
+func CueLintRules(config *Config) ([]LintRule, error) {
+	ids := make([]string, 0, len(config.Cmd.Lint.Rules))
+	for id := range config.Cmd.Lint.Rules {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	var rules []LintRule
+	for _, id := range ids {
+		ruleValue := config.Value.Lookup("Cmd", "Lint", "Rules", id)
+		var constraint cue.Value
+		fields, fieldsErr := ruleValue.Fields(cue.Hidden(true))
+		if fieldsErr != nil {
+			return nil, fieldsErr
+		}
+		for fields.Next() {
+			if fields.Label() == "_Constraint" {
+				constraint = fields.Value()
+			}
+		}
+		if !constraint.Exists() {
+			return nil, fmt.Errorf("lint rule %s has no _Constraint", id)
+		}
+		source, formatErr := format.Node(constraint.Syntax())
+		if formatErr != nil {
+			return nil, fmt.Errorf("lint rule %s: %s", id, formatErr)
+		}
+		rules = append(rules, cueRule{id: id, config: config.Cmd.Lint.Rules[id], constraint: string(source)})
+	}
+	return rules, nil
+}
+
+// Lint runs rules against the stack's Template. severities overrides the severity of rules by ID, and rules that are off are not run.
+// Findings are suppressed by listing rule IDs in Metadata: Stx: Lint: Suppress of the resource, or of the Template for the whole stack.
+func Lint(stack Stack, stackValue cue.Value, rules []LintRule, severities map[string]string) ([]LintResult, error) {
+	templateValue := stackValue.Lookup("Template")
+	var template struct {
+		Metadata  map[string]interface{}
+		Resources map[string]LintResource
+	}
+	if decodeErr := templateValue.Decode(&template); decodeErr != nil {
+		return nil, decodeErr
+	}
+	lintTemplate := LintTemplate{Stack: stack, Resources: template.Resources}
+	stackSuppressed := suppressedRules(template.Metadata)
+
+	var results []LintResult
+	for _, rule := range rules {
+		severity := rule.Severity()
+		if override, ok := severities[rule.ID()]; ok {
+			severity = override
+		}
+		if severity == SeverityOff || stackSuppressed[rule.ID()] {
+			continue
+		}
+		findings, checkErr := rule.Check(lintTemplate)
+		if checkErr != nil {
+			return nil, checkErr
+		}
+		for _, finding := range findings {
+			pos := stackValue.Pos()
+			if finding.Resource != "" {
+				if suppressedRules(template.Resources[finding.Resource].Metadata)[rule.ID()] {
+					continue
+				}
+				pos = valuePos(templateValue, append([]string{"Resources", finding.Resource}, finding.Path...))
+			}
+			results = append(results, LintResult{
+				RuleID:   rule.ID(),
+				Severity: severity,
+				Stack:    stack.Name,
+				Resource: finding.Resource,
+				Message:  finding.Message,
+				Pos:      pos,
+			})
+		}
+	}
+	return results, nil
+}
+
+// suppressedRules reads the rule IDs listed in Stx: Lint: Suppress of a Metadata section
+func suppressedRules(metadata map[string]interface{}) map[string]bool {
+	suppressed := make(map[string]bool)
+	stxMetadata, _ := metadata["Stx"].(map[string]interface{})
+	lintMetadata, _ := stxMetadata["Lint"].(map[string]interface{})
+	ids, _ := lintMetadata["Suppress"].([]interface{})
+	for _, id := range ids {
+		if s, ok := id.(string); ok {
+			suppressed[s] = true
+		}
+	}
+	return suppressed
+}
+
+func containsString(values []string, s string) bool {
+	for _, v := range values {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
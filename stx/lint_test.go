@@ -0,0 +1,229 @@
+package stx
+
+import (
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// lintRule returns the built-in rule with the ID, failing the test if there is none
+func lintRule(t *testing.T, id string) LintRule {
+	t.Helper()
+	for _, rule := range LintRules() {
+		if rule.ID() == id {
+			return rule
+		}
+	}
+	t.Fatalf("no built-in rule %s", id)
+	return nil
+}
+
+func TestBuiltInLintRules(t *testing.T) {
+	blocked := map[string]interface{}{"BlockPublicAcls": true, "BlockPublicPolicy": "true", "IgnorePublicAcls": map[string]interface{}{"Ref": "Block"}, "RestrictPublicBuckets": true}
+	partlyBlocked := map[string]interface{}{"BlockPublicAcls": true, "BlockPublicPolicy": false}
+
+	tests := []struct {
+		rule     string
+		template LintTemplate
+		want     []LintFinding
+	}{
+		{"S3BucketPublicAccessBlock", LintTemplate{Resources: map[string]LintResource{
+			"Blocked":   {Type: "AWS::S3::Bucket", Properties: map[string]interface{}{"PublicAccessBlockConfiguration": blocked}},
+			"Partly":    {Type: "AWS::S3::Bucket", Properties: map[string]interface{}{"PublicAccessBlockConfiguration": partlyBlocked}},
+			"Unblocked": {Type: "AWS::S3::Bucket"},
+			"Queue":     {Type: "AWS::SQS::Queue"},
+		}}, []LintFinding{
+			{Resource: "Partly", Path: []string{"Properties", "PublicAccessBlockConfiguration"}, Message: "PublicAccessBlockConfiguration does not set BlockPublicPolicy, IgnorePublicAcls, RestrictPublicBuckets to true"},
+			{Resource: "Unblocked", Path: []string{"Properties"}, Message: "bucket has no PublicAccessBlockConfiguration"},
+		}},
+		{"LogGroupRetention", LintTemplate{Resources: map[string]LintResource{
+			"Kept":      {Type: "AWS::Logs::LogGroup", Properties: map[string]interface{}{"RetentionInDays": 30}},
+			"Forever":   {Type: "AWS::Logs::LogGroup"},
+			"NotALog":   {Type: "AWS::SQS::Queue"},
+			"Forgotten": {Type: "AWS::Logs::LogGroup", Properties: map[string]interface{}{"LogGroupName": "a"}},
+		}}, []LintFinding{
+			{Resource: "Forever", Path: []string{"Properties"}, Message: "log group has no RetentionInDays, so its logs are kept forever"},
+			{Resource: "Forgotten", Path: []string{"Properties"}, Message: "log group has no RetentionInDays, so its logs are kept forever"},
+		}},
+		{"StackTags", LintTemplate{Stack: Stack{Tags: map[string]string{"a": "b"}, TagsEnabled: true}}, nil},
+		{"StackTags", LintTemplate{Stack: Stack{}}, []LintFinding{
+			{Message: "stack declares no Tags, so its resources are not tagged"},
+		}},
+		{"StackTags", LintTemplate{Stack: Stack{Tags: map[string]string{"a": "b"}}}, []LintFinding{
+			{Message: "stack declares Tags without TagsEnabled, so its resources are not tagged"},
+		}},
+		{"StatefulDeletionPolicy", LintTemplate{Resources: map[string]LintResource{
+			"Retained":  {Type: "AWS::DynamoDB::Table", DeletionPolicy: "Retain"},
+			"Deleted":   {Type: "AWS::RDS::DBInstance", DeletionPolicy: "Snapshot"},
+			"Default":   {Type: "AWS::S3::Bucket"},
+			"Stateless": {Type: "AWS::SQS::Queue"},
+		}}, []LintFinding{
+			{Resource: "Default", Path: []string{"DeletionPolicy"}, Message: "AWS::S3::Bucket must have DeletionPolicy Retain"},
+			{Resource: "Deleted", Path: []string{"DeletionPolicy"}, Message: "AWS::RDS::DBInstance must have DeletionPolicy Retain"},
+		}},
+	}
+	for _, test := range tests {
+		findings, checkErr := lintRule(t, test.rule).Check(test.template)
+		if checkErr != nil {
+			t.Errorf("%s: %s", test.rule, checkErr)
+			continue
+		}
+		if !reflect.DeepEqual(findings, test.want) {
+			t.Errorf("%s: got %+v, want %+v", test.rule, findings, test.want)
+		}
+	}
+}
+
+func TestCueLintRule(t *testing.T) {
+	rule := cueRule{
+		id:         "Versioned",
+		config:     LintRuleConfig{Types: []string{"AWS::S3::Bucket"}},
+		constraint: `{DeletionPolicy: "Retain", Properties: {VersioningConfiguration: Status: "Enabled", BucketName: =~"^stx-"}}`,
+	}
+	versioned := map[string]interface{}{"Status": "Enabled"}
+
+	tests := []struct {
+		name     string
+		resource LintResource
+		want     []LintFinding
+	}{
+		{"accepted", LintResource{Type: "AWS::S3::Bucket", DeletionPolicy: "Retain", Properties: map[string]interface{}{"VersioningConfiguration": versioned, "BucketName": "stx-a"}}, nil},
+		{"other type", LintResource{Type: "AWS::SQS::Queue"}, nil},
+		{"conflict", LintResource{Type: "AWS::S3::Bucket", DeletionPolicy: "Delete", Properties: map[string]interface{}{"VersioningConfiguration": versioned, "BucketName": "stx-a"}}, []LintFinding{
+			{Resource: "Bucket", Path: []string{"DeletionPolicy"}, Message: `DeletionPolicy: conflicting values "Retain" and "Delete"`},
+		}},
+		{"missing field", LintResource{Type: "AWS::S3::Bucket", Properties: map[string]interface{}{"VersioningConfiguration": versioned, "BucketName": "stx-a"}}, []LintFinding{
+			{Resource: "Bucket", Path: []string{"DeletionPolicy"}, Message: `DeletionPolicy: missing; the rule requires "Retain"`},
+		}},
+		{"missing nested field", LintResource{Type: "AWS::S3::Bucket", DeletionPolicy: "Retain", Properties: map[string]interface{}{"BucketName": "stx-a"}}, []LintFinding{
+			{Resource: "Bucket", Path: []string{"Properties", "VersioningConfiguration"}, Message: `Properties.VersioningConfiguration: missing; the rule requires {"Status":"Enabled"}`},
+		}},
+		{"incomplete", LintResource{Type: "AWS::S3::Bucket", DeletionPolicy: "Retain", Properties: map[string]interface{}{"VersioningConfiguration": versioned}}, []LintFinding{
+			{Resource: "Bucket", Path: []string{"Properties", "BucketName"}},
+		}},
+	}
+	for _, test := range tests {
+		findings, checkErr := rule.Check(LintTemplate{Resources: map[string]LintResource{"Bucket": test.resource}})
+		if checkErr != nil {
+			t.Errorf("%s: %s", test.name, checkErr)
+			continue
+		}
+		if len(findings) != len(test.want) {
+			t.Errorf("%s: got %+v, want %+v", test.name, findings, test.want)
+			continue
+		}
+		for i, finding := range findings {
+			want := test.want[i]
+			if finding.Resource != want.Resource || !reflect.DeepEqual(finding.Path, want.Path) {
+				t.Errorf("%s: got %+v, want %+v", test.name, finding, want)
+			}
+			// messages from cue are only checked for the path they start with
+			if want.Message == "" {
+				want.Message = strings.Join(want.Path, ".") + ": "
+			}
+			if !strings.HasPrefix(finding.Message, want.Message) {
+				t.Errorf("%s: got message %q, want %q", test.name, finding.Message, want.Message)
+			}
+		}
+	}
+}
+
+func TestCueLintRules(t *testing.T) {
+	value := compileTemplate(t, `Cmd: Lint: Rules: {
+	Retained: {
+		Severity: "warning"
+		_Constraint: DeletionPolicy: "Retain"
+	}
+	Encrypted: _Constraint: Properties: KmsMasterKeyId: string
+	Unconstrained: Description: "has no constraint"
+}
+`)
+	config := &Config{Value: value}
+	config.Cmd.Lint.Rules = map[string]LintRuleConfig{"Retained": {Severity: "warning"}, "Encrypted": {Severity: "error"}}
+
+	rules, rulesErr := CueLintRules(config)
+	if rulesErr != nil {
+		t.Fatal(rulesErr)
+	}
+	var ids []string
+	for _, rule := range rules {
+		ids = append(ids, rule.ID()+":"+rule.Severity())
+	}
+	if want := []string{"Encrypted:error", "Retained:warning"}; !reflect.DeepEqual(ids, want) {
+		t.Errorf("got rules %v, want %v", ids, want)
+	}
+
+	config.Cmd.Lint.Rules["Unconstrained"] = LintRuleConfig{}
+	if _, rulesErr := CueLintRules(config); rulesErr == nil || rulesErr.Error() != "lint rule Unconstrained has no _Constraint" {
+		t.Errorf("got error %v for a rule without _Constraint", rulesErr)
+	}
+}
+
+func TestLint(t *testing.T) {
+	stackValue := compileTemplate(t, `Template: {
+	Metadata: Stx: Lint: Suppress: ["StackTags"]
+	Resources: {
+		Logs: {
+			Type: "AWS::Logs::LogGroup"
+			Properties: LogGroupName: "a"
+		}
+		Table: {
+			Type: "AWS::DynamoDB::Table"
+			Metadata: Stx: Lint: Suppress: ["StatefulDeletionPolicy"]
+		}
+		Bucket: {
+			Type:           "AWS::S3::Bucket"
+			DeletionPolicy: "Delete"
+			Properties: PublicAccessBlockConfiguration: {BlockPublicAcls: true, BlockPublicPolicy: true, IgnorePublicAcls: true, RestrictPublicBuckets: true}
+		}
+	}
+}
+`)
+	stack := Stack{Name: "test-stack"}
+	rules := []LintRule{
+		lintRule(t, "S3BucketPublicAccessBlock"),
+		lintRule(t, "LogGroupRetention"),
+		lintRule(t, "StackTags"),
+		lintRule(t, "StatefulDeletionPolicy"),
+		goRule{id: "Disabled", severity: SeverityOff, check: func(LintTemplate) []LintFinding {
+			t.Error("a rule that is off was run")
+			return nil
+		}},
+	}
+
+	tests := []struct {
+		name       string
+		severities map[string]string
+		want       []string
+	}{
+		{"default severities", nil, []string{
+			"LogGroupRetention error Logs 6",
+			"StatefulDeletionPolicy error Bucket 14",
+		}},
+		{"overridden severity", map[string]string{"LogGroupRetention": SeverityWarning, "StatefulDeletionPolicy": SeverityNote}, []string{
+			"LogGroupRetention warning Logs 6",
+			"StatefulDeletionPolicy note Bucket 14",
+		}},
+		{"severity off", map[string]string{"LogGroupRetention": SeverityOff}, []string{
+			"StatefulDeletionPolicy error Bucket 14",
+		}},
+	}
+	for _, test := range tests {
+		results, lintErr := Lint(stack, stackValue, rules, test.severities)
+		if lintErr != nil {
+			t.Errorf("%s: %s", test.name, lintErr)
+			continue
+		}
+		var got []string
+		for _, result := range results {
+			if result.Stack != stack.Name {
+				t.Errorf("%s: result for stack %s", test.name, result.Stack)
+			}
+			got = append(got, strings.Join([]string{result.RuleID, result.Severity, result.Resource, strconv.Itoa(result.Pos.Line())}, " "))
+		}
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%s: got\n  %s\nwant\n  %s", test.name, strings.Join(got, "\n  "), strings.Join(test.want, "\n  "))
+		}
+	}
+}
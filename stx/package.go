@@ -0,0 +1,203 @@
+package stx
+
+import (
+	"archive/zip"
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v2"
+)
+
+// Uploader stores packaged artifacts. extension is appended to the key.
+type Uploader interface {
+	Upload(body []byte, extension string) (S3Location, error)
+}
+
+// Artifact is a local path referenced by a template property, and where it was uploaded
+type Artifact struct {
+	Resource, Property, Path string
+	Location                 S3Location
+}
+
+// packageRule describes how a property that references a local path is packaged and rewritten
+type packageRule struct {
+	property string
+	zip      bool // directories and files that are not already archives are zipped
+	nested   bool // the file is a template whose own artifacts are packaged first
+	rewrite  func(location S3Location) interface{}
+}
+
+// packageRules are keyed by resource type, following `aws cloudformation package`
+var packageRules = map[string]packageRule{
+	"AWS::Lambda::Function": {property: "Code", zip: true, rewrite: func(location S3Location) interface{} {
+		return yaml.MapSlice{{Key: "S3Bucket", Value: location.Bucket}, {Key: "S3Key", Value: location.Key}}
+	}},
+	"AWS::Serverless::Function": {property: "CodeUri", zip: true, rewrite: func(location S3Location) interface{} {
+		return "s3://" + location.Bucket + "/" + location.Key
+	}},
+	"AWS::CloudFormation::Stack": {property: "TemplateURL", nested: true, rewrite: func(location S3Location) interface{} {
+		return location.URL
+	}},
+	"AWS::ApiGateway::RestApi": {property: "BodyS3Location", rewrite: func(location S3Location) interface{} {
+		return yaml.MapSlice{{Key: "Bucket", Value: location.Bucket}, {Key: "Key", Value: location.Key}}
+	}},
+}
+
+// PackageTemplate uploads the local paths referenced by the template's resources and rewrites the references to point at the uploads.
+// Relative paths are resolved from baseDir. If nothing references a local path, the template is returned unchanged.
+func PackageTemplate(template []byte, baseDir string, uploader Uploader) ([]byte, []Artifact, error) {
+	var root yaml.MapSlice
+	if unmarshalErr := yaml.Unmarshal(template, &root); unmarshalErr != nil {
+		return nil, nil, unmarshalErr
+	}
+
+	var artifacts []Artifact
+	resources, _ := mapSliceValue(root, "Resources").(yaml.MapSlice)
+	for _, resourceItem := range resources {
+		resource, _ := resourceItem.Value.(yaml.MapSlice)
+		resourceType, _ := mapSliceValue(resource, "Type").(string)
+		rule, ok := packageRules[resourceType]
+		if !ok {
+			continue
+		}
+		properties, _ := mapSliceValue(resource, "Properties").(yaml.MapSlice)
+		for i, property := range properties {
+			path, ok := property.Value.(string)
+			if property.Key != rule.property || !ok || !isLocalPath(path) {
+				continue
+			}
+			if !filepath.IsAbs(path) {
+				path = filepath.Join(baseDir, path)
+			}
+
+			artifact := Artifact{Resource: fmt.Sprint(resourceItem.Key), Property: rule.property, Path: path}
+			location, uploadErr := uploadArtifact(rule, path, uploader)
+			if uploadErr != nil {
+				return nil, nil, fmt.Errorf("Unable to package %s.%s: %s", artifact.Resource, artifact.Property, uploadErr)
+			}
+			artifact.Location = location
+			artifacts = append(artifacts, artifact)
+			properties[i].Value = rule.rewrite(location)
+		}
+	}
+
+	if len(artifacts) == 0 {
+		return template, nil, nil
+	}
+	packaged, marshalErr := yaml.Marshal(root)
+	return packaged, artifacts, marshalErr
+}
+
+// uploadArtifact prepares the file or directory at path according to the rule and uploads it
+func uploadArtifact(rule packageRule, path string, uploader Uploader) (S3Location, error) {
+	info, statErr := os.Stat(path)
+	if statErr != nil {
+		return S3Location{}, statErr
+	}
+
+	if rule.zip {
+		extension := strings.ToLower(filepath.Ext(path))
+		if !info.IsDir() && (extension == ".zip" || extension == ".jar") {
+			body, readErr := ioutil.ReadFile(path)
+			if readErr != nil {
+				return S3Location{}, readErr
+			}
+			return uploader.Upload(body, extension[1:])
+		}
+		body, zipErr := zipPath(path)
+		if zipErr != nil {
+			return S3Location{}, zipErr
+		}
+		return uploader.Upload(body, "zip")
+	}
+
+	if info.IsDir() {
+		return S3Location{}, fmt.Errorf("%s is a directory", path)
+	}
+	body, readErr := ioutil.ReadFile(path)
+	if readErr != nil {
+		return S3Location{}, readErr
+	}
+
+	if rule.nested {
+		packaged, _, packageErr := PackageTemplate(body, filepath.Dir(path), uploader)
+		if packageErr != nil {
+			return S3Location{}, packageErr
+		}
+		return uploader.Upload(packaged, "cfn.yml")
+	}
+
+	extension := strings.TrimPrefix(filepath.Ext(path), ".")
+	if extension == "" {
+		extension = "artifact"
+	}
+	return uploader.Upload(body, extension)
+}
+
+// zipPath zips a directory's contents, or a single file. Timestamps are fixed so that unchanged content zips to the same bytes.
+func zipPath(path string) ([]byte, error) {
+	var buf bytes.Buffer
+	zipWriter := zip.NewWriter(&buf)
+	modified := time.Date(1980, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	walkErr := filepath.Walk(path, func(file string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() {
+			return err
+		}
+		name, relErr := filepath.Rel(path, file)
+		if relErr != nil {
+			return relErr
+		}
+		if name == "." {
+			name = filepath.Base(file)
+		}
+
+		header, headerErr := zip.FileInfoHeader(info)
+		if headerErr != nil {
+			return headerErr
+		}
+		header.Name = filepath.ToSlash(name)
+		header.Method = zip.Deflate
+		header.Modified = modified
+		writer, createErr := zipWriter.CreateHeader(header)
+		if createErr != nil {
+			return createErr
+		}
+		body, readErr := ioutil.ReadFile(file)
+		if readErr != nil {
+			return readErr
+		}
+		_, writeErr := writer.Write(body)
+		return writeErr
+	})
+	if walkErr != nil {
+		return nil, walkErr
+	}
+	closeErr := zipWriter.Close()
+	return buf.Bytes(), closeErr
+}
+
+// isLocalPath returns false for s3 and http(s) locations
+func isLocalPath(path string) bool {
+	for _, scheme := range []string{"s3://", "http://", "https://"} {
+		if strings.HasPrefix(path, scheme) {
+			return false
+		}
+	}
+	return path != ""
+}
+
+// mapSliceValue returns the value of key in a yaml.MapSlice, or nil
+func mapSliceValue(mapSlice yaml.MapSlice, key string) interface{} {
+	for _, item := range mapSlice {
+		if item.Key == key {
+			return item.Value
+		}
+	}
+	return nil
+}
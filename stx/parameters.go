@@ -0,0 +1,180 @@
+package stx
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"cuelang.org/go/cue"
+)
+
+// TemplateParameter represents the decoded value of Template.Parameters[name]
+type TemplateParameter struct {
+	Type, Description, ConstraintDescription, AllowedPattern string
+	Default, NoEcho                                          interface{}
+	AllowedValues                                            []interface{}
+	MinLength, MaxLength                                     *int
+	MinValue, MaxValue                                       *float64
+}
+
+// GetTemplateParameters decodes Template.Parameters of the stack, keyed by parameter name
+func GetTemplateParameters(stackValue cue.Value) (map[string]TemplateParameter, error) {
+	parameters := make(map[string]TemplateParameter)
+	parametersValue := stackValue.Lookup("Template", "Parameters")
+	if !parametersValue.Exists() {
+		return parameters, nil
+	}
+
+	decodeErr := parametersValue.Decode(&parameters)
+	return parameters, decodeErr
+}
+
+// IsList returns true for CommaDelimitedList and List<...> types
+func (p TemplateParameter) IsList() bool {
+	return p.Type == "CommaDelimitedList" || strings.HasPrefix(p.Type, "List<")
+}
+
+// IsNoEcho returns true if NoEcho is set to true or "true"
+func (p TemplateParameter) IsNoEcho() bool {
+	return p.NoEcho == true || p.NoEcho == "true"
+}
+
+// IsNumber returns true for Number and List<Number> types
+func (p TemplateParameter) IsNumber() bool {
+	return p.Type == "Number" || p.Type == "List<Number>"
+}
+
+// Encode converts a value decoded from yaml into the string CloudFormation expects for the parameter's Type,
+// and validates it against the parameter's constraints
+func (p TemplateParameter) Encode(value interface{}) (string, error) {
+	if items, ok := value.([]interface{}); ok {
+		if !p.IsList() {
+			return "", fmt.Errorf("a list was given, but Type is %s", p.Type)
+		}
+		var encodedItems []string
+		for _, item := range items {
+			encodedItem, encodeErr := encodeScalar(item)
+			if encodeErr != nil {
+				return "", encodeErr
+			}
+			if strings.Contains(encodedItem, ",") {
+				return "", fmt.Errorf("list item %q contains a comma", encodedItem)
+			}
+			encodedItems = append(encodedItems, encodedItem)
+		}
+		encoded := strings.Join(encodedItems, ",")
+		return encoded, p.Validate(encoded)
+	}
+
+	encoded, encodeErr := encodeScalar(value)
+	if encodeErr != nil {
+		return "", encodeErr
+	}
+	return encoded, p.Validate(encoded)
+}
+
+// Validate checks an encoded value against the parameter's Type, AllowedValues, AllowedPattern, MinLength, MaxLength, MinValue, and MaxValue
+func (p TemplateParameter) Validate(value string) error {
+	items := []string{value}
+	if p.IsList() {
+		items = strings.Split(value, ",")
+	}
+
+	for _, item := range items {
+		if p.IsList() {
+			item = strings.TrimSpace(item)
+		}
+
+		if p.IsNumber() {
+			number, numberErr := strconv.ParseFloat(item, 64)
+			if numberErr != nil {
+				return p.constraintError("%q is not a number", item)
+			}
+			if p.MinValue != nil && number < *p.MinValue {
+				return p.constraintError("%s is less than MinValue %v", item, *p.MinValue)
+			}
+			if p.MaxValue != nil && number > *p.MaxValue {
+				return p.constraintError("%s is greater than MaxValue %v", item, *p.MaxValue)
+			}
+		}
+
+		if p.Type == "String" || p.Type == "" {
+			length := utf8.RuneCountInString(item)
+			if p.MinLength != nil && length < *p.MinLength {
+				return p.constraintError("%q is shorter than MinLength %d", item, *p.MinLength)
+			}
+			if p.MaxLength != nil && length > *p.MaxLength {
+				return p.constraintError("%q is longer than MaxLength %d", item, *p.MaxLength)
+			}
+			if p.AllowedPattern != "" {
+				// CloudFormation requires the whole value to match the pattern
+				pattern, patternErr := regexp.Compile("^(?:" + p.AllowedPattern + ")$")
+				if patternErr != nil {
+					return fmt.Errorf("invalid AllowedPattern %q: %s", p.AllowedPattern, patternErr)
+				}
+				if !pattern.MatchString(item) {
+					return p.constraintError("%q does not match AllowedPattern %s", item, p.AllowedPattern)
+				}
+			}
+		}
+
+		if len(p.AllowedValues) > 0 {
+			allowed := false
+			allowedValues := p.AllowedValueStrings()
+			for _, allowedValue := range allowedValues {
+				if allowedValue == item {
+					allowed = true
+				}
+			}
+			if !allowed {
+				return p.constraintError("%q is not one of AllowedValues [%s]", item, strings.Join(allowedValues, ", "))
+			}
+		}
+	}
+	return nil
+}
+
+// AllowedValueStrings returns AllowedValues encoded as strings
+func (p TemplateParameter) AllowedValueStrings() []string {
+	var allowedValues []string
+	for _, allowedValue := range p.AllowedValues {
+		encodedAllowedValue, _ := encodeScalar(allowedValue)
+		allowedValues = append(allowedValues, encodedAllowedValue)
+	}
+	return allowedValues
+}
+
+// constraintError formats an error, followed by the ConstraintDescription if the parameter has one
+func (p TemplateParameter) constraintError(format string, args ...interface{}) error {
+	err := fmt.Sprintf(format, args...)
+	if p.ConstraintDescription != "" {
+		err += ": " + p.ConstraintDescription
+	}
+	return fmt.Errorf("%s", err)
+}
+
+// encodeScalar converts a yaml scalar to a string. Maps and lists are not scalars.
+func encodeScalar(value interface{}) (string, error) {
+	switch v := value.(type) {
+	case nil:
+		return "", nil
+	case string:
+		return v, nil
+	case bool:
+		return strconv.FormatBool(v), nil
+	case int:
+		return strconv.Itoa(v), nil
+	case int64:
+		return strconv.FormatInt(v, 10), nil
+	case uint64:
+		return strconv.FormatUint(v, 10), nil
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64), nil
+	case []interface{}:
+		return "", fmt.Errorf("unexpected list %v", v)
+	default:
+		return "", fmt.Errorf("unexpected value %v", v)
+	}
+}
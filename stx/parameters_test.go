@@ -0,0 +1,81 @@
+package stx
+
+import (
+	"strings"
+	"testing"
+)
+
+func intPtr(i int) *int           { return &i }
+func floatPtr(f float64) *float64 { return &f }
+
+func TestTemplateParameterEncode(t *testing.T) {
+	tests := []struct {
+		name      string
+		parameter TemplateParameter
+		value     interface{}
+		want      string
+		err       string
+	}{
+		{"string", TemplateParameter{Type: "String"}, "no", "no", ""},
+		{"bool", TemplateParameter{Type: "String"}, true, "true", ""},
+		{"int", TemplateParameter{Type: "Number"}, 42, "42", ""},
+		{"float", TemplateParameter{Type: "Number"}, 1.5, "1.5", ""},
+		{"large float", TemplateParameter{Type: "Number"}, 1e21, "1000000000000000000000", ""},
+		{"null", TemplateParameter{Type: "String"}, nil, "", ""},
+		{"list", TemplateParameter{Type: "CommaDelimitedList"}, []interface{}{"a", "b"}, "a,b", ""},
+		{"number list", TemplateParameter{Type: "List<Number>"}, []interface{}{1, 2.5}, "1,2.5", ""},
+		{"subnet list", TemplateParameter{Type: "List<AWS::EC2::Subnet::Id>"}, []interface{}{"subnet-1", "subnet-2"}, "subnet-1,subnet-2", ""},
+		{"list for a string", TemplateParameter{Type: "String"}, []interface{}{"a"}, "", "a list was given, but Type is String"},
+		{"list item with a comma", TemplateParameter{Type: "CommaDelimitedList"}, []interface{}{"a,b"}, "", `list item "a,b" contains a comma`},
+		{"nested list", TemplateParameter{Type: "CommaDelimitedList"}, []interface{}{[]interface{}{"a"}}, "", "unexpected list [a]"},
+		{"map", TemplateParameter{Type: "String"}, map[string]interface{}{"a": "b"}, "", "unexpected value map[a:b]"},
+		{"invalid number", TemplateParameter{Type: "Number"}, "ten", "", `"ten" is not a number`},
+	}
+	for _, test := range tests {
+		encoded, err := test.parameter.Encode(test.value)
+		switch {
+		case test.err != "" && (err == nil || err.Error() != test.err):
+			t.Errorf("%s: got error %v, want %q", test.name, err, test.err)
+		case test.err == "" && err != nil:
+			t.Errorf("%s: got error %v", test.name, err)
+		case test.err == "" && encoded != test.want:
+			t.Errorf("%s: got %q, want %q", test.name, encoded, test.want)
+		}
+	}
+}
+
+func TestTemplateParameterValidate(t *testing.T) {
+	tests := []struct {
+		name      string
+		parameter TemplateParameter
+		value     string
+		err       string // a substring of the error, or empty if the value is valid
+	}{
+		{"allowed value", TemplateParameter{Type: "String", AllowedValues: []interface{}{"dev", "prod"}}, "prod", ""},
+		{"disallowed value", TemplateParameter{Type: "String", AllowedValues: []interface{}{"dev", "prod"}}, "test", `"test" is not one of AllowedValues [dev, prod]`},
+		{"allowed number", TemplateParameter{Type: "Number", AllowedValues: []interface{}{1, 2}}, "2", ""},
+		{"allowed list items", TemplateParameter{Type: "CommaDelimitedList", AllowedValues: []interface{}{"a", "b"}}, "a, b", ""},
+		{"disallowed list item", TemplateParameter{Type: "CommaDelimitedList", AllowedValues: []interface{}{"a", "b"}}, "a,c", `"c" is not one of AllowedValues`},
+		{"matching pattern", TemplateParameter{Type: "String", AllowedPattern: "[a-z]+"}, "abc", ""},
+		{"partly matching pattern", TemplateParameter{Type: "String", AllowedPattern: "[a-z]+"}, "abc1", `"abc1" does not match AllowedPattern [a-z]+`},
+		{"alternation pattern", TemplateParameter{Type: "String", AllowedPattern: "a|b"}, "ab", "does not match AllowedPattern"},
+		{"invalid pattern", TemplateParameter{Type: "String", AllowedPattern: "("}, "a", `invalid AllowedPattern "("`},
+		{"MinLength", TemplateParameter{Type: "String", MinLength: intPtr(3)}, "ab", `"ab" is shorter than MinLength 3`},
+		{"MinLength in runes", TemplateParameter{Type: "String", MinLength: intPtr(3)}, "äöü", ""},
+		{"MaxLength", TemplateParameter{Type: "String", MaxLength: intPtr(2)}, "abc", `"abc" is longer than MaxLength 2`},
+		{"MinValue", TemplateParameter{Type: "Number", MinValue: floatPtr(1)}, "0", "0 is less than MinValue 1"},
+		{"MaxValue", TemplateParameter{Type: "Number", MaxValue: floatPtr(10)}, "10.5", "10.5 is greater than MaxValue 10"},
+		{"MaxValue of list item", TemplateParameter{Type: "List<Number>", MaxValue: floatPtr(10)}, "1, 11", "11 is greater than MaxValue 10"},
+		{"within MaxValue", TemplateParameter{Type: "Number", MaxValue: floatPtr(10)}, "10", ""},
+		{"ConstraintDescription", TemplateParameter{Type: "String", MaxLength: intPtr(1), ConstraintDescription: "one letter"}, "ab", "longer than MaxLength 1: one letter"},
+	}
+	for _, test := range tests {
+		err := test.parameter.Validate(test.value)
+		switch {
+		case test.err == "" && err != nil:
+			t.Errorf("%s: got error %v", test.name, err)
+		case test.err != "" && (err == nil || !strings.Contains(err.Error(), test.err)):
+			t.Errorf("%s: got error %v, want %q", test.name, err, test.err)
+		}
+	}
+}
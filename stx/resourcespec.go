@@ -0,0 +1,391 @@
+package stx
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"cuelang.org/go/cue"
+	"cuelang.org/go/cue/token"
+)
+
+// ResourceSpec is a CloudFormation resource specification, as published for each region by AWS
+type ResourceSpec struct {
+	ResourceSpecificationVersion string
+	PropertyTypes                map[string]PropertyTypeSpec
+	ResourceTypes                map[string]PropertyTypeSpec
+}
+
+// PropertyTypeSpec describes a resource type or a property type. A few property types are a single primitive value instead of properties.
+type PropertyTypeSpec struct {
+	Properties                    map[string]PropertySpec
+	PrimitiveType, Type, ItemType string
+	PrimitiveItemType             string
+}
+
+// PropertySpec describes one property: either a PrimitiveType, or a Type that is List, Map, or the name of a property type
+type PropertySpec struct {
+	PrimitiveType, PrimitiveItemType string
+	Type, ItemType                   string
+	Required                         bool
+}
+
+// resourceSpecs caches specifications by path, since they are several megabytes
+var resourceSpecs = struct {
+	sync.Mutex
+	byPath map[string]*ResourceSpec
+}{byPath: make(map[string]*ResourceSpec)}
+
+// LoadResourceSpec reads a CloudFormationResourceSpecification.json, caching it for later calls with the same path
+func LoadResourceSpec(path string) (*ResourceSpec, error) {
+	resourceSpecs.Lock()
+	defer resourceSpecs.Unlock()
+	if spec, ok := resourceSpecs.byPath[path]; ok {
+		return spec, nil
+	}
+
+	specBytes, readErr := ioutil.ReadFile(path)
+	if readErr != nil {
+		return nil, readErr
+	}
+	var spec ResourceSpec
+	if unmarshalErr := json.Unmarshal(specBytes, &spec); unmarshalErr != nil {
+		return nil, fmt.Errorf("%s: %s", path, unmarshalErr)
+	}
+	if len(spec.ResourceTypes) < 1 {
+		return nil, fmt.Errorf("%s is not a CloudFormation resource specification", path)
+	}
+	resourceSpecs.byPath[path] = &spec
+	return &spec, nil
+}
+
+// propertyChecker collects problems while checking the properties of one template
+type propertyChecker struct {
+	spec     *ResourceSpec
+	template cue.Value
+	problems []TemplateProblem
+}
+
+// CheckResources checks the Properties of every resource in the stack's Template against the specification:
+// required properties, unknown property names, primitive types, and list or map shapes. Resource types the
+// specification does not describe, such as custom resources, are not checked, and neither are values
+// computed by intrinsic functions. Each problem has the position of the CUE that declares it.
+func (s *ResourceSpec) CheckResources(template cue.Value) ([]TemplateProblem, error) {
+	var resources map[string]struct {
+		Type       string
+		Properties interface{}
+	}
+	if decodeErr := template.Lookup("Resources").Decode(&resources); decodeErr != nil {
+		return nil, decodeErr
+	}
+
+	names := make([]string, 0, len(resources))
+	for name := range resources {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	c := &propertyChecker{spec: s, template: template}
+	for _, name := range names {
+		resource := resources[name]
+		resourceSpec, ok := s.ResourceTypes[resource.Type]
+		if !ok {
+			continue
+		}
+		path := []string{"Resources", name, "Properties"}
+		if resource.Properties == nil {
+			c.checkRequired(path[:2], resourceSpec.Properties, nil)
+			continue
+		}
+		c.checkProperties(path, resource.Type, resourceSpec.Properties, resource.Properties)
+	}
+	return c.problems, nil
+}
+
+// problem records a problem at the path, with the position of the closest value in the CUE that exists
+func (c *propertyChecker) problem(path []string, format string, args ...interface{}) {
+	displayPath := ""
+	for _, label := range path {
+		if _, err := strconv.Atoi(label); err == nil {
+			displayPath += "[" + label + "]"
+		} else if displayPath == "" {
+			displayPath = label
+		} else {
+			displayPath += "." + label
+		}
+	}
+	c.problems = append(c.problems, TemplateProblem{
+		Path:    displayPath,
+		Message: fmt.Sprintf(format, args...),
+		Pos:     valuePos(c.template, path),
+	})
+}
+
+// checkProperties checks a map of properties against the properties of a resource or property type
+func (c *propertyChecker) checkProperties(path []string, resourceType string, specs map[string]PropertySpec, value interface{}) {
+	if isIntrinsic(value) {
+		return
+	}
+	properties, ok := value.(map[string]interface{})
+	if !ok {
+		c.problem(path, "expected a map of properties, not %s", describeValue(value))
+		return
+	}
+	c.checkRequired(path, specs, properties)
+
+	names := make([]string, 0, len(properties))
+	for name := range properties {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		propertyPath := append(append([]string{}, path...), name)
+		spec, ok := specs[name]
+		if !ok {
+			message := "unknown property " + name
+			if suggestion := closestName(name, specs); suggestion != "" {
+				message += "; did you mean " + suggestion + "?"
+			}
+			c.problem(propertyPath, message)
+			continue
+		}
+		c.checkValue(propertyPath, resourceType, spec, properties[name])
+	}
+}
+
+// checkRequired reports required properties that are missing
+func (c *propertyChecker) checkRequired(path []string, specs map[string]PropertySpec, properties map[string]interface{}) {
+	var missing []string
+	for name, spec := range specs {
+		if _, ok := properties[name]; spec.Required && !ok {
+			missing = append(missing, name)
+		}
+	}
+	sort.Strings(missing)
+	for _, name := range missing {
+		c.problem(path, "missing required property %s", name)
+	}
+}
+
+// checkValue checks a property value against its specification
+func (c *propertyChecker) checkValue(path []string, resourceType string, spec PropertySpec, value interface{}) {
+	if isIntrinsic(value) {
+		return
+	}
+	switch {
+	case spec.PrimitiveType != "":
+		if !primitiveMatches(spec.PrimitiveType, value) {
+			c.problem(path, "expected %s, not %s", spec.PrimitiveType, describeValue(value))
+		}
+	case spec.Type == "List":
+		items, ok := value.([]interface{})
+		if !ok {
+			c.problem(path, "expected a list, not %s", describeValue(value))
+			return
+		}
+		for i, item := range items {
+			c.checkItem(append(append([]string{}, path...), strconv.Itoa(i)), resourceType, spec, item)
+		}
+	case spec.Type == "Map":
+		items, ok := value.(map[string]interface{})
+		if !ok {
+			c.problem(path, "expected a map, not %s", describeValue(value))
+			return
+		}
+		keys := make([]string, 0, len(items))
+		for key := range items {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			c.checkItem(append(append([]string{}, path...), key), resourceType, spec, items[key])
+		}
+	case spec.Type != "":
+		c.checkPropertyType(path, resourceType, spec.Type, value)
+	}
+}
+
+// checkItem checks an item of a List or Map property
+func (c *propertyChecker) checkItem(path []string, resourceType string, spec PropertySpec, item interface{}) {
+	if isIntrinsic(item) {
+		return
+	}
+	if spec.PrimitiveItemType != "" {
+		if !primitiveMatches(spec.PrimitiveItemType, item) {
+			c.problem(path, "expected %s, not %s", spec.PrimitiveItemType, describeValue(item))
+		}
+		return
+	}
+	if spec.ItemType != "" {
+		c.checkPropertyType(path, resourceType, spec.ItemType, item)
+	}
+}
+
+// checkPropertyType checks a value against a property type, which is named <resource type>.<name>, except for the shared Tag
+func (c *propertyChecker) checkPropertyType(path []string, resourceType, typeName string, value interface{}) {
+	propertyType, ok := c.spec.PropertyTypes[resourceType+"."+typeName]
+	if !ok {
+		propertyType, ok = c.spec.PropertyTypes[typeName]
+	}
+	if !ok {
+		return
+	}
+	if propertyType.PrimitiveType != "" {
+		c.checkValue(path, resourceType, PropertySpec{PrimitiveType: propertyType.PrimitiveType}, value)
+		return
+	}
+	if propertyType.Type != "" {
+		c.checkValue(path, resourceType, PropertySpec{Type: propertyType.Type, ItemType: propertyType.ItemType, PrimitiveItemType: propertyType.PrimitiveItemType}, value)
+		return
+	}
+	c.checkProperties(path, resourceType, propertyType.Properties, value)
+}
+
+// isIntrinsic returns true for Ref, Fn::*, and Condition, whose values are only known once CloudFormation resolves them
+func isIntrinsic(value interface{}) bool {
+	m, ok := value.(map[string]interface{})
+	if !ok || len(m) != 1 {
+		return false
+	}
+	for key := range m {
+		return key == "Ref" || key == "Condition" || strings.HasPrefix(key, "Fn::")
+	}
+	return false
+}
+
+// primitiveMatches returns true if CloudFormation accepts value for the primitive type. Scalars are converted as CloudFormation converts them,
+// so numbers and booleans are valid strings, and numeric strings are valid numbers.
+func primitiveMatches(primitiveType string, value interface{}) bool {
+	switch primitiveType {
+	case "String", "Timestamp":
+		switch value.(type) {
+		case string, float64, bool:
+			return true
+		}
+	case "Integer", "Long":
+		switch v := value.(type) {
+		case float64:
+			return v == float64(int64(v))
+		case string:
+			_, err := strconv.ParseInt(v, 10, 64)
+			return err == nil
+		}
+	case "Double":
+		switch v := value.(type) {
+		case float64:
+			return true
+		case string:
+			_, err := strconv.ParseFloat(v, 64)
+			return err == nil
+		}
+	case "Boolean":
+		switch v := value.(type) {
+		case bool:
+			return true
+		case string:
+			return v == "true" || v == "false"
+		}
+	case "Json":
+		switch value.(type) {
+		case map[string]interface{}, string:
+			return true
+		}
+	default:
+		return true
+	}
+	return false
+}
+
+// describeValue names the kind of a decoded value for messages
+func describeValue(value interface{}) string {
+	switch v := value.(type) {
+	case nil:
+		return "null"
+	case map[string]interface{}:
+		return "a map"
+	case []interface{}:
+		return "a list"
+	case string:
+		return strconv.Quote(v)
+	default:
+		return fmt.Sprint(v)
+	}
+}
+
+// closestName returns the property name nearest to name, if it is close enough to be a typo
+func closestName(name string, specs map[string]PropertySpec) string {
+	best, bestDistance := "", len(name)/3+1
+	for candidate := range specs {
+		if distance := editDistance(strings.ToLower(name), strings.ToLower(candidate)); distance < bestDistance || (distance == bestDistance && candidate < best) {
+			best, bestDistance = candidate, distance
+		}
+	}
+	return best
+}
+
+// editDistance is the Levenshtein distance between a and b, counting a swap of adjacent letters as one edit
+func editDistance(a, b string) int {
+	d := make([][]int, len(a)+1)
+	for i := range d {
+		d[i] = make([]int, len(b)+1)
+		d[i][0] = i
+	}
+	for j := range d[0] {
+		d[0][j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			d[i][j] = min(d[i-1][j]+1, d[i][j-1]+1, d[i-1][j-1]+cost)
+			if i > 1 && j > 1 && a[i-1] == b[j-2] && a[i-2] == b[j-1] {
+				d[i][j] = min(d[i][j], d[i-2][j-2]+1)
+			}
+		}
+	}
+	return d[len(a)][len(b)]
+}
+
+func min(values ...int) int {
+	m := values[0]
+	for _, v := range values[1:] {
+		if v < m {
+			m = v
+		}
+	}
+	return m
+}
+
+// valuePos returns the position of the value at path, or of its closest parent that exists. Numeric labels index into lists.
+func valuePos(v cue.Value, path []string) token.Pos {
+	pos := v.Pos()
+	for _, label := range path {
+		next, found := cue.Value{}, false
+		if index, err := strconv.Atoi(label); err == nil {
+			if items, listErr := v.List(); listErr == nil {
+				for i := 0; items.Next(); i++ {
+					if i == index {
+						next, found = items.Value(), true
+						break
+					}
+				}
+			}
+		} else if field := v.Lookup(label); field.Exists() {
+			next, found = field, true
+		}
+		if !found {
+			break
+		}
+		v = next
+		if p := v.Pos(); p.IsValid() {
+			pos = p
+		}
+	}
+	return pos
+}
@@ -0,0 +1,182 @@
+package stx
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"cuelang.org/go/cue"
+)
+
+// compileTemplate compiles CUE source for a template, failing the test if it does not compile
+func compileTemplate(t *testing.T, source string) cue.Value {
+	t.Helper()
+	var runtime cue.Runtime
+	instance, compileErr := runtime.Compile("template.cue", source)
+	if compileErr != nil {
+		t.Fatal(compileErr)
+	}
+	return instance.Value()
+}
+
+func TestCheckResources(t *testing.T) {
+	spec, loadErr := LoadResourceSpec("testdata/resourcespec.json")
+	if loadErr != nil {
+		t.Fatal(loadErr)
+	}
+
+	tests := []struct {
+		name, resources string
+		want            []string
+	}{
+		{
+			name: "valid",
+			resources: `
+Bucket: {Type: "AWS::S3::Bucket", Properties: {BucketName: "b", Tags: [{Key: "a", Value: "b"}], VersioningConfiguration: Status: "Enabled"}}
+Function: {Type: "AWS::Lambda::Function", Properties: {Code: ZipFile: "x", Role: "r", MemorySize: 128, Layers: ["a"], Environment: Variables: {A: "1", B: 2}}}
+`,
+		},
+		{
+			name: "missing required properties",
+			resources: `
+Subscription: {Type: "AWS::SNS::Subscription", Properties: {Endpoint: "e"}}
+NoProperties: {Type: "AWS::SNS::Subscription"}
+Versioned: {Type: "AWS::S3::Bucket", Properties: VersioningConfiguration: {}}
+`,
+			want: []string{
+				"Resources.NoProperties: missing required property Protocol",
+				"Resources.NoProperties: missing required property TopicArn",
+				"Resources.Subscription.Properties: missing required property Protocol",
+				"Resources.Subscription.Properties: missing required property TopicArn",
+				"Resources.Versioned.Properties.VersioningConfiguration: missing required property Status",
+			},
+		},
+		{
+			name: "typo",
+			resources: `
+Bucket: {Type: "AWS::S3::Bucket", Properties: {BucketNmae: "b", Unrelated: "x"}}
+`,
+			want: []string{
+				"Resources.Bucket.Properties.BucketNmae: unknown property BucketNmae; did you mean BucketName?",
+				"Resources.Bucket.Properties.Unrelated: unknown property Unrelated",
+			},
+		},
+		{
+			name: "list and map shapes",
+			resources: `
+Bucket: {Type: "AWS::S3::Bucket", Properties: {Tags: {Key: "a", Value: "b"}, VersioningConfiguration: ["Enabled"]}}
+Function: {Type: "AWS::Lambda::Function", Properties: {Code: ZipFile: "x", Role: "r", Layers: "a", Environment: Variables: ["a"]}}
+Tagged: {Type: "AWS::S3::Bucket", Properties: Tags: [{Key: "a"}, "b"]}
+`,
+			want: []string{
+				"Resources.Bucket.Properties.Tags: expected a list, not a map",
+				"Resources.Bucket.Properties.VersioningConfiguration: expected a map of properties, not a list",
+				"Resources.Function.Properties.Environment.Variables: expected a map, not a list",
+				"Resources.Function.Properties.Layers: expected a list, not \"a\"",
+				"Resources.Tagged.Properties.Tags[0]: missing required property Value",
+				"Resources.Tagged.Properties.Tags[1]: expected a map of properties, not \"b\"",
+			},
+		},
+		{
+			name: "primitive types",
+			resources: `
+Function: {Type: "AWS::Lambda::Function", Properties: {Code: ZipFile: "x", Role: ["r"], MemorySize: 1.5, Environment: Variables: {A: {B: "c"}}}}
+Sized: {Type: "AWS::Lambda::Function", Properties: {Code: ZipFile: "x", Role: "r", MemorySize: "128"}}
+`,
+			want: []string{
+				"Resources.Function.Properties.Environment.Variables.A: expected String, not a map",
+				"Resources.Function.Properties.MemorySize: expected Integer, not 1.5",
+				"Resources.Function.Properties.Role: expected String, not a list",
+			},
+		},
+		{
+			name: "intrinsic functions",
+			resources: `
+Bucket: {Type: "AWS::S3::Bucket", Properties: {BucketName: {"Fn::Sub": "${AWS::StackName}"}, Tags: {"Fn::If": ["c", [], []]}, VersioningConfiguration: {Ref: "AWS::NoValue"}}}
+Function: {Type: "AWS::Lambda::Function", Properties: {Code: {"Fn::GetAtt": ["a", "b"]}, Role: {Ref: "Role"}, Layers: [{Ref: "Layer"}]}}
+`,
+		},
+		{
+			name: "unknown resource types",
+			resources: `
+Custom: {Type: "Custom::Thing", Properties: {Anything: ["goes"]}}
+`,
+		},
+	}
+	for _, test := range tests {
+		problems, checkErr := spec.CheckResources(compileTemplate(t, "Resources: {"+test.resources+"}"))
+		if checkErr != nil {
+			t.Errorf("%s: %s", test.name, checkErr)
+			continue
+		}
+		if got := problemStrings(problems); !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%s: got\n  %s\nwant\n  %s", test.name, strings.Join(got, "\n  "), strings.Join(test.want, "\n  "))
+		}
+	}
+}
+
+func TestCheckResourcesPositions(t *testing.T) {
+	spec, loadErr := LoadResourceSpec("testdata/resourcespec.json")
+	if loadErr != nil {
+		t.Fatal(loadErr)
+	}
+
+	template := compileTemplate(t, `Resources: {
+	Bucket: {
+		Type: "AWS::S3::Bucket"
+		Properties: {
+			BucketNmae: "b"
+			Tags: [
+				{Key: "a", Value: "b"},
+				{Key: "c"},
+			]
+		}
+	}
+	Subscription: {
+		Type: "AWS::SNS::Subscription"
+	}
+}
+`)
+	problems, checkErr := spec.CheckResources(template)
+	if checkErr != nil {
+		t.Fatal(checkErr)
+	}
+
+	// problems point at the CUE that declares the value, or at its closest parent
+	want := map[string]int{
+		"Resources.Bucket.Properties.BucketNmae": 5,
+		"Resources.Bucket.Properties.Tags[1]":    8,
+		"Resources.Subscription":                 12,
+	}
+	got := make(map[string]int)
+	for _, problem := range problems {
+		if !problem.Pos.IsValid() {
+			t.Errorf("%s has no position", problem.Path)
+			continue
+		}
+		if problem.Pos.Filename() != "template.cue" {
+			t.Errorf("%s is in %s, not template.cue", problem.Path, problem.Pos.Filename())
+		}
+		got[problem.Path] = problem.Pos.Line()
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got lines %v, want %v", got, want)
+	}
+}
+
+func TestLoadResourceSpec(t *testing.T) {
+	if _, loadErr := LoadResourceSpec("testdata/missing.json"); loadErr == nil {
+		t.Error("expected an error for a missing file")
+	}
+	if _, loadErr := LoadResourceSpec("testdata/../validate_test.go"); loadErr == nil {
+		t.Error("expected an error for a file that is not json")
+	}
+
+	spec, loadErr := LoadResourceSpec("testdata/resourcespec.json")
+	if loadErr != nil {
+		t.Fatal(loadErr)
+	}
+	if again, _ := LoadResourceSpec("testdata/resourcespec.json"); again != spec {
+		t.Error("expected the specification to be cached")
+	}
+}
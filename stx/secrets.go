@@ -1,17 +1,73 @@
 package stx
 
 import (
+	"fmt"
 	"os"
+	"sync"
 
+	"go.mozilla.org/sops/v3"
+	"go.mozilla.org/sops/v3/aes"
+	"go.mozilla.org/sops/v3/cmd/sops/common"
 	"go.mozilla.org/sops/v3/decrypt"
+	"go.mozilla.org/sops/v3/keyservice"
+	"go.mozilla.org/sops/v3/kms"
+	sopsyaml "go.mozilla.org/sops/v3/stores/yaml"
+	"go.mozilla.org/sops/v3/version"
 )
 
+// decryptMu guards the AWS environment variables set for sops while stacks are deployed in parallel
+var decryptMu sync.Mutex
+
 // DecryptSecrets uses sops to decrypt the file with credentials from the given profile
 func DecryptSecrets(file, profile string) ([]byte, error) {
-	credentials := GetProfileCredentials(profile)
+	credentials, credentialsErr := GetProfileCredentials(profile)
+	if credentialsErr != nil {
+		return nil, credentialsErr
+	}
+	decryptMu.Lock()
+	defer decryptMu.Unlock()
 	// set ENV vars (primarily for sops decrypt)
 	os.Setenv("AWS_ACCESS_KEY_ID", credentials.AccessKeyID)
 	os.Setenv("AWS_SECRET_ACCESS_KEY", credentials.SecretAccessKey)
 	os.Setenv("AWS_SESSION_TOKEN", credentials.SessionToken)
 	return decrypt.File(file, "yaml")
 }
+
+// EncryptSecrets uses sops to encrypt yaml with the KMS key, using credentials from the given profile
+func EncryptSecrets(plain []byte, kmsArn, profile string) ([]byte, error) {
+	credentials, credentialsErr := GetProfileCredentials(profile)
+	if credentialsErr != nil {
+		return nil, credentialsErr
+	}
+	decryptMu.Lock()
+	defer decryptMu.Unlock()
+	// set ENV vars for the sops kms key source
+	os.Setenv("AWS_ACCESS_KEY_ID", credentials.AccessKeyID)
+	os.Setenv("AWS_SECRET_ACCESS_KEY", credentials.SecretAccessKey)
+	os.Setenv("AWS_SESSION_TOKEN", credentials.SessionToken)
+
+	store := sopsyaml.Store{}
+	branches, branchesErr := store.LoadPlainFile(plain)
+	if branchesErr != nil {
+		return nil, branchesErr
+	}
+
+	tree := sops.Tree{
+		Branches: branches,
+		Metadata: sops.Metadata{
+			KeyGroups:         []sops.KeyGroup{{kms.NewMasterKeyFromArn(kmsArn, nil, "")}},
+			UnencryptedSuffix: "_unencrypted",
+			Version:           version.Version,
+		},
+	}
+	dataKey, dataKeyErrs := tree.GenerateDataKeyWithKeyServices([]keyservice.KeyServiceClient{keyservice.NewLocalClient()})
+	if len(dataKeyErrs) > 0 {
+		return nil, fmt.Errorf("Could not generate data key: %s", dataKeyErrs)
+	}
+
+	encryptErr := common.EncryptTree(common.EncryptTreeOpts{DataKey: dataKey, Tree: &tree, Cipher: aes.NewCipher()})
+	if encryptErr != nil {
+		return nil, encryptErr
+	}
+	return store.EmitEncryptedFile(tree)
+}
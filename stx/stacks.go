@@ -12,13 +12,58 @@ import (
 // Stack represents the decoded value of stacks[stackname]
 type Stack struct {
 	Name, Profile, Region, Environment, RegionCode string
-	Overrides                                      map[string]struct {
-		SopsProfile string
-		Map         map[string]string
-	}
-	DependsOn   []string
-	Tags        map[string]string
-	TagsEnabled bool
+	RoleArn, AssumeRoleArn, AccountId              string
+	TemplateBucket                                 string
+	Overrides                                      map[string]Override
+	DependsOn                                      []string
+	Tags                                           map[string]string
+	TagsEnabled                                    bool
+	StackPolicy                                    map[string]interface{}
+	EnableTerminationProtection                    *bool
+	RollbackConfiguration                          *RollbackConfiguration
+	TimeoutInMinutes                               int64
+	NotificationARNs                               []string
+	Import                                         map[string]interface{}
+	StackSet                                       *StackSet
+}
+
+// StackSet makes the stack a StackSet, deployed to every target account or organizational unit in every region.
+// The stack's Profile and Region locate the administrator account that owns it.
+type StackSet struct {
+	PermissionModel                          string
+	AdministrationRoleARN, ExecutionRoleName string
+	Accounts, OrganizationalUnitIds, Regions []string
+	AutoDeployment                           *StackSetAutoDeployment
+	OperationPreferences                     *StackSetOperationPreferences
+}
+
+// StackSetAutoDeployment deploys SERVICE_MANAGED stack sets to accounts as they join a target organizational unit
+type StackSetAutoDeployment struct {
+	Enabled, RetainStacksOnAccountRemoval bool
+}
+
+// StackSetOperationPreferences controls how many instances a stack set operation changes at once, and how many may fail
+type StackSetOperationPreferences struct {
+	FailureToleranceCount, FailureTolerancePercentage int64
+	MaxConcurrentCount, MaxConcurrentPercentage       int64
+	RegionOrder                                       []string
+}
+
+// RollbackConfiguration lists the alarms CloudFormation monitors during and after a stack operation
+type RollbackConfiguration struct {
+	MonitoringTimeInMinutes int64
+	RollbackTriggers        []RollbackTrigger
+}
+
+// RollbackTrigger is an alarm that rolls the stack back when it goes into ALARM
+type RollbackTrigger struct {
+	Arn, Type string
+}
+
+// Override describes how an overrides file supplies values for Template.Parameters
+type Override struct {
+	SopsProfile string
+	Map         map[string]string
 }
 
 // StacksIterator is a wrapper around cue.Iterator that allows for filtering based on stack fields
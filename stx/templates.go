@@ -0,0 +1,85 @@
+package stx
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"fmt"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3iface"
+)
+
+// MaxTemplateBodySize is the largest template CloudFormation accepts as TemplateBody. Larger templates must be uploaded to S3.
+const MaxTemplateBodySize = 51200
+
+// NewS3Client returns the client used to upload templates and artifacts
+func NewS3Client(sess *session.Session, config *aws.Config) s3iface.S3API {
+	return s3.New(sess, config)
+}
+
+// S3Location is where an upload was stored
+type S3Location struct {
+	Bucket, Key, URL string
+}
+
+// S3Uploader stores objects in Bucket under keys derived from their sha1, so unchanged content is only uploaded once.
+// With DryRun, it only returns where each object would be stored, without calling S3. Object URLs are built from the
+// Client's requests, so a stand-in Client that builds requests against its own endpoint gets URLs on that endpoint.
+type S3Uploader struct {
+	Client s3iface.S3API
+	Bucket string
+	DryRun bool
+}
+
+// Upload stores body as stx/<sha1>.<extension> unless an object with that key already exists
+func (u *S3Uploader) Upload(body []byte, extension string) (S3Location, error) {
+	key := fmt.Sprintf("stx/%x.%s", sha1.Sum(body), extension)
+	objectURL, urlErr := u.objectURL(key)
+	if urlErr != nil {
+		return S3Location{}, urlErr
+	}
+	location := S3Location{Bucket: u.Bucket, Key: key, URL: objectURL}
+	if u.DryRun {
+		return location, nil
+	}
+
+	_, headErr := u.Client.HeadObject(&s3.HeadObjectInput{Bucket: aws.String(u.Bucket), Key: aws.String(key)})
+	if headErr == nil {
+		return location, nil
+	}
+
+	_, putErr := u.Client.PutObject(&s3.PutObjectInput{
+		Bucket: aws.String(u.Bucket),
+		Key:    aws.String(key),
+		Body:   bytes.NewReader(body),
+	})
+	if putErr != nil {
+		return location, fmt.Errorf("Unable to upload to s3://%s/%s: %s", u.Bucket, key, putErr)
+	}
+	return location, nil
+}
+
+// objectURL returns the https URL of the key as the client addresses it, honoring the client's endpoint and path style settings
+func (u *S3Uploader) objectURL(key string) (string, error) {
+	request, _ := u.Client.GetObjectRequest(&s3.GetObjectInput{Bucket: aws.String(u.Bucket), Key: aws.String(key)})
+	if buildErr := request.Build(); buildErr != nil {
+		return "", fmt.Errorf("Unable to build the URL of s3://%s/%s: %s", u.Bucket, key, buildErr)
+	}
+	return request.HTTPRequest.URL.String(), nil
+}
+
+// UploadTemplate returns the TemplateURL to deploy the template from. Without a bucket, it returns an empty URL so the template
+// is sent as TemplateBody, or an error if the template is larger than MaxTemplateBodySize. With a bucket, the template is stored
+// under a key derived from its sha1, and templates that were already uploaded are not uploaded again.
+func UploadTemplate(client s3iface.S3API, bucket string, template []byte) (string, error) {
+	if bucket == "" {
+		if len(template) > MaxTemplateBodySize {
+			return "", fmt.Errorf("Template is %d bytes, which is more than the %d bytes CloudFormation accepts without S3. Set Cmd.Deploy.TemplateBucket in config.stx.cue or TemplateBucket on the stack", len(template), MaxTemplateBodySize)
+		}
+		return "", nil
+	}
+	location, err := (&S3Uploader{Client: client, Bucket: bucket}).Upload(template, "cfn.yml")
+	return location.URL, err
+}
@@ -0,0 +1,128 @@
+package stx
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"fmt"
+	"io/ioutil"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3iface"
+)
+
+// fakeS3 keeps objects in memory. Requests it does not fake, such as the one objectURL builds, go to the embedded client,
+// which is configured for a local endpoint and never sends anything.
+type fakeS3 struct {
+	s3iface.S3API
+	objects map[string][]byte
+	puts    []string
+}
+
+func (f *fakeS3) HeadObject(input *s3.HeadObjectInput) (*s3.HeadObjectOutput, error) {
+	if _, ok := f.objects[aws.StringValue(input.Bucket)+"/"+aws.StringValue(input.Key)]; ok {
+		return &s3.HeadObjectOutput{}, nil
+	}
+	return nil, awserr.New("NotFound", "Not Found", nil)
+}
+
+func (f *fakeS3) PutObject(input *s3.PutObjectInput) (*s3.PutObjectOutput, error) {
+	body, readErr := ioutil.ReadAll(input.Body)
+	if readErr != nil {
+		return nil, readErr
+	}
+	key := aws.StringValue(input.Bucket) + "/" + aws.StringValue(input.Key)
+	f.objects[key] = body
+	f.puts = append(f.puts, key)
+	return &s3.PutObjectOutput{}, nil
+}
+
+// newFakeS3 returns a stand-in for S3 whose requests are built against a local endpoint
+func newFakeS3(t *testing.T) *fakeS3 {
+	sess, sessErr := session.NewSession(aws.NewConfig().WithCredentials(credentials.NewStaticCredentials("id", "secret", "")))
+	if sessErr != nil {
+		t.Fatal(sessErr)
+	}
+	return &fakeS3{
+		S3API:   s3.New(sess, aws.NewConfig().WithRegion("us-west-2").WithEndpoint("http://localhost:4566").WithS3ForcePathStyle(true)),
+		objects: make(map[string][]byte),
+	}
+}
+
+func TestUploadTemplateWithoutBucket(t *testing.T) {
+	fake := newFakeS3(t)
+
+	templateURL, err := UploadTemplate(fake, "", bytes.Repeat([]byte("a"), MaxTemplateBodySize))
+	if err != nil || templateURL != "" {
+		t.Errorf("template of MaxTemplateBodySize: got %q, %v; want it sent as TemplateBody", templateURL, err)
+	}
+
+	_, err = UploadTemplate(fake, "", bytes.Repeat([]byte("a"), MaxTemplateBodySize+1))
+	if err == nil || !strings.Contains(err.Error(), "TemplateBucket") {
+		t.Errorf("template larger than MaxTemplateBodySize: got %v; want an error naming TemplateBucket", err)
+	}
+
+	if puts := fake.puts; len(puts) > 0 {
+		t.Errorf("uploaded %v without a bucket", puts)
+	}
+}
+
+func TestUploadTemplate(t *testing.T) {
+	fake := newFakeS3(t)
+	template := bytes.Repeat([]byte("b"), MaxTemplateBodySize+1)
+	key := fmt.Sprintf("stx/%x.cfn.yml", sha1.Sum(template))
+
+	templateURL, err := UploadTemplate(fake, "templates", template)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "http://localhost:4566/templates/" + key; templateURL != want {
+		t.Errorf("got TemplateURL %q, want %q", templateURL, want)
+	}
+	if len(fake.puts) != 1 || fake.puts[0] != "templates/"+key {
+		t.Errorf("got uploads %v, want templates/%s", fake.puts, key)
+	}
+	if !bytes.Equal(fake.objects["templates/"+key], template) {
+		t.Error("uploaded body differs from the template")
+	}
+}
+
+func TestUploadTemplateExists(t *testing.T) {
+	fake := newFakeS3(t)
+	template := []byte("Resources: {}\n")
+	fake.objects["templates/"+fmt.Sprintf("stx/%x.cfn.yml", sha1.Sum(template))] = template
+
+	templateURL, err := UploadTemplate(fake, "templates", template)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if templateURL == "" {
+		t.Error("got no TemplateURL for a template that already exists")
+	}
+	if len(fake.puts) > 0 {
+		t.Errorf("uploaded %v, which already exists", fake.puts)
+	}
+}
+
+func TestS3UploaderDryRun(t *testing.T) {
+	fake := newFakeS3(t)
+	body := []byte("exports.handler = () => {}\n")
+	key := fmt.Sprintf("stx/%x.zip", sha1.Sum(body))
+
+	location, err := (&S3Uploader{Client: fake, Bucket: "artifacts", DryRun: true}).Upload(body, "zip")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := S3Location{Bucket: "artifacts", Key: key, URL: "http://localhost:4566/artifacts/" + key}
+	if location != want {
+		t.Errorf("got %+v, want %+v", location, want)
+	}
+	if len(fake.puts) > 0 {
+		t.Errorf("uploaded %v in a dry run", fake.puts)
+	}
+}
@@ -0,0 +1,262 @@
+package stx
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+
+	"cuelang.org/go/cue/token"
+	"gopkg.in/yaml.v2"
+)
+
+// Template limits, from https://docs.aws.amazon.com/AWSCloudFormation/latest/UserGuide/cloudformation-limits.html
+const (
+	MaxTemplateURLSize = 1048576 // templates uploaded to S3
+	MaxResources       = 500
+	MaxOutputs         = 200
+	MaxParameters      = 200
+)
+
+// pseudoParameters may be referenced without being declared
+var pseudoParameters = map[string]bool{
+	"AWS::AccountId":        true,
+	"AWS::NotificationARNs": true,
+	"AWS::NoValue":          true,
+	"AWS::Partition":        true,
+	"AWS::Region":           true,
+	"AWS::StackId":          true,
+	"AWS::StackName":        true,
+	"AWS::URLSuffix":        true,
+}
+
+// subVariable matches ${Name} and ${Name.Attribute} in Fn::Sub strings, but not the ${!Literal} escape
+var subVariable = regexp.MustCompile(`\$\{([^!}][^}]*)\}`)
+
+// TemplateProblem is something in a template that CloudFormation would reject, or that is almost certainly a mistake.
+// Pos is the position of the CUE that declares it, when known.
+type TemplateProblem struct {
+	Path, Message string
+	Pos           token.Pos
+}
+
+// templateValidator collects problems while walking a template
+type templateValidator struct {
+	parameters, resources, conditions map[string]bool
+	usedConditions                    map[string]bool
+	checkReferences                   bool
+	problems                          []TemplateProblem
+}
+
+// ValidateTemplate checks a yaml or json template without calling AWS. Ref, Fn::GetAtt, and Fn::Sub targets must exist,
+// DependsOn must name resources, every condition must be used, and the template must be within CloudFormation's limits.
+// maxSize is MaxTemplateBodySize, or MaxTemplateURLSize when the template is uploaded to S3.
+func ValidateTemplate(template []byte, maxSize int) ([]TemplateProblem, error) {
+	var root map[interface{}]interface{}
+	if unmarshalErr := yaml.Unmarshal(template, &root); unmarshalErr != nil {
+		return nil, unmarshalErr
+	}
+
+	v := &templateValidator{
+		parameters:     sectionNames(root, "Parameters"),
+		resources:      sectionNames(root, "Resources"),
+		conditions:     sectionNames(root, "Conditions"),
+		usedConditions: make(map[string]bool),
+		// transforms such as AWS::Serverless add resources that are not in the template
+		checkReferences: root["Transform"] == nil,
+	}
+
+	if len(template) > maxSize {
+		v.problem("", "template is %d bytes, more than the limit of %d", len(template), maxSize)
+	}
+	if len(v.resources) < 1 {
+		v.problem("Resources", "template has no resources")
+	}
+	for _, limit := range []struct {
+		section string
+		max     int
+	}{{"Resources", MaxResources}, {"Outputs", MaxOutputs}, {"Parameters", MaxParameters}} {
+		if count := len(sectionNames(root, limit.section)); count > limit.max {
+			v.problem(limit.section, "template has %d %s, more than the limit of %d", count, strings.ToLower(limit.section), limit.max)
+		}
+	}
+
+	resources, _ := root["Resources"].(map[interface{}]interface{})
+	for _, name := range sortedKeys(resources) {
+		resource, _ := resources[name].(map[interface{}]interface{})
+		v.checkDependsOn("Resources."+name, resource["DependsOn"])
+	}
+
+	for _, section := range []string{"Conditions", "Resources", "Outputs"} {
+		v.walk(root[section], section)
+	}
+
+	for _, name := range sortedKeys(root["Conditions"]) {
+		if !v.usedConditions[name] {
+			v.problem("Conditions."+name, "condition %s is never used", name)
+		}
+	}
+
+	return v.problems, nil
+}
+
+// problem records a problem at path
+func (v *templateValidator) problem(path, format string, args ...interface{}) {
+	v.problems = append(v.problems, TemplateProblem{Path: path, Message: fmt.Sprintf(format, args...)})
+}
+
+// walk visits every value below node, checking intrinsic functions and condition names
+func (v *templateValidator) walk(node interface{}, path string) {
+	switch value := node.(type) {
+	case map[interface{}]interface{}:
+		for _, key := range sortedKeys(value) {
+			child := value[key]
+			childPath := path + "." + key
+			switch key {
+			case "Ref":
+				v.checkRef(childPath, child)
+			case "Fn::GetAtt":
+				v.checkGetAtt(childPath, child)
+			case "Fn::Sub":
+				v.checkSub(childPath, child)
+			case "Fn::If":
+				if args, ok := child.([]interface{}); ok && len(args) > 0 {
+					v.useCondition(childPath, args[0])
+				}
+			case "Condition":
+				// a resource or output condition, or a condition used inside another condition; policy documents also use Condition, but as a map
+				if _, ok := child.(string); ok {
+					v.useCondition(childPath, child)
+				}
+			}
+			v.walk(child, childPath)
+		}
+	case []interface{}:
+		for i, item := range value {
+			v.walk(item, fmt.Sprintf("%s[%d]", path, i))
+		}
+	}
+}
+
+// checkRef reports a Ref to something that is neither a parameter, a resource, nor a pseudo parameter
+func (v *templateValidator) checkRef(path string, target interface{}) {
+	name, ok := target.(string)
+	if !ok {
+		v.problem(path, "Ref must be a string")
+		return
+	}
+	if v.checkReferences && !v.parameters[name] && !v.resources[name] && !pseudoParameters[name] {
+		v.problem(path, "Ref to %s, which is not a parameter or resource", name)
+	}
+}
+
+// checkGetAtt reports a Fn::GetAtt of something that is not a resource
+func (v *templateValidator) checkGetAtt(path string, args interface{}) {
+	var name string
+	switch value := args.(type) {
+	case string:
+		name = strings.SplitN(value, ".", 2)[0]
+	case []interface{}:
+		if len(value) != 2 {
+			v.problem(path, "Fn::GetAtt takes a resource and an attribute")
+			return
+		}
+		name, _ = value[0].(string)
+	}
+	if name == "" {
+		v.problem(path, "Fn::GetAtt must name a resource")
+		return
+	}
+	if v.checkReferences && !v.resources[name] {
+		v.problem(path, "Fn::GetAtt of %s, which is not a resource", name)
+	}
+}
+
+// checkSub reports ${Name} and ${Name.Attribute} variables that are neither declared in the Fn::Sub map nor in the template
+func (v *templateValidator) checkSub(path string, args interface{}) {
+	var text string
+	variables := make(map[string]bool)
+	switch value := args.(type) {
+	case string:
+		text = value
+	case []interface{}:
+		if len(value) > 0 {
+			text, _ = value[0].(string)
+		}
+		if len(value) > 1 {
+			for _, name := range sortedKeys(value[1]) {
+				variables[name] = true
+			}
+		}
+	}
+	if !v.checkReferences {
+		return
+	}
+
+	for _, match := range subVariable.FindAllStringSubmatch(text, -1) {
+		name := strings.TrimSpace(match[1])
+		if variables[name] || v.parameters[name] || v.resources[name] || pseudoParameters[name] {
+			continue
+		}
+		if parts := strings.SplitN(name, ".", 2); len(parts) == 2 {
+			if !v.resources[parts[0]] {
+				v.problem(path, "Fn::Sub uses ${%s}, but %s is not a resource", name, parts[0])
+			}
+			continue
+		}
+		v.problem(path, "Fn::Sub uses ${%s}, which is not a parameter, resource, or variable", name)
+	}
+}
+
+// checkDependsOn reports DependsOn entries that are not resources
+func (v *templateValidator) checkDependsOn(path string, dependsOn interface{}) {
+	var names []interface{}
+	switch value := dependsOn.(type) {
+	case nil:
+		return
+	case string:
+		names = []interface{}{value}
+	case []interface{}:
+		names = value
+	}
+	for _, item := range names {
+		name, ok := item.(string)
+		if !ok || !v.resources[name] {
+			v.problem(path+".DependsOn", "DependsOn %v, which is not a resource", item)
+		}
+	}
+}
+
+// useCondition marks a condition as used, reporting conditions that are not declared
+func (v *templateValidator) useCondition(path string, condition interface{}) {
+	name, ok := condition.(string)
+	if !ok {
+		v.problem(path, "condition name must be a string")
+		return
+	}
+	if !v.conditions[name] {
+		v.problem(path, "condition %s is not declared in Conditions", name)
+		return
+	}
+	v.usedConditions[name] = true
+}
+
+// sectionNames returns the names declared in a top level section such as Resources
+func sectionNames(root map[interface{}]interface{}, section string) map[string]bool {
+	names := make(map[string]bool)
+	for _, name := range sortedKeys(root[section]) {
+		names[name] = true
+	}
+	return names
+}
+
+// sortedKeys returns the keys of a yaml map in order, or nothing if node is not a map
+func sortedKeys(node interface{}) []string {
+	m, _ := node.(map[interface{}]interface{})
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, fmt.Sprint(key))
+	}
+	sort.Strings(keys)
+	return keys
+}
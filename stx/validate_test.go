@@ -0,0 +1,228 @@
+package stx
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// problemStrings formats problems as "Path: Message" for comparison
+func problemStrings(problems []TemplateProblem) []string {
+	var formatted []string
+	for _, problem := range problems {
+		formatted = append(formatted, problem.Path+": "+problem.Message)
+	}
+	return formatted
+}
+
+func TestValidateTemplate(t *testing.T) {
+	tests := []struct {
+		name, template string
+		want           []string
+	}{
+		{
+			name: "valid references",
+			template: `
+Parameters:
+  Name: {Type: String}
+Conditions:
+  IsProd: {"Fn::Equals": [{Ref: Name}, prod]}
+Resources:
+  Bucket:
+    Type: AWS::S3::Bucket
+    Condition: IsProd
+    Properties:
+      BucketName: {"Fn::Sub": "${Name}-${AWS::Region}-${!Literal}"}
+  Policy:
+    Type: AWS::S3::BucketPolicy
+    DependsOn: [Bucket]
+    Properties:
+      Bucket: {Ref: Bucket}
+      PolicyDocument:
+        Statement:
+          - Effect: Allow
+            Resource: {"Fn::GetAtt": Bucket.Arn}
+            Condition: {Bool: {"aws:SecureTransport": "true"}}
+Outputs:
+  Arn:
+    Value: {"Fn::GetAtt": [Bucket, Arn]}
+  Url:
+    Value: {"Fn::Sub": ["https://${Domain}/${Bucket.DomainName}", {Domain: {Ref: Name}}]}
+`,
+		},
+		{
+			name: "Ref",
+			template: `
+Resources:
+  Bucket:
+    Type: AWS::S3::Bucket
+    Properties:
+      BucketName: {Ref: Missing}
+      Tags: [{Key: a, Value: {Ref: [a]}}]
+`,
+			want: []string{
+				"Resources.Bucket.Properties.BucketName.Ref: Ref to Missing, which is not a parameter or resource",
+				"Resources.Bucket.Properties.Tags[0].Value.Ref: Ref must be a string",
+			},
+		},
+		{
+			name: "Fn::GetAtt",
+			template: `
+Resources:
+  Bucket: {Type: AWS::S3::Bucket}
+Outputs:
+  String: {Value: {"Fn::GetAtt": Missing.Arn}}
+  List: {Value: {"Fn::GetAtt": [Missing, Arn]}}
+  Short: {Value: {"Fn::GetAtt": [Bucket]}}
+  Unnamed: {Value: {"Fn::GetAtt": [1, Arn]}}
+`,
+			want: []string{
+				"Outputs.List.Value.Fn::GetAtt: Fn::GetAtt of Missing, which is not a resource",
+				"Outputs.Short.Value.Fn::GetAtt: Fn::GetAtt takes a resource and an attribute",
+				"Outputs.String.Value.Fn::GetAtt: Fn::GetAtt of Missing, which is not a resource",
+				"Outputs.Unnamed.Value.Fn::GetAtt: Fn::GetAtt must name a resource",
+			},
+		},
+		{
+			name: "Fn::Sub",
+			template: `
+Resources:
+  Bucket: {Type: AWS::S3::Bucket}
+Outputs:
+  Undeclared: {Value: {"Fn::Sub": "${Missing}-${!Missing}"}}
+  Attribute: {Value: {"Fn::Sub": "${Missing.Arn}"}}
+  Variables: {Value: {"Fn::Sub": ["${Declared}-${Missing}", {Declared: a}]}}
+`,
+			want: []string{
+				"Outputs.Attribute.Value.Fn::Sub: Fn::Sub uses ${Missing.Arn}, but Missing is not a resource",
+				"Outputs.Undeclared.Value.Fn::Sub: Fn::Sub uses ${Missing}, which is not a parameter, resource, or variable",
+				"Outputs.Variables.Value.Fn::Sub: Fn::Sub uses ${Missing}, which is not a parameter, resource, or variable",
+			},
+		},
+		{
+			name: "DependsOn",
+			template: `
+Resources:
+  Bucket: {Type: AWS::S3::Bucket, DependsOn: Missing}
+  Queue: {Type: AWS::SQS::Queue, DependsOn: [Bucket, Other]}
+`,
+			want: []string{
+				"Resources.Bucket.DependsOn: DependsOn Missing, which is not a resource",
+				"Resources.Queue.DependsOn: DependsOn Other, which is not a resource",
+			},
+		},
+		{
+			name: "conditions",
+			template: `
+Conditions:
+  Used: {"Fn::Equals": [a, a]}
+  Unused: {"Fn::Equals": [a, b]}
+  Nested: {"Fn::Not": [{Condition: Used}]}
+Resources:
+  Bucket: {Type: AWS::S3::Bucket, Condition: Nested}
+  Queue: {Type: AWS::SQS::Queue, Condition: Undeclared}
+Outputs:
+  Name: {Value: {"Fn::If": [AlsoUndeclared, a, b]}}
+`,
+			want: []string{
+				"Resources.Queue.Condition: condition Undeclared is not declared in Conditions",
+				"Outputs.Name.Value.Fn::If: condition AlsoUndeclared is not declared in Conditions",
+				"Conditions.Unused: condition Unused is never used",
+			},
+		},
+		{
+			name: "Transform",
+			template: `
+Transform: AWS::Serverless-2016-10-31
+Resources:
+  Function:
+    Type: AWS::Serverless::Function
+    Properties:
+      Role: {"Fn::GetAtt": FunctionRole.Arn}
+      Environment: {Variables: {Alias: {Ref: FunctionAliaslive}, Url: {"Fn::Sub": "${ServerlessRestApi}"}}}
+`,
+		},
+		{
+			name: "no resources",
+			template: `
+Parameters:
+  Name: {Type: String}
+`,
+			want: []string{"Resources: template has no resources"},
+		},
+	}
+	for _, test := range tests {
+		problems, err := ValidateTemplate([]byte(test.template), MaxTemplateBodySize)
+		if err != nil {
+			t.Errorf("%s: %s", test.name, err)
+			continue
+		}
+		if got := problemStrings(problems); !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%s: got\n  %s\nwant\n  %s", test.name, strings.Join(got, "\n  "), strings.Join(test.want, "\n  "))
+		}
+	}
+}
+
+func TestValidateTemplateLimits(t *testing.T) {
+	section := func(name string, count int, format string) string {
+		var b strings.Builder
+		b.WriteString(name + ":\n")
+		for i := 0; i < count; i++ {
+			fmt.Fprintf(&b, "  "+format+"\n", i)
+		}
+		return b.String()
+	}
+
+	tests := []struct {
+		name, template string
+		maxSize        int
+		want           []string
+	}{
+		{
+			name:     "within the limits",
+			template: section("Resources", MaxResources, "R%d: {Type: AWS::SNS::Topic}") + section("Outputs", MaxOutputs, "O%d: {Value: a}") + section("Parameters", MaxParameters, "P%d: {Type: String}"),
+			maxSize:  MaxTemplateURLSize,
+		},
+		{
+			name:     "resources",
+			template: section("Resources", MaxResources+1, "R%d: {Type: AWS::SNS::Topic}"),
+			maxSize:  MaxTemplateURLSize,
+			want:     []string{"Resources: template has 501 resources, more than the limit of 500"},
+		},
+		{
+			name:     "outputs",
+			template: section("Resources", 1, "R%d: {Type: AWS::SNS::Topic}") + section("Outputs", MaxOutputs+1, "O%d: {Value: a}"),
+			maxSize:  MaxTemplateURLSize,
+			want:     []string{"Outputs: template has 201 outputs, more than the limit of 200"},
+		},
+		{
+			name:     "parameters",
+			template: section("Resources", 1, "R%d: {Type: AWS::SNS::Topic}") + section("Parameters", MaxParameters+1, "P%d: {Type: String}"),
+			maxSize:  MaxTemplateURLSize,
+			want:     []string{"Parameters: template has 201 parameters, more than the limit of 200"},
+		},
+		{
+			name:     "size",
+			template: section("Resources", 1, "R%d: {Type: AWS::SNS::Topic}"),
+			maxSize:  10,
+			want:     []string{": template is 41 bytes, more than the limit of 10"},
+		},
+	}
+	for _, test := range tests {
+		problems, err := ValidateTemplate([]byte(test.template), test.maxSize)
+		if err != nil {
+			t.Errorf("%s: %s", test.name, err)
+			continue
+		}
+		if got := problemStrings(problems); !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%s: got\n  %s\nwant\n  %s", test.name, strings.Join(got, "\n  "), strings.Join(test.want, "\n  "))
+		}
+	}
+}
+
+func TestValidateTemplateInvalidYaml(t *testing.T) {
+	if _, err := ValidateTemplate([]byte("Resources: [a"), MaxTemplateBodySize); err == nil {
+		t.Error("expected an error for invalid yaml")
+	}
+}
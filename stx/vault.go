@@ -0,0 +1,64 @@
+package stx
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+// awsVaultProvider gets credentials by executing aws-vault, optionally pulling MFA codes from ykman
+type awsVaultProvider struct {
+	sourceProfile, ykmanProfile string
+}
+
+func newAwsVaultProvider(config *Config) CredentialProvider {
+	return &awsVaultProvider{sourceProfile: config.Auth.AwsVault.SourceProfile, ykmanProfile: config.Auth.Ykman.Profile}
+}
+
+// EnsureSession is used to prompt for MFA if aws-vault session has expired
+func (p *awsVaultProvider) EnsureSession() error {
+	_, existingVault := os.LookupEnv("AWS_VAULT")
+	if existingVault {
+		return errors.New("Cannot run in nested aws-vault session")
+	}
+
+	sessionsOut, sessionsErr := exec.Command("aws-vault", "list", "--sessions").Output()
+	if sessionsErr != nil {
+		return fmt.Errorf("Could not list aws-vault sessions: %s", sessionsErr)
+	}
+
+	var mfa string
+	if len(sessionsOut) < 1 {
+		if len(p.ykmanProfile) > 1 {
+			ykmanOutput, ykmanErr := exec.Command("ykman", "oath", "code", "-s", p.ykmanProfile).Output()
+			if ykmanErr != nil {
+				return fmt.Errorf("ykman error: %s", ykmanErr)
+			}
+			mfa = strings.TrimSpace(string(ykmanOutput))
+			fmt.Println("Pulled MFA from ykman profile ", p.ykmanProfile)
+		} else {
+			fmt.Print("MFA: ")
+			fmt.Scanln(&mfa)
+		}
+		awsVaultExecErr := exec.Command("aws-vault", "exec", "-t", mfa, p.sourceProfile).Run()
+		if awsVaultExecErr != nil {
+			return fmt.Errorf("aws-vault error: %s", awsVaultExecErr)
+		}
+	}
+	return nil
+}
+
+// Credentials executes aws-vault to get credentials for the profile
+func (p *awsVaultProvider) Credentials(profile string) (AwsCredentials, error) {
+	var credentials AwsCredentials
+	execOut, execErr := exec.Command("aws-vault", "exec", "--json", profile).Output()
+	if execErr != nil {
+		return credentials, fmt.Errorf("aws-vault error: %s", execErr)
+	}
+
+	unmarshalErr := json.Unmarshal(execOut, &credentials)
+	return credentials, unmarshalErr
+}